@@ -0,0 +1,29 @@
+package kafka
+
+import "github.com/Shopify/sarama"
+
+// Ack : Marks the message as processed, so its offset is committed, for
+// handlers run with Config.ManualAck that only consider a message done once
+// e.g. an asynchronous write completed. On the workers of a partition, of
+// Config.OrderedByKey or of WithConcurrency, a message that is never acked
+// holds back the commits of its partition, and it and the messages after it
+// are delivered again after a restart or rebalance. Without
+// Config.ManualAck, messages are committed once their handler returns nil
+// and Ack only commits them earlier. Safe to call more than once and from
+// any goroutine. Does nothing for messages of a batch handler.
+func (m Message) Ack() {
+	if m.ack != nil {
+		m.ack()
+	}
+}
+
+// MarkOffset : Marks a message as processed, as with msg.Ack. A message
+// that didn't come from Consume, e.g. one rebuilt from its topic, partition
+// and offset, is marked on the consumer group directly.
+func (kc *Client) MarkOffset(msg Message) {
+	if msg.ack != nil {
+		msg.ack()
+		return
+	}
+	kc.markOffset(&sarama.ConsumerMessage{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+}
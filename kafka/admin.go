@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+// Create a cluster admin sharing the brokers and TLS config of the client.
+// Admin requests need a newer protocol than the sarama default, so 1.0.0 is
+// assumed when no version is configured. Callers must close it.
+func (kc *Client) newClusterAdmin() (sarama.ClusterAdmin, error) {
+	config, err := kc.adminConfig()
+	if err != nil {
+		return nil, err
+	}
+	return sarama.NewClusterAdmin(kc.brokers, config)
+}
+
+func (kc *Client) adminConfig() (*sarama.Config, error) {
+	version, err := kc.config.kafkaVersion(sarama.V1_0_0_0)
+	if err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Net.TLS.Config = kc.tlsConfig
+	config.Net.TLS.Enable = true
+	kc.config.applySASL(config)
+	config.Version = version
+	config.MetricRegistry = kc.config.MetricRegistry
+	return config, nil
+}
+
+// ListTopics : Lists the topics on the cluster by their logical name, with
+// the configured prefix removed. Topics outside of the configured prefixes
+// are left out.
+func (kc *Client) ListTopics() ([]string, error) {
+	admin, err := kc.newClusterAdmin()
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	details, err := admin.ListTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make([]string, 0, len(details))
+	for name := range details {
+		if logical, ok := kc.config.logicalTopic(name); ok {
+			topics = append(topics, logical)
+		}
+	}
+	sort.Strings(topics)
+
+	return topics, nil
+}
+
+// CommittedOffsets : The offsets committed by our consumer group for every
+// partition of the consumed topics. Partitions the group has never
+// committed for are reported as -1.
+func (kc *Client) CommittedOffsets() (map[string]map[int32]int64, error) {
+	admin, err := kc.newClusterAdmin()
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	return committedOffsets(admin, kc.config.group(), kc.subscriptions())
+}
+
+// DeleteConsumerGroup : Deletes a consumer group and its committed
+// offsets, e.g. the groups left behind by Config.EphemeralGroup. The group
+// must have no members left.
+func (kc *Client) DeleteConsumerGroup(group string) error {
+	admin, err := kc.newClusterAdmin()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	return admin.DeleteConsumerGroup(group)
+}
+
+// GroupLag : The lag of any consumer group, e.g. the archiver's, on every
+// partition of the given topics, by their name without prefix. Partitions
+// the group has never committed for report their whole high watermark.
+func (kc *Client) GroupLag(group string, topics []string) (map[string]map[int32]int64, error) {
+	config, err := kc.adminConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(kc.brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	// Closes the client too
+	defer admin.Close()
+
+	groups, err := admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 || groups[0].State == "Dead" {
+		return nil, fmt.Errorf("kafka: consumer group %s does not exist", group)
+	}
+
+	full := make([]string, len(topics))
+	for i, t := range topics {
+		full[i] = kc.config.topic(t)
+	}
+	offsets, err := committedOffsets(admin, group, full)
+	if err != nil {
+		return nil, err
+	}
+
+	for topic, partitions := range offsets {
+		for id, committed := range partitions {
+			hwm, err := client.GetOffset(topic, id, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("kafka: high watermark of %s/%d: %v", topic, id, err)
+			}
+			if committed < 0 {
+				committed = 0
+			}
+			partitions[id] = hwm - committed
+		}
+	}
+	return offsets, nil
+}
+
+// The offsets committed by group for every partition of topics, -1 where
+// nothing was committed
+func committedOffsets(admin sarama.ClusterAdmin, group string, topics []string) (map[string]map[int32]int64, error) {
+	metadata, err := admin.DescribeTopics(topics)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make(map[string][]int32, len(metadata))
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("kafka: topic %s: %v", topic.Name, topic.Err)
+		}
+		for _, p := range topic.Partitions {
+			partitions[topic.Name] = append(partitions[topic.Name], p.ID)
+		}
+	}
+
+	resp, err := admin.ListConsumerGroupOffsets(group, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]map[int32]int64, len(partitions))
+	for topic, ids := range partitions {
+		offsets[topic] = make(map[int32]int64, len(ids))
+		for _, id := range ids {
+			offsets[topic][id] = -1
+
+			block := resp.GetBlock(topic, id)
+			if block == nil {
+				continue
+			}
+			if block.Err != sarama.ErrNoError {
+				return nil, fmt.Errorf("kafka: offset for %s/%d: %v", topic, id, block.Err)
+			}
+			offsets[topic][id] = block.Offset
+		}
+	}
+
+	return offsets, nil
+}
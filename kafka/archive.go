@@ -0,0 +1,214 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ArchiveConfig : Configuration for archiving raw events to S3
+type ArchiveConfig struct {
+	Bucket        string        `env:"KAFKA_ARCHIVE_BUCKET,required"`
+	Prefix        string        `env:"KAFKA_ARCHIVE_PREFIX"`
+	Region        string        `env:"KAFKA_ARCHIVE_REGION,default=us-east-1"`
+	ConsumerGroup string        `env:"KAFKA_ARCHIVE_CONSUMER_GROUP,default=heroku-kafka-demo-go-archiver"`
+	MaxBatchBytes int           `env:"KAFKA_ARCHIVE_MAX_BATCH_BYTES,default=5242880"`
+	FlushInterval time.Duration `env:"KAFKA_ARCHIVE_FLUSH_INTERVAL,default=1m"`
+}
+
+// Validate : Checks the configuration, as a zero MaxBatchBytes or
+// FlushInterval (e.g. in a literal ArchiveConfig) would upload every
+// message on its own or never flush
+func (c ArchiveConfig) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("KAFKA_ARCHIVE_BUCKET must be set")
+	}
+	if c.MaxBatchBytes <= 0 {
+		return fmt.Errorf("KAFKA_ARCHIVE_MAX_BATCH_BYTES must be positive, got %d", c.MaxBatchBytes)
+	}
+	if c.FlushInterval <= 0 {
+		return fmt.Errorf("KAFKA_ARCHIVE_FLUSH_INTERVAL must be positive, got %s", c.FlushInterval)
+	}
+	return nil
+}
+
+// What the archiver uploads with, an *s3.S3
+type objectPutter interface {
+	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+}
+
+// A message as archived. The value and key are kept as raw bytes, base64
+// in JSON, since they needn't be valid UTF-8.
+type archiveRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Key       []byte            `json:"key,omitempty"`
+	Value     []byte            `json:"value"`
+	Headers   map[string][]byte `json:"headers,omitempty"`
+}
+
+type archiver struct {
+	cfg      ArchiveConfig
+	consumer GroupConsumer
+	uploader objectPutter
+	logger   Logger
+
+	batch bytes.Buffer
+	// The last message of the batch on every partition, marked once uploaded
+	last map[topicPartition]*sarama.ConsumerMessage
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// StartArchiver : Runs a second consumer group over the same topics that
+// uploads the raw messages to S3 as newline delimited JSON. A batch is
+// uploaded once it reaches MaxBatchBytes or FlushInterval has elapsed, and
+// its offsets are only committed after the upload succeeded. While a full
+// batch fails to upload, no more messages are read, so memory stays
+// bounded by MaxBatchBytes. Fails if cfg is invalid.
+func (kc *Client) StartArchiver(cfg ArchiveConfig) error {
+	if kc.archiver != nil {
+		return fmt.Errorf("kafka: archiver already started")
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(cfg.Region)})
+	if err != nil {
+		return err
+	}
+
+	consumer, err := kc.config.createKafkaConsumer(kc.brokers, kc.tlsConfig, kc.config.prefixGroup(cfg.ConsumerGroup), kc.config.topics())
+	if err != nil {
+		return err
+	}
+
+	a := &archiver{
+		cfg:      cfg,
+		consumer: consumer,
+		uploader: s3.New(sess),
+		logger:   kc.logger(),
+		last:     make(map[topicPartition]*sarama.ConsumerMessage),
+		stop:     make(chan struct{}),
+	}
+	a.stopped.Add(1)
+	go a.run()
+
+	kc.archiver = a
+	return nil
+}
+
+func (a *archiver) run() {
+	defer a.stopped.Done()
+
+	ticker := time.NewTicker(a.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		// A full batch that failed to upload holds back consumption until
+		// the next flush gets it through
+		messages := a.consumer.Messages()
+		if a.batch.Len() >= a.cfg.MaxBatchBytes {
+			messages = nil
+		}
+
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			a.add(msg)
+			if a.batch.Len() >= a.cfg.MaxBatchBytes {
+				a.flush()
+			}
+		case err := <-a.consumer.Errors():
+			if err != nil {
+				a.logger.Error("archiver error", errorFields(err))
+			}
+		case <-a.consumer.Notifications():
+		case <-ticker.C:
+			a.flush()
+		case <-a.stop:
+			a.flush()
+			return
+		}
+	}
+}
+
+func (a *archiver) add(msg *sarama.ConsumerMessage) {
+	rec := archiveRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		if rec.Headers == nil {
+			rec.Headers = make(map[string][]byte, len(msg.Headers))
+		}
+		rec.Headers[string(h.Key)] = h.Value
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		a.logger.Error("cannot archive message", messageFields(msg, err))
+		return
+	}
+
+	a.batch.Write(line)
+	a.batch.WriteByte('\n')
+	a.last[topicPartition{msg.Topic, msg.Partition}] = msg
+}
+
+// Upload the current batch and commit its offsets. On failure the batch is
+// kept and the upload is retried on the next flush.
+func (a *archiver) flush() {
+	if a.batch.Len() == 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s%s.ndjson", a.cfg.Prefix, time.Now().UTC().Format("2006/01/02/150405.000000000"))
+	_, err := a.uploader.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(a.cfg.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(a.batch.Bytes()),
+	})
+	if err != nil {
+		fields := Fields{"bucket": a.cfg.Bucket, "key": key, "error": err}
+		if a.batch.Len() >= a.cfg.MaxBatchBytes {
+			fields["paused"] = true
+		}
+		a.logger.Error("failed to upload archive batch", fields)
+		return
+	}
+
+	for _, msg := range a.last {
+		a.consumer.MarkOffset(msg, "")
+	}
+	a.last = make(map[topicPartition]*sarama.ConsumerMessage)
+	a.batch.Reset()
+}
+
+// Upload whatever is left and close the archive consumer
+func (a *archiver) close() error {
+	close(a.stop)
+	a.stopped.Wait()
+	return a.consumer.Close()
+}
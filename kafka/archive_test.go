@@ -0,0 +1,97 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Uploader failing every upload, counting the attempts
+type failingPutter struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *failingPutter) PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return nil, errors.New("s3 unavailable")
+}
+
+func TestArchiveKeepsBinaryValues(t *testing.T) {
+	a := &archiver{logger: discardLogger{}, last: make(map[topicPartition]*sarama.ConsumerMessage)}
+	value := []byte{0xff, 0x00, 0xfe, 'a'}
+	a.add(&sarama.ConsumerMessage{Topic: "orders", Value: value})
+
+	var rec archiveRecord
+	if err := json.Unmarshal(bytes.TrimSpace(a.batch.Bytes()), &rec); err != nil {
+		t.Fatalf("archived line isn't JSON: %v", err)
+	}
+	if !bytes.Equal(rec.Value, value) {
+		t.Fatalf("archived value is %x, want %x", rec.Value, value)
+	}
+}
+
+func TestArchiveStopsConsumingWhileTheUploadFails(t *testing.T) {
+	consumer := newMockConsumer()
+	uploader := &failingPutter{}
+	a := &archiver{
+		cfg:      ArchiveConfig{MaxBatchBytes: 1, FlushInterval: time.Hour},
+		consumer: consumer,
+		uploader: uploader,
+		logger:   discardLogger{},
+		last:     make(map[topicPartition]*sarama.ConsumerMessage),
+		stop:     make(chan struct{}),
+	}
+	for i := 0; i < 5; i++ {
+		consumer.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: int64(i), Value: []byte("{}")}
+	}
+
+	a.stopped.Add(1)
+	go a.run()
+	time.Sleep(50 * time.Millisecond)
+	close(a.stop)
+	a.stopped.Wait()
+
+	// The first message fills the batch, the rest wait in the consumer
+	if left := len(consumer.messages); left != 4 {
+		t.Fatalf("%d messages left unread, want 4", left)
+	}
+	if marked := consumer.markedOffsets(); len(marked) != 0 {
+		t.Fatalf("marked %v without a successful upload", marked)
+	}
+}
+
+func TestStartArchiverRejectsInvalidConfig(t *testing.T) {
+	valid := ArchiveConfig{Bucket: "events", MaxBatchBytes: 1 << 20, FlushInterval: time.Minute}
+	tests := map[string]func(*ArchiveConfig){
+		"no bucket":           func(c *ArchiveConfig) { c.Bucket = "" },
+		"zero batch size":     func(c *ArchiveConfig) { c.MaxBatchBytes = 0 },
+		"zero interval":       func(c *ArchiveConfig) { c.FlushInterval = 0 },
+		"negative interval":   func(c *ArchiveConfig) { c.FlushInterval = -time.Second },
+		"negative batch size": func(c *ArchiveConfig) { c.MaxBatchBytes = -1 },
+	}
+	for name, invalidate := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid
+			invalidate(&cfg)
+			kc := &Client{config: &Config{}}
+			if err := kc.StartArchiver(cfg); err == nil {
+				t.Fatal("started with an invalid config")
+			}
+			if kc.archiver != nil {
+				t.Fatal("archiver set despite the invalid config")
+			}
+		})
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// BatchHandler : Processes a batch of consumed messages at once
+type BatchHandler func(ctx context.Context, msgs []Message) error
+
+// ConsumeBatch : Like Consume, but collects up to Config.BatchSize
+// messages, or whatever arrived within Config.BatchTimeout of the first
+// one, and runs the handler once for the whole batch. Batches are handled
+// one at a time, in order. Offsets of a batch are committed only once the
+// handler succeeded; failures are retried and resolved as in Process,
+// applied to every message of the batch. A batch that is still being
+// collected when ctx is cancelled is left uncommitted and delivered again.
+// Config.MaxMessages is honoured as in Consume, the last batch being cut
+// short if needed. With Config.RetryTopics, every message of a failed
+// batch goes to the retry topic, and is handled again on its own, as a
+// batch of one. ConsumeBatch returns nil once Shutdown is called, after
+// the batch being handled, and doesn't reconnect from then on.
+func (kc *Client) ConsumeBatch(ctx context.Context, handler BatchHandler) error {
+	if kc.config.RetryTopics {
+		retry := func(ctx context.Context, msg Message) error {
+			return handler(ctx, []Message{msg})
+		}
+		if err := kc.startRetryConsumer(ctx, retry); err != nil {
+			return err
+		}
+	}
+
+	batch := make([]*sarama.ConsumerMessage, 0, kc.config.BatchSize)
+	var timeout <-chan time.Time
+	flush := func() {
+		if len(batch) > 0 {
+			kc.processBatch(ctx, batch, handler)
+		}
+		batch = batch[:0]
+		timeout = nil
+	}
+
+	consumed := 0
+	consumer := kc.currentConsumer()
+	messages := consumer.Messages()
+	kc.watchCurrentConsumer(consumer)
+	for {
+		if err := kc.waitUnpaused(ctx); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-kc.done:
+			// Shutdown drains the batch being handled, one still being
+			// collected is left uncommitted and delivered again
+			return nil
+		case <-timeout:
+			flush()
+		case msg, ok := <-messages:
+			if !ok {
+				if c := kc.currentConsumer(); c != consumer {
+					// Replaced by Subscribe, Unsubscribe or the idle check, which committed
+					// what was marked
+					batch = batch[:0]
+					timeout = nil
+					consumer, messages = c, c.Messages()
+					kc.watchCurrentConsumer(consumer)
+					continue
+				}
+				if kc.shuttingDown() {
+					// Closed by Shutdown
+					return nil
+				}
+				kc.logger().Warn("consumer closed its messages channel", nil)
+				// The consumer can't commit the offsets it was holding, so
+				// the batch is delivered again by the next one
+				batch = batch[:0]
+				timeout = nil
+				if !kc.config.AutoReconnect {
+					return ErrConsumerClosed
+				}
+				if err := kc.reconnectConsumer(ctx); err != nil {
+					if err == errShuttingDown {
+						return nil
+					}
+					return err
+				}
+				consumer = kc.currentConsumer()
+				messages = consumer.Messages()
+				kc.watchCurrentConsumer(consumer)
+				continue
+			}
+			if msg == nil {
+				continue
+			}
+			kc.touch(msg)
+
+			batch = append(batch, msg)
+			consumed++
+			if kc.config.MaxMessages > 0 && consumed >= kc.config.MaxMessages {
+				flush()
+				kc.logger().Info("consumed the maximum of messages, stopping", Fields{"messages": consumed})
+				return nil
+			}
+			if len(batch) == 1 {
+				timeout = time.After(kc.config.BatchTimeout)
+			}
+			if len(batch) >= kc.config.BatchSize {
+				flush()
+			}
+		}
+	}
+}
+
+func (kc *Client) processBatch(ctx context.Context, msgs []*sarama.ConsumerMessage, handler BatchHandler) {
+	// Left uncommitted for redelivery once Shutdown started
+	if !kc.startInflight() {
+		return
+	}
+	defer kc.inflight.Done()
+	kc.handlerStarted()
+	defer kc.handlerFinished()
+
+	// Messages that aren't handed to the handler, committed along with the
+	// batch so their offsets don't run ahead of a failed message
+	var skipped []*sarama.ConsumerMessage
+	skip := func(msg *sarama.ConsumerMessage) { skipped = append(skipped, msg) }
+	markSkipped := func() {
+		for _, msg := range skipped {
+			if !kc.isBlocked(msg.Topic, msg.Partition) {
+				kc.markOffset(msg)
+			}
+		}
+	}
+
+	var raw []*sarama.ConsumerMessage
+	var batch []Message
+	for _, msg := range msgs {
+		if kc.isBlocked(msg.Topic, msg.Partition) {
+			continue
+		}
+		if kc.skipDuplicate(msg) {
+			skip(msg)
+			continue
+		}
+
+		if kc.skipExpired(msg, skip) {
+			continue
+		}
+
+		if !kc.checkSchemaVersion(msg, skip) {
+			continue
+		}
+
+		value, ok := kc.decrypt(msg, skip)
+		if !ok {
+			continue
+		}
+		value, ok = kc.decode(msg, value, skip)
+		if !ok || !kc.validateSchema(msg, value, skip) {
+			continue
+		}
+
+		message := newMessage(msg)
+		message.Value = string(value)
+		message.codec = kc.codec(message.contentType)
+		message.logicalTopic, _ = kc.config.logicalTopic(msg.Topic)
+		message.log = kc.logger()
+		kc.logReceipt(msg, message.Metadata.ReceivedAt)
+		messagesConsumed.WithLabelValues(msg.Topic, kc.keyLabel(msg.Key)).Inc()
+
+		raw = append(raw, msg)
+		batch = append(batch, message)
+	}
+	if len(batch) == 0 {
+		markSkipped()
+		return
+	}
+
+	start := time.Now()
+	err := handler(ctx, batch)
+	retries := 0
+	for ; err != nil && !isPermanent(err) && retries < kc.config.MaxRetries && kc.waitRetry(ctx, retries); retries++ {
+		err = handler(ctx, batch)
+	}
+	elapsed := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		fields := messageFields(raw[0], err)
+		fields["messages"] = len(raw)
+		kc.logger().Info("consumption stopped while handling batch, leaving it for redelivery", fields)
+		return
+	}
+	kc.latency.Update(int64(elapsed))
+	for _, msg := range raw {
+		kc.emitProcessed(msg, elapsed, err)
+	}
+	if err == nil {
+		for _, msg := range raw {
+			kc.rememberHandled(msg)
+			kc.markOffset(msg)
+		}
+		markSkipped()
+		return
+	}
+
+	fields := messageFields(raw[0], err)
+	fields["messages"] = len(raw)
+	switch kc.config.OnPermanentError {
+	case PermanentErrorBlock:
+		kc.logger().Error("failed to process batch, blocking partitions", fields)
+		for _, msg := range raw {
+			kc.block(msg.Topic, msg.Partition)
+		}
+	case PermanentErrorCrash:
+		fatal(kc.logger(), "failed to process batch", fields)
+	default:
+		retry := kc.config.RetryTopics && !isPermanent(err)
+		if retry {
+			kc.logger().Warn("failed to process batch, retrying", fields)
+		} else {
+			kc.logger().Error("failed to process batch, skipping", fields)
+		}
+		for _, msg := range raw {
+			if kc.isBlocked(msg.Topic, msg.Partition) {
+				continue
+			}
+			if round := kc.retryRound(msg); retry && round < kc.config.retryRounds() {
+				if retryErr := kc.scheduleRetry(msg, round+1); retryErr != nil {
+					// Committing later offsets would lose this message
+					kc.logger().Error("failed to schedule retry of message, blocking partition", messageFields(msg, retryErr))
+					kc.block(msg.Topic, msg.Partition)
+					continue
+				}
+				kc.markOffset(msg)
+				continue
+			}
+			if kc.config.deadLettering() {
+				if dltErr := kc.deadLetter(msg, err, retries); dltErr != nil {
+					// Committing later offsets would lose this message
+					kc.logger().Error("failed to dead-letter message, blocking partition", messageFields(msg, dltErr))
+					kc.block(msg.Topic, msg.Partition)
+					continue
+				}
+			}
+			kc.markOffset(msg)
+		}
+		markSkipped()
+	}
+}
@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestFailedBatchGoesToTheRetryTopics(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.DLTHeaderPrefix = "x-"
+	kc.config.RetryTopics = true
+	kc.config.MaxRetryRounds = 3
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	msgs := []*sarama.ConsumerMessage{
+		{Topic: "orders", Offset: 1, Value: []byte("{}")},
+		{Topic: "orders", Offset: 2, Value: []byte("{}")},
+	}
+	kc.processBatch(context.Background(), msgs, func(context.Context, []Message) error {
+		return errors.New("bulk write failed")
+	})
+
+	retried := producer.published()
+	if len(retried) != 2 {
+		t.Fatalf("published %d messages, want a retry of each", len(retried))
+	}
+	for _, r := range retried {
+		if r.topic != "orders.retry" {
+			t.Fatalf("retry published to %s, want orders.retry", r.topic)
+		}
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1, 2}) {
+		t.Fatalf("marked %v, want [1 2]", marked)
+	}
+}
+
+func TestShutdownStopsConsumeBatch(t *testing.T) {
+	consumer := newMockConsumer()
+	kc, _ := newConnectedTestClient(t)
+	kc.Consumer = consumer
+	kc.config.BatchSize = 1
+	kc.config.BatchTimeout = time.Second
+	// Shutdown closes the consumer, which mustn't be taken for a failure
+	kc.config.AutoReconnect = true
+	kc.config.ReconnectBackoff = time.Millisecond
+
+	started := make(chan struct{})
+	returned := make(chan error, 1)
+	go func() {
+		returned <- kc.ConsumeBatch(context.Background(), func(context.Context, []Message) error {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		})
+	}()
+	consumer.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: 4}
+	<-started
+
+	if err := kc.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+	// The batch being handled was drained by Shutdown
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{4}) {
+		t.Fatalf("marked %v, want [4]", marked)
+	}
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatalf("ConsumeBatch returned %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeBatch didn't return after Shutdown")
+	}
+	if kc.Consumer != consumer {
+		t.Fatal("ConsumeBatch reconnected after Shutdown")
+	}
+}
@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+var producerLinger = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "kafka",
+	Name:      "producer_linger_ms",
+	Help:      "How long the async producer waits to fill a batch, from KAFKA_FLUSH_FREQUENCY.",
+})
+
+var producerMaxOpenRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "kafka",
+	Name:      "producer_max_open_requests",
+	Help:      "Requests the producers send a broker before waiting for a response.",
+})
+
+// Exports sarama's count of requests in flight, recorded in
+// Config.MetricRegistry. The batch sizes are exported with the rest of
+// the registry by saramaCollector.
+type producerInflightCollector struct {
+	inflight *prometheus.Desc
+
+	mu       sync.Mutex
+	registry metrics.Registry
+}
+
+var producerInflight = &producerInflightCollector{
+	inflight: prometheus.NewDesc("kafka_producer_inflight_requests",
+		"Requests awaiting a broker response on the connections sharing the metric registry, the consumer's fetches included.", nil, nil),
+}
+
+func init() {
+	prometheus.MustRegister(producerLinger, producerMaxOpenRequests, producerInflight)
+}
+
+// Report the producer settings and requests in flight of the client that
+// connected last
+func (kc *Config) exportProducerMetrics() {
+	producerLinger.Set(float64(kc.FlushFrequency / time.Millisecond))
+	config := sarama.NewConfig()
+	kc.applyProducerRetry(config)
+	producerMaxOpenRequests.Set(float64(config.Net.MaxOpenRequests))
+
+	producerInflight.mu.Lock()
+	producerInflight.registry = kc.MetricRegistry
+	producerInflight.mu.Unlock()
+}
+
+// Describe : Implements prometheus.Collector
+func (c *producerInflightCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inflight
+}
+
+// Collect : Implements prometheus.Collector
+func (c *producerInflightCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	registry := c.registry
+	c.mu.Unlock()
+	if registry == nil {
+		return
+	}
+
+	if n, ok := registry.Get("requests-in-flight").(metrics.Counter); ok {
+		ch <- prometheus.MustNewConstMetric(c.inflight, prometheus.GaugeValue, float64(n.Count()))
+	}
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Delivery reports of the async producer attributed to one broker, with
+// Config.Debug
+type brokerDeliveries struct {
+	Delivered   int64     `json:"delivered"`
+	Failed      int64     `json:"failed"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+// Log which broker led the partition of a delivered or failed message and
+// count it against that broker, so produce errors can be traced to a flaky
+// broker. The leader is looked up in the cluster metadata once the report
+// comes in, so it can be off if leadership moved in between. Does nothing
+// unless Config.Debug is set.
+func (kc *Client) recordDelivery(msg *sarama.ProducerMessage, err error) {
+	if !kc.config.Debug {
+		return
+	}
+
+	kc.deliveriesMu.Lock()
+	defer kc.deliveriesMu.Unlock()
+
+	broker := kc.partitionLeader(msg.Topic, msg.Partition)
+	if err != nil {
+		kc.logger().Debug("failed delivery", Fields{"topic": msg.Topic, "partition": msg.Partition, "broker": broker, "error": err})
+	} else {
+		kc.logger().Debug("delivered", Fields{"topic": msg.Topic, "partition": msg.Partition, "offset": msg.Offset, "broker": broker})
+	}
+
+	if kc.deliveries == nil {
+		kc.deliveries = make(map[string]*brokerDeliveries)
+	}
+	d := kc.deliveries[broker]
+	if d == nil {
+		d = &brokerDeliveries{}
+		kc.deliveries[broker] = d
+	}
+	if err != nil {
+		d.Failed++
+		d.LastError = err.Error()
+		d.LastErrorAt = time.Now()
+	} else {
+		d.Delivered++
+	}
+}
+
+// The leader of a partition as "<id> (<addr>)", or "unknown". Must be
+// called with deliveriesMu held.
+func (kc *Client) partitionLeader(topic string, partition int32) string {
+	if kc.metadataClosed {
+		return "unknown"
+	}
+	client, err := kc.metadataClient()
+	if err != nil {
+		kc.logger().Warn("cannot look up partition leaders", errorFields(err))
+		return "unknown"
+	}
+
+	b, err := client.Leader(topic, partition)
+	if err != nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d (%s)", b.ID(), b.Addr())
+}
+
+var errMetadataClosed = errors.New("kafka: client is shut down")
+
+// The metadata client shared by the delivery reports and the health
+// checks, created on first use. Must be called with deliveriesMu held.
+func (kc *Client) metadataClient() (sarama.Client, error) {
+	if kc.metadata != nil {
+		return kc.metadata, nil
+	}
+	if kc.metadataClosed {
+		return nil, errMetadataClosed
+	}
+
+	config, err := kc.adminConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(kc.brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	kc.metadata = client
+	return client, nil
+}
+
+// Snapshot of the delivery reports by broker, for the debug endpoint
+func (kc *Client) brokerDeliveries() map[string]brokerDeliveries {
+	kc.deliveriesMu.Lock()
+	defer kc.deliveriesMu.Unlock()
+
+	snapshot := make(map[string]brokerDeliveries, len(kc.deliveries))
+	for broker, d := range kc.deliveries {
+		snapshot[broker] = *d
+	}
+	return snapshot
+}
+
+// Close the metadata client used by recordDelivery and the health checks,
+// if any. Later reports are attributed to an unknown broker.
+func (kc *Client) closeMetadata() error {
+	kc.deliveriesMu.Lock()
+	defer kc.deliveriesMu.Unlock()
+
+	kc.metadataClosed = true
+	if kc.metadata == nil {
+		return nil
+	}
+	err := kc.metadata.Close()
+	kc.metadata = nil
+	return err
+}
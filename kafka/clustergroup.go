@@ -0,0 +1,97 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"time"
+
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+// Everything depending on sarama-cluster, used with
+// KAFKA_NATIVE_CONSUMER_GROUP=false only. Removing that option, this file
+// and its require in go.mod leaves the package on sarama alone.
+
+// Connect a sarama-cluster consumer
+func (kc *Config) createClusterConsumer(brokers []string, tc *tls.Config, group string, topics []string) (GroupConsumer, error) {
+	config := cluster.NewConfig()
+	if err := kc.applyConsumerConfig(&config.Config, tc, config.Version); err != nil {
+		return nil, err
+	}
+	config.Group.PartitionStrategy = cluster.StrategyRoundRobin
+	if kc.PartitionStrategy == PartitionStrategyRange {
+		config.Group.PartitionStrategy = cluster.StrategyRange
+	}
+	config.Group.Return.Notifications = true
+	config.Group.Session.Timeout = kc.SessionTimeout
+	config.Consumer.Offsets.CommitInterval = time.Second
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	consumer, err := cluster.NewConsumer(brokers, group, topics, config)
+	if err != nil {
+		return nil, err
+	}
+	return newClusterConsumer(consumer), nil
+}
+
+// Adapts the sarama-cluster consumer to GroupConsumer, translating its
+// notifications and commit errors to the package's own types
+type clusterConsumer struct {
+	*cluster.Consumer
+
+	notifications chan *Notification
+	errors        chan error
+}
+
+func newClusterConsumer(consumer *cluster.Consumer) *clusterConsumer {
+	c := &clusterConsumer{
+		Consumer:      consumer,
+		notifications: make(chan *Notification),
+		errors:        make(chan error),
+	}
+	go c.forwardNotifications(consumer.Notifications())
+	go c.forwardErrors(consumer.Errors())
+	return c
+}
+
+// Both channels must be drained, as sarama-cluster blocks until they are,
+// and close once the consumer is closed
+func (c *clusterConsumer) forwardNotifications(in <-chan *cluster.Notification) {
+	defer close(c.notifications)
+	for n := range in {
+		if n == nil {
+			continue
+		}
+		c.notifications <- &Notification{
+			Type:     clusterNotificationTypes[n.Type],
+			Claimed:  n.Claimed,
+			Released: n.Released,
+			Current:  n.Current,
+		}
+	}
+}
+
+func (c *clusterConsumer) forwardErrors(in <-chan error) {
+	defer close(c.errors)
+	for err := range in {
+		if cerr, ok := err.(*cluster.Error); ok && cerr.Ctx == "commit" {
+			err = &commitError{cerr}
+		}
+		c.errors <- err
+	}
+}
+
+var clusterNotificationTypes = map[cluster.NotificationType]NotificationType{
+	cluster.RebalanceStart: RebalanceStart,
+	cluster.RebalanceOK:    RebalanceOK,
+	cluster.RebalanceError: RebalanceError,
+}
+
+func (c *clusterConsumer) Notifications() <-chan *Notification {
+	return c.notifications
+}
+
+func (c *clusterConsumer) Errors() <-chan error {
+	return c.errors
+}
@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+func TestClusterNotificationsAreTranslated(t *testing.T) {
+	c := &clusterConsumer{notifications: make(chan *Notification)}
+	in := make(chan *cluster.Notification, 3)
+	in <- &cluster.Notification{Type: cluster.RebalanceStart, Current: map[string][]int32{"orders": {0, 1}}}
+	in <- nil
+	in <- &cluster.Notification{Type: cluster.RebalanceOK, Claimed: map[string][]int32{"orders": {2}}, Current: map[string][]int32{"orders": {2}}}
+	close(in)
+	go c.forwardNotifications(in)
+
+	var got []*Notification
+	for n := range c.Notifications() {
+		got = append(got, n)
+	}
+	want := []*Notification{
+		{Type: RebalanceStart, Current: map[string][]int32{"orders": {0, 1}}},
+		{Type: RebalanceOK, Claimed: map[string][]int32{"orders": {2}}, Current: map[string][]int32{"orders": {2}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("notifications %+v, want %+v", got, want)
+	}
+}
+
+func TestClusterCommitErrorsAreTranslated(t *testing.T) {
+	c := &clusterConsumer{errors: make(chan error)}
+	other := errors.New("kafka: broker gone")
+	in := make(chan error, 2)
+	in <- &cluster.Error{Ctx: "commit"}
+	in <- other
+	close(in)
+	go c.forwardErrors(in)
+
+	first := <-c.Errors()
+	if _, ok := first.(*commitError); !ok {
+		t.Fatalf("commit error forwarded as %T, want *commitError", first)
+	}
+	if err := <-c.Errors(); err != other {
+		t.Fatalf("forwarded %v, want the error as is", err)
+	}
+	if _, ok := <-c.Errors(); ok {
+		t.Fatal("errors still open after the consumer's closed")
+	}
+}
+
+func TestNotificationTypeNames(t *testing.T) {
+	if RebalanceOK.String() != "rebalance OK" || NotificationType(42).String() != "unknown" {
+		t.Fatalf("names %q, %q", RebalanceOK, NotificationType(42))
+	}
+}
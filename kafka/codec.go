@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Content types with a codec registered by default
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeRaw  = "application/octet-stream"
+)
+
+// Header carrying the content type of a message. Messages without it are
+// treated as JSON.
+const contentTypeHeader = "content-type"
+
+// Codec : Converts between message values and Go values, registered per
+// content type with RegisterCodec
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Decode(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// Passes bytes through as is, from and to []byte or string values
+type rawCodec struct{}
+
+func (rawCodec) Encode(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("raw codec can't encode %T", v)
+	}
+}
+
+func (rawCodec) Decode(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+	case *string:
+		*v = string(data)
+	default:
+		return fmt.Errorf("raw codec can't decode into %T", v)
+	}
+	return nil
+}
+
+var defaultCodecs = map[string]Codec{
+	ContentTypeJSON: jsonCodec{},
+	ContentTypeRaw:  rawCodec{},
+}
+
+// RegisterCodec : Registers the codec for a content type, replacing the
+// default one if any. Call before Connect.
+func (kc *Client) RegisterCodec(contentType string, c Codec) {
+	if kc.codecs == nil {
+		kc.codecs = make(map[string]Codec)
+	}
+	kc.codecs[contentType] = c
+}
+
+// The codec for a content type, nil if there is none. An empty content
+// type is JSON.
+func (kc *Client) codec(contentType string) Codec {
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	if c, ok := kc.codecs[contentType]; ok {
+		return c
+	}
+	return defaultCodecs[contentType]
+}
+
+// PublishValue : Encodes v with the codec for opts.ContentType (JSON when
+// empty) and publishes it as with PublishWithOptions. A non-empty content
+// type is sent in the content-type header.
+func (kc *Client) PublishValue(topic string, v interface{}, opts PublishOptions) error {
+	c := kc.codec(opts.ContentType)
+	if c == nil {
+		return fmt.Errorf("kafka: no codec for content type %q", opts.ContentType)
+	}
+
+	value, err := c.Encode(v)
+	if err != nil {
+		return fmt.Errorf("kafka: encoding %s: %v", opts.ContentType, err)
+	}
+	return kc.PublishWithOptions(topic, value, opts)
+}
+
+// Decode : Decodes the value into v with the codec for the content-type
+// header of the message, JSON when it has none
+func (m Message) Decode(v interface{}) error {
+	if m.codec == nil {
+		return fmt.Errorf("kafka: no codec for content type %q", m.contentType)
+	}
+	return m.codec.Decode([]byte(m.Value), v)
+}
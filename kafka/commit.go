@@ -0,0 +1,119 @@
+package kafka
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var offsetCommitFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "offset_commit_failures_total",
+	Help:      "Failed attempts to commit consumer offsets.",
+})
+
+func init() {
+	prometheus.MustRegister(offsetCommitFailures)
+}
+
+// A failed offset commit of sarama-cluster, reported on Errors
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return e.err.Error()
+}
+
+// Handle a failed offset commit. sarama-cluster gives up on committing
+// once its own immediate retries fail and rebalances, after which the
+// messages handled since the last commit are delivered again. Retry with
+// backoff in the meantime, and with Config.PauseOnCommitFailure stop
+// fetching until the rebalance is done when that fails too, so no more
+// messages are handled only to be delivered again. Reports whether err was
+// a commit error. sarama's consumer group reports failed commits as plain
+// consumer errors and can't commit on demand, so this only applies to
+// sarama-cluster; Validate rejects PauseOnCommitFailure otherwise.
+func (kc *Client) handleCommitError(err error) bool {
+	cerr, ok := err.(*commitError)
+	if !ok {
+		return false
+	}
+
+	offsetCommitFailures.Inc()
+	kc.logger().Error("failed to commit offsets, messages handled since the last commit may be delivered again", errorFields(cerr))
+
+	if atomic.CompareAndSwapInt32(&kc.retryingCommit, 0, 1) {
+		go kc.retryCommit()
+	}
+	return true
+}
+
+func (kc *Client) retryCommit() {
+	defer atomic.StoreInt32(&kc.retryingCommit, 0)
+
+	backoff := kc.config.CommitRetryBackoff
+	for i := 1; i <= kc.config.CommitRetryMax; i++ {
+		select {
+		case <-kc.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		kc.consumerMu.RLock()
+		err := kc.Consumer.CommitOffsets()
+		kc.consumerMu.RUnlock()
+		if err == nil {
+			kc.logger().Info("committed offsets after retrying", Fields{"retries": i})
+			return
+		}
+
+		offsetCommitFailures.Inc()
+		kc.logger().Warn("failed to commit offsets", Fields{"retry": i, "max_retries": kc.config.CommitRetryMax, "error": err})
+		backoff *= 2
+	}
+
+	if kc.config.PauseOnCommitFailure {
+		kc.logger().Warn("pausing consumption until the consumer group has rebalanced", nil)
+		kc.pause()
+	}
+}
+
+func (kc *Client) pause() {
+	kc.pauseMu.Lock()
+	defer kc.pauseMu.Unlock()
+
+	if kc.paused == nil {
+		kc.paused = make(chan struct{})
+	}
+}
+
+func (kc *Client) resume() {
+	kc.pauseMu.Lock()
+	defer kc.pauseMu.Unlock()
+
+	if kc.paused != nil {
+		close(kc.paused)
+		kc.paused = nil
+		kc.logger().Info("resuming consumption", nil)
+	}
+}
+
+// Block while consumption is paused, or until ctx is done
+func (kc *Client) waitUnpaused(ctx context.Context) error {
+	kc.pauseMu.Lock()
+	paused := kc.paused
+	kc.pauseMu.Unlock()
+
+	if paused == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-paused:
+		return nil
+	}
+}
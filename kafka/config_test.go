@@ -0,0 +1,45 @@
+package kafka
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestValidateReportsAnInvalidVersion(t *testing.T) {
+	cfg := &Config{URL: "kafka+ssl://broker:9096", Version: "two"}
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "KAFKA_VERSION") {
+		t.Fatalf("Validate returned %v, want an error about KAFKA_VERSION", err)
+	}
+}
+
+func TestKafkaVersion(t *testing.T) {
+	cfg := &Config{}
+	if v, err := cfg.kafkaVersion(sarama.V1_0_0_0); err != nil || v != sarama.V1_0_0_0 {
+		t.Fatalf("unset version gave %v, %v, want the fallback", v, err)
+	}
+
+	cfg.Version = "2.1.0"
+	if v, err := cfg.kafkaVersion(sarama.MinVersion); err != nil || v != sarama.V2_1_0_0 {
+		t.Fatalf("2.1.0 gave %v, %v", v, err)
+	}
+
+	// Returned rather than exiting the process
+	cfg.Version = "two"
+	if _, err := cfg.kafkaVersion(sarama.MinVersion); err == nil {
+		t.Fatal("an invalid version was accepted")
+	}
+}
+
+func TestCreateKafkaConsumerRejectsEmptyTopics(t *testing.T) {
+	cfg := &Config{}
+	for _, topics := range [][]string{nil, {""}, {"orders", " "}} {
+		// Refused before any connection to the brokers
+		if _, err := cfg.createKafkaConsumer([]string{"broker:9096"}, nil, "group", topics); err == nil || !strings.Contains(err.Error(), "topic") {
+			t.Fatalf("topics %q gave %v, want an error about the topics", topics, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrConsumerClosed is returned by Consume when the consumer stopped
+// delivering messages and Config.AutoReconnect is disabled
+var ErrConsumerClosed = errors.New("kafka: consumer closed")
+
+// Returned by reconnectConsumer once Shutdown started, when the consume
+// loops return nil
+var errShuttingDown = errors.New("kafka: client is shutting down")
+
+// Consume : Consumes messages until ctx is cancelled, running the handler
+// for each of them on up to Config.MaxConcurrentPerPartition workers per
+// partition, or on the key-sharded workers when Config.OrderedByKey is set,
+// or on a fixed pool of workers with WithConcurrency. Offsets are committed
+// as described in Process, on several workers per partition only once every
+// earlier message of the partition was handled, and the consumer's errors
+// and notifications are drained in the background. Consume returns as soon
+// as ctx is cancelled, even on an idle topic or while the workers' queues
+// are full. The handler gets ctx, so it is cancelled as soon as consumption
+// stops; call Shutdown afterwards to wait for the handlers that are still
+// running.
+//
+// Consume also returns, with nil, once Shutdown is called.
+//
+// If the consumer closes its messages channel on its own, the consumer is
+// recreated when Config.AutoReconnect is set, otherwise ErrConsumerClosed
+// is returned so the caller can decide what to do.
+//
+// With Config.MaxMessages set, Consume stops after that many messages,
+// waits for their handlers and returns nil. Call Shutdown afterwards to
+// commit their offsets.
+func (kc *Client) Consume(ctx context.Context, handler Handler, opts ...ConsumeOption) error {
+	o, err := newConsumeOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	kc.setGroupState(groupJoining)
+	if kc.config.RetryTopics {
+		if err := kc.startRetryConsumer(ctx, handler); err != nil {
+			return err
+		}
+	}
+
+	var dispatch func(ctx context.Context, msg *sarama.ConsumerMessage, handler Handler)
+	switch {
+	case o.concurrency > 0:
+		pool := kc.newSharedPool(o.concurrency, kc.config.ChannelBufferSize)
+		defer pool.close()
+		dispatch = func(ctx context.Context, msg *sarama.ConsumerMessage, handler Handler) {
+			kc.submitShared(pool, job{ctx, msg, handler})
+		}
+	case kc.config.OrderedByKey:
+		pool := kc.newKeyedPool(kc.config.Workers, kc.config.ChannelBufferSize)
+		defer pool.close()
+		dispatch = func(ctx context.Context, msg *sarama.ConsumerMessage, handler Handler) {
+			kc.submit(pool, job{ctx, msg, handler})
+		}
+	default:
+		pool := newPartitionPool(kc.config.MaxConcurrentPerPartition, kc.config.ChannelBufferSize)
+		defer pool.close()
+		dispatch = func(ctx context.Context, msg *sarama.ConsumerMessage, handler Handler) {
+			kc.submitPartition(pool, job{ctx, msg, handler})
+		}
+	}
+
+	consumed := 0
+	consumer := kc.currentConsumer()
+	messages := consumer.Messages()
+	kc.watchCurrentConsumer(consumer)
+	for {
+		if err := kc.waitUnpaused(ctx); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-kc.done:
+			// Shutdown drains the messages handed out so far
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				if c := kc.currentConsumer(); c != consumer {
+					// Replaced by Subscribe, Unsubscribe or the idle check
+					consumer, messages = c, c.Messages()
+					kc.watchCurrentConsumer(consumer)
+					continue
+				}
+				if kc.shuttingDown() {
+					// Closed by Shutdown
+					return nil
+				}
+				kc.logger().Warn("consumer closed its messages channel", nil)
+				if !kc.config.AutoReconnect {
+					return ErrConsumerClosed
+				}
+				if err := kc.reconnectConsumer(ctx); err != nil {
+					if err == errShuttingDown {
+						return nil
+					}
+					return err
+				}
+				consumer = kc.currentConsumer()
+				messages = consumer.Messages()
+				kc.watchCurrentConsumer(consumer)
+				continue
+			}
+			if msg == nil {
+				continue
+			}
+			kc.touch(msg)
+
+			dispatch(ctx, msg, handler)
+			consumed++
+			if kc.config.MaxMessages > 0 && consumed >= kc.config.MaxMessages {
+				kc.logger().Info("consumed the maximum of messages, stopping", Fields{"messages": consumed})
+				kc.inflight.Wait()
+				return nil
+			}
+		}
+	}
+}
+
+// Replace the consumer with a new one, retrying every
+// Config.ReconnectBackoff until it succeeds, ctx is cancelled or Shutdown
+// starts. Checked under consumerMu, so a consumer is either created before
+// LeaveGroup closes it or not at all, and no member is left in the group.
+func (kc *Client) reconnectConsumer(ctx context.Context) error {
+	kc.consumerMu.Lock()
+	defer kc.consumerMu.Unlock()
+	if kc.shuttingDown() {
+		return errShuttingDown
+	}
+
+	kc.Consumer.Close()
+	for {
+		kc.logger().Info("reconnecting consumer", nil)
+		consumer, err := kc.config.createKafkaConsumer(kc.brokers, kc.tlsConfig, kc.config.group(), kc.subscribed)
+		if err == nil {
+			kc.Consumer = consumer
+			return nil
+		}
+		kc.logger().Error("failed to reconnect consumer", errorFields(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-kc.done:
+			return errShuttingDown
+		case <-time.After(kc.config.ReconnectBackoff):
+		}
+	}
+}
+
+// Whether Shutdown started
+func (kc *Client) shuttingDown() bool {
+	select {
+	case <-kc.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConsumeReturnsErrConsumerClosed(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- kc.Consume(context.Background(), func(context.Context, Message) error { return nil })
+	}()
+	close(consumer.messages)
+
+	select {
+	case err := <-done:
+		if err != ErrConsumerClosed {
+			t.Fatalf("Consume returned %v, want ErrConsumerClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Consume didn't return after the messages channel closed")
+	}
+}
+
+func TestWatchCurrentConsumerStopsThePreviousWatcher(t *testing.T) {
+	first, second := newMockConsumer(), newMockConsumer()
+	kc := newTestClient(first)
+
+	kc.watchCurrentConsumer(first)
+	stop := kc.stopWatch
+	kc.watchCurrentConsumer(second)
+
+	select {
+	case <-stop:
+	default:
+		t.Fatal("the watcher of the first consumer wasn't stopped")
+	}
+}
+
+func TestCancellingAnIdleConsumeReturnsPromptly(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- kc.Consume(ctx, func(context.Context, Message) error { return nil })
+	}()
+	// Let Consume wait on the empty messages channel
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	start := time.Now()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Consume returned %v, want context.Canceled", err)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("Consume returned %s after cancellation", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Consume didn't return after cancellation on an idle topic")
+	}
+}
+
+func TestConsumeDoesntReconnectAfterShutdown(t *testing.T) {
+	consumer := newMockConsumer()
+	kc, _ := newConnectedTestClient(t)
+	kc.Consumer = consumer
+	kc.config.AutoReconnect = true
+	kc.config.ReconnectBackoff = time.Millisecond
+
+	// As when the closed messages channel is seen before done
+	close(kc.done)
+	consumer.Close()
+	if err := kc.reconnectConsumer(context.Background()); err != errShuttingDown {
+		t.Fatalf("reconnectConsumer returned %v, want errShuttingDown", err)
+	}
+
+	returned := make(chan error, 1)
+	go func() {
+		returned <- kc.Consume(context.Background(), func(context.Context, Message) error { return nil })
+	}()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatalf("Consume returned %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Consume didn't return after Shutdown")
+	}
+	if kc.Consumer != consumer {
+		t.Fatal("Consume reconnected after Shutdown")
+	}
+}
@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var coordinatorChanges = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "coordinator_changes_total",
+	Help:      "Consumer errors from the group coordinator moving or being unavailable.",
+})
+
+func init() {
+	prometheus.MustRegister(coordinatorChanges)
+}
+
+// Errors of a consumer group whose coordinator moved, e.g. during a broker
+// restart. The consumer finds the new one on its next heartbeat or
+// rebalance.
+var coordinatorErrors = []sarama.KError{
+	sarama.ErrNotCoordinatorForConsumer,
+	sarama.ErrConsumerCoordinatorNotAvailable,
+}
+
+func isCoordinatorError(err error) bool {
+	if cerr, ok := err.(*sarama.ConsumerError); ok {
+		err = cerr.Err
+	}
+	// sarama-cluster wraps them in a *cluster.Error that doesn't expose
+	// the original, with the same message
+	for _, kerr := range coordinatorErrors {
+		if err == kerr || err.Error() == kerr.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle an error caused by the group coordinator moving. These are
+// expected while brokers restart and clear up once the consumer has found
+// the new coordinator and rebalanced, so only the first one is logged, and
+// again every Config.CoordinatorErrorThreshold while they keep coming.
+// Reports whether err was such an error.
+func (kc *Client) handleCoordinatorError(err error) bool {
+	if !isCoordinatorError(err) {
+		return false
+	}
+	coordinatorChanges.Inc()
+
+	kc.coordinatorMu.Lock()
+	defer kc.coordinatorMu.Unlock()
+
+	now := time.Now()
+	switch {
+	case kc.coordinatorSince.IsZero():
+		kc.coordinatorSince, kc.coordinatorLogged = now, now
+		kc.logger().Warn("group coordinator unavailable, waiting for the consumer to find the new one", errorFields(err))
+	case now.Sub(kc.coordinatorLogged) >= kc.config.CoordinatorErrorThreshold:
+		kc.coordinatorLogged = now
+		kc.logger().Error("group coordinator still unavailable, check the health of the brokers", Fields{
+			"unavailable_for": now.Sub(kc.coordinatorSince).Round(time.Second).String(),
+			"error":           err,
+		})
+	}
+	return true
+}
+
+// Called once the group rebalanced, which means the consumer reached the
+// coordinator again
+func (kc *Client) coordinatorRecovered() {
+	kc.coordinatorMu.Lock()
+	defer kc.coordinatorMu.Unlock()
+
+	if !kc.coordinatorSince.IsZero() {
+		kc.logger().Info("group coordinator available again", Fields{"unavailable_for": time.Since(kc.coordinatorSince).Round(time.Second).String()})
+		kc.coordinatorSince = time.Time{}
+	}
+}
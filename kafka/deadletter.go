@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Suffix of the dead-letter topics of Config.DeadLetterPerTopic
+const deadLetterTopicSuffix = ".dlq"
+
+// Diagnostic headers added to dead-lettered messages, prefixed with
+// Config.DLTHeaderPrefix
+const (
+	dltHeaderOriginalTopic     = "original-topic"
+	dltHeaderOriginalPartition = "original-partition"
+	dltHeaderOriginalOffset    = "original-offset"
+	dltHeaderError             = "error"
+	dltHeaderFailedAt          = "failed-at"
+	dltHeaderRetryCount        = "retry-count"
+	dltHeaderValidationErrors  = "validation-errors"
+)
+
+// Whether failed messages are dead-lettered at all
+func (kc *Config) deadLettering() bool {
+	return kc.DeadLetterTopic != "" || kc.DeadLetterPerTopic
+}
+
+// The dead-letter topic of a topic, given by its full name
+func (kc *Config) deadLetterTopic(topic string) string {
+	if kc.DeadLetterPerTopic {
+		return topic + deadLetterTopicSuffix
+	}
+	return kc.DeadLetterTopic
+}
+
+// Publish a failed message to the dead-letter topic. The original key and
+// headers are kept so a reprocessing tool can replay it to the source
+// topic and have it land on the same partition.
+func (kc *Client) deadLetter(msg *sarama.ConsumerMessage, cause error, retries int) error {
+	headers := make(map[string][]byte, len(msg.Headers)+7)
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers[string(h.Key)] = h.Value
+		}
+	}
+
+	prefix := kc.config.DLTHeaderPrefix
+	diagnostics := []struct{ key, value string }{
+		{dltHeaderOriginalTopic, msg.Topic},
+		{dltHeaderOriginalPartition, strconv.FormatInt(int64(msg.Partition), 10)},
+		{dltHeaderOriginalOffset, strconv.FormatInt(msg.Offset, 10)},
+		{dltHeaderError, cause.Error()},
+		{dltHeaderFailedAt, time.Now().UTC().Format(time.RFC3339)},
+		{dltHeaderRetryCount, strconv.Itoa(retries)},
+	}
+	if verr, ok := cause.(*ValidationError); ok {
+		diagnostics = append(diagnostics, struct{ key, value string }{
+			dltHeaderValidationErrors, strings.Join(verr.Errors, "; "),
+		})
+	}
+	for _, d := range diagnostics {
+		headers[prefix+d.key] = []byte(d.value)
+	}
+
+	return kc.publishDurably(kc.config.deadLetterTopic(msg.Topic), msg.Value, PublishOptions{
+		Key:        msg.Key,
+		RawHeaders: headers,
+	})
+}
@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// A message published to recordingProducer
+type published struct {
+	topic string
+	value []byte
+	opts  PublishOptions
+	sync  bool
+}
+
+// Producer keeping what it was asked to publish
+type recordingProducer struct {
+	mu   sync.Mutex
+	msgs []published
+}
+
+func (p *recordingProducer) Publish(topic string, value []byte, opts PublishOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, published{topic, value, opts, false})
+	return nil
+}
+
+func (p *recordingProducer) PublishSync(topic string, value []byte, opts PublishOptions) (int32, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, published{topic, value, opts, true})
+	return 0, int64(len(p.msgs) - 1), nil
+}
+
+func (p *recordingProducer) ProduceSync(topic string, value []byte) (int32, int64, error) {
+	return p.PublishSync(topic, value, PublishOptions{})
+}
+
+func (p *recordingProducer) Close() error { return nil }
+
+func (p *recordingProducer) published() []published {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]published(nil), p.msgs...)
+}
+
+func TestDeadLettersGoThroughTheClientProducer(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.DeadLetterTopic = "orders.dead"
+	kc.config.DLTHeaderPrefix = "x-"
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	msg := &sarama.ConsumerMessage{
+		Topic:   "orders",
+		Offset:  4,
+		Key:     []byte("order-1"),
+		Value:   []byte(`{"id":1}`),
+		Headers: []*sarama.RecordHeader{{Key: []byte("tenant"), Value: []byte("a")}},
+	}
+	kc.Process(context.Background(), msg, func(context.Context, Message) error {
+		return errors.New("printer offline")
+	})
+
+	msgs := producer.published()
+	if len(msgs) != 1 {
+		t.Fatalf("published %d messages, want the dead letter", len(msgs))
+	}
+	dl := msgs[0]
+	if dl.topic != "orders.dead" || !dl.sync || string(dl.opts.Key) != "order-1" || string(dl.value) != `{"id":1}` {
+		t.Fatalf("dead letter is %+v", dl)
+	}
+	if string(dl.opts.RawHeaders["tenant"]) != "a" || string(dl.opts.RawHeaders["x-original-offset"]) != "4" {
+		t.Fatalf("dead letter headers are %q", dl.opts.RawHeaders)
+	}
+	if marked := consumer.markedOffsets(); len(marked) != 1 {
+		t.Fatalf("marked %v, want the dead-lettered message", marked)
+	}
+}
+
+func TestRetriesGoThroughTheClientProducer(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+	kc.config.DLTHeaderPrefix = "x-"
+	kc.config.RetryTopics = true
+	kc.config.MaxRetryRounds = 3
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	msg := &sarama.ConsumerMessage{
+		Topic:   "orders",
+		Headers: []*sarama.RecordHeader{{Key: []byte("x-retry-round"), Value: []byte("1")}},
+	}
+	if err := kc.scheduleRetry(msg, 2); err != nil {
+		t.Fatalf("scheduleRetry returned %v", err)
+	}
+
+	msgs := producer.published()
+	if len(msgs) != 1 || !msgs[0].sync {
+		t.Fatalf("published %+v, want the retry", msgs)
+	}
+	if round := string(msgs[0].opts.RawHeaders["x-retry-round"]); round != "2" {
+		t.Fatalf("retry round header is %q, want 2", round)
+	}
+}
@@ -0,0 +1,44 @@
+package kafka
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// Handler durations, sampled with a forward-decaying reservoir so the
+// percentiles favour the last few minutes
+func newLatencyHistogram() metrics.Histogram {
+	return metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015))
+}
+
+// LatencyStats : Percentiles of recent message handling durations
+func (kc *Client) LatencyStats() (p50, p95, p99 time.Duration) {
+	ps := kc.latency.Percentiles([]float64{0.5, 0.95, 0.99})
+	return time.Duration(ps[0]), time.Duration(ps[1]), time.Duration(ps[2])
+}
+
+// DebugHandler : Serves an in-process summary of the client as JSON
+func (kc *Client) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p50, p95, p99 := kc.LatencyStats()
+
+		summary := map[string]interface{}{
+			"latency": map[string]string{
+				"p50": p50.String(),
+				"p95": p95.String(),
+				"p99": p99.String(),
+			},
+			"stats":  kc.Stats(),
+			"config": kc.config.Summary(),
+		}
+		if kc.config.Debug {
+			summary["deliveries_by_broker"] = kc.brokerDeliveries()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	})
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Policies for Config.OnDecodeError
+const (
+	// DecodeErrorFail stops processing the partition of the message, leaving
+	// its offset uncommitted. Other partitions and topics keep flowing.
+	DecodeErrorFail = "fail"
+	// DecodeErrorDLT publishes the raw message to the dead-letter topic
+	// and moves on
+	DecodeErrorDLT = "dlt"
+	// DecodeErrorSkip commits the message without handling it
+	DecodeErrorSkip = "skip"
+)
+
+var decodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "decode_errors_total",
+	Help:      "Messages that could not be decoded, by topic and policy applied.",
+}, []string{"topic", "policy"})
+
+func init() {
+	prometheus.MustRegister(decodeErrors)
+}
+
+// Decoder : Turns the raw value of a message into the value handed to the
+// handler, e.g. Avro resolved against a schema registry into JSON
+type Decoder func(value []byte) ([]byte, error)
+
+// SetDecoder : Sets the decoder for a topic, given by its name without
+// prefix. Topics without a decoder are handed to the handler as is, so an
+// outage of whatever a decoder depends on only affects its own topics.
+// Call before Consume.
+func (kc *Client) SetDecoder(topic string, d Decoder) {
+	if kc.decoders == nil {
+		kc.decoders = make(map[string]Decoder)
+	}
+	kc.decoders[topic] = d
+}
+
+// Decode value, the decrypted message value, with the decoder of its topic.
+// Reports false when decoding failed and the message must not be handled,
+// after having applied the topic's decode error policy.
+func (kc *Client) decode(msg *sarama.ConsumerMessage, value []byte, markOffset func(*sarama.ConsumerMessage)) ([]byte, bool) {
+	name, _ := kc.config.logicalTopic(msg.Topic)
+	d := kc.decoders[name]
+	if d == nil {
+		return value, true
+	}
+
+	decoded, err := d(value)
+	if err == nil {
+		return decoded, true
+	}
+
+	policy := kc.config.OnDecodeError.policy(name)
+	decodeErrors.WithLabelValues(msg.Topic, policy).Inc()
+
+	switch policy {
+	case DecodeErrorDLT:
+		kc.logger().Error("failed to decode message, dead-lettering", messageFields(msg, err))
+		if dltErr := kc.deadLetter(msg, err, 0); dltErr != nil {
+			kc.logger().Error("failed to dead-letter message, blocking partition", messageFields(msg, dltErr))
+			kc.block(msg.Topic, msg.Partition)
+			return nil, false
+		}
+		markOffset(msg)
+	case DecodeErrorSkip:
+		kc.logger().Error("failed to decode message, skipping", messageFields(msg, err))
+		markOffset(msg)
+	default:
+		if kc.isPoison(msg, 1) {
+			kc.skipPoison(msg, err, 0, markOffset)
+			return nil, false
+		}
+		kc.logger().Error("failed to decode message, blocking partition", messageFields(msg, err))
+		kc.block(msg.Topic, msg.Partition)
+	}
+	return nil, false
+}
+
+// DecodePolicies : Topic name to decode error policy, decoded from a comma
+// separated list of topic=policy pairs. Topics without an entry use
+// DecodeErrorFail.
+type DecodePolicies map[string]string
+
+// Decode : Implements envdecode.Decoder
+func (m *DecodePolicies) Decode(value string) error {
+	policies := make(DecodePolicies)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid decode error policy %q, expected topic=policy", pair)
+		}
+		switch parts[1] {
+		case DecodeErrorFail, DecodeErrorDLT, DecodeErrorSkip:
+		default:
+			return fmt.Errorf("decode error policy for %s must be one of %s, %s or %s, got %q",
+				parts[0], DecodeErrorFail, DecodeErrorDLT, DecodeErrorSkip, parts[1])
+		}
+		policies[parts[0]] = parts[1]
+	}
+
+	*m = policies
+	return nil
+}
+
+func (m DecodePolicies) policy(topic string) string {
+	if p, ok := m[topic]; ok {
+		return p
+	}
+	return DecodeErrorFail
+}
@@ -0,0 +1,97 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// A schema registry that is down
+func newFailingRegistry(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "registry unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Decoder resolving the schema of every value from the registry at url
+func registryDecoder(url string) Decoder {
+	return func(value []byte) ([]byte, error) {
+		resp, err := http.Get(url + "/schemas/ids/1")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("schema registry: %s", resp.Status)
+		}
+		return value, nil
+	}
+}
+
+// Process a message of the registry-backed topic and one of a JSON topic
+// during a registry outage, with the given policy for the former
+func processDuringRegistryOutage(t *testing.T, policy string) (*mockConsumer, *recordingProducer, []string) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.DeadLetterTopic = "dead"
+	kc.config.OnDecodeError = DecodePolicies{"avro_events": policy}
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+	kc.SetDecoder("avro_events", registryDecoder(newFailingRegistry(t).URL))
+
+	var handled []string
+	handler := func(ctx context.Context, msg Message) error {
+		handled = append(handled, msg.Topic)
+		return nil
+	}
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "avro_events", Offset: 1, Value: []byte{0}}, handler)
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "order_events", Offset: 2, Value: []byte("{}")}, handler)
+	return consumer, producer, handled
+}
+
+func TestRegistryOutageWithDLT(t *testing.T) {
+	consumer, producer, handled := processDuringRegistryOutage(t, DecodeErrorDLT)
+
+	if msgs := producer.published(); len(msgs) != 1 || msgs[0].topic != "dead" {
+		t.Fatalf("published %+v, want the undecodable message dead-lettered", msgs)
+	}
+	if !reflect.DeepEqual(handled, []string{"order_events"}) {
+		t.Fatalf("handled %v, want only the JSON topic", handled)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1, 2}) {
+		t.Fatalf("marked %v, want [1 2]", marked)
+	}
+}
+
+func TestRegistryOutageWithSkip(t *testing.T) {
+	consumer, producer, handled := processDuringRegistryOutage(t, DecodeErrorSkip)
+
+	if msgs := producer.published(); len(msgs) != 0 {
+		t.Fatalf("published %+v, want nothing", msgs)
+	}
+	if !reflect.DeepEqual(handled, []string{"order_events"}) {
+		t.Fatalf("handled %v, want only the JSON topic", handled)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1, 2}) {
+		t.Fatalf("marked %v, want [1 2]", marked)
+	}
+}
+
+func TestRegistryOutageWithFail(t *testing.T) {
+	consumer, _, handled := processDuringRegistryOutage(t, DecodeErrorFail)
+
+	// The registry-backed partition stops, the JSON topic keeps flowing
+	if !reflect.DeepEqual(handled, []string{"order_events"}) {
+		t.Fatalf("handled %v, want only the JSON topic", handled)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{2}) {
+		t.Fatalf("marked %v, want only the JSON topic's [2]", marked)
+	}
+}
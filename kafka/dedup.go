@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"container/list"
+	"sync"
+)
+
+// dedupCache is a bounded LRU of recently seen idempotency keys. It only
+// lives in memory, so deduplication is best-effort: a restart (or a
+// partition moving to another instance) forgets everything seen so far.
+type dedupCache struct {
+	mu      sync.Mutex
+	size    int
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+func newDedupCache(size int) *dedupCache {
+	return &dedupCache{
+		size:    size,
+		entries: list.New(),
+		index:   make(map[string]*list.Element, size),
+	}
+}
+
+// seen reports whether the key is in the cache
+func (d *dedupCache) seen(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.index[key]; ok {
+		d.entries.MoveToFront(el)
+		return true
+	}
+	return false
+}
+
+// add records the key, evicting the least recently seen one when full
+func (d *dedupCache) add(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.index[key]; ok {
+		d.entries.MoveToFront(el)
+		return
+	}
+
+	d.index[key] = d.entries.PushFront(key)
+	if d.entries.Len() > d.size {
+		oldest := d.entries.Back()
+		d.entries.Remove(oldest)
+		delete(d.index, oldest.Value.(string))
+	}
+}
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestDedupCacheEvictsTheLeastRecentlySeenKey(t *testing.T) {
+	d := newDedupCache(2)
+	d.add("a")
+	d.add("b")
+	// Seeing a makes b the least recently seen
+	if !d.seen("a") {
+		t.Fatal("a not seen after being added")
+	}
+	d.add("c")
+
+	if d.seen("b") {
+		t.Fatal("b still cached, want it evicted")
+	}
+	if !d.seen("a") || !d.seen("c") {
+		t.Fatal("a and c should still be cached")
+	}
+	if d.entries.Len() != 2 || len(d.index) != 2 {
+		t.Fatalf("%d entries and %d indexed, want 2 of each", d.entries.Len(), len(d.index))
+	}
+}
+
+func TestDedupCacheAddingAKeyAgainKeepsOneEntry(t *testing.T) {
+	d := newDedupCache(2)
+	d.add("a")
+	d.add("b")
+	d.add("a")
+	d.add("c")
+
+	if !d.seen("a") || d.seen("b") {
+		t.Fatal("re-adding a should have made b the one to evict")
+	}
+}
+
+// A client deduplicating on the idempotency-key header
+func newDedupTestClient(consumer GroupConsumer) *Client {
+	kc := newTestClient(consumer)
+	kc.config.DedupHeader = "idempotency-key"
+	kc.config.DLTHeaderPrefix = "x-"
+	kc.dedup = newDedupCache(16)
+	return kc
+}
+
+func keyedMessage(offset int64, key string) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:   "print_jobs",
+		Offset:  offset,
+		Value:   []byte("{}"),
+		Headers: []*sarama.RecordHeader{{Key: []byte("idempotency-key"), Value: []byte(key)}},
+	}
+}
+
+func TestDuplicatesAreSkippedAndCommitted(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newDedupTestClient(consumer)
+
+	calls := 0
+	handler := func(context.Context, Message) error {
+		calls++
+		return nil
+	}
+	kc.Process(context.Background(), keyedMessage(1, "job-1"), handler)
+	kc.Process(context.Background(), keyedMessage(2, "job-1"), handler)
+	kc.Process(context.Background(), keyedMessage(3, "job-2"), handler)
+
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want the duplicate skipped", calls)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1, 2, 3}) {
+		t.Fatalf("marked %v, want the duplicate committed too", marked)
+	}
+}
+
+func TestFailedMessagesAreNotRemembered(t *testing.T) {
+	kc := newDedupTestClient(newMockConsumer())
+
+	calls := 0
+	kc.Process(context.Background(), keyedMessage(1, "job-1"), failingHandler(&calls))
+	kc.Process(context.Background(), keyedMessage(1, "job-1"), failingHandler(&calls))
+
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want the redelivery of a failed message handled", calls)
+	}
+}
+
+func TestRetryTopicCopiesAreNeverSkipped(t *testing.T) {
+	kc := newDedupTestClient(newMockConsumer())
+
+	calls := 0
+	handler := func(context.Context, Message) error {
+		calls++
+		return nil
+	}
+	kc.Process(context.Background(), keyedMessage(1, "job-1"), handler)
+	retry := keyedMessage(1, "job-1")
+	retry.Headers = append(retry.Headers, &sarama.RecordHeader{Key: []byte("x-" + retryHeaderRound), Value: []byte("1")})
+	kc.Process(context.Background(), retry, handler)
+
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want the retry handled", calls)
+	}
+}
@@ -0,0 +1,118 @@
+package kafka
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Header carrying the base64 AES-GCM nonce of an encrypted message
+const encryptionNonceHeader = "encryption-nonce"
+
+var decryptionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "decryption_errors_total",
+	Help:      "Messages of encrypted topics that could not be decrypted.",
+}, []string{"topic"})
+
+func init() {
+	prometheus.MustRegister(decryptionErrors)
+}
+
+// Create the AES-GCM cipher for a base64 AES-128, AES-192 or AES-256 key
+func newAEAD(encodedKey string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("KAFKA_ENCRYPTION_KEY must be base64: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("KAFKA_ENCRYPTION_KEY must be 16, 24 or 32 bytes: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Is the topic, with or without prefix, one of Config.EncryptedTopics
+func (kc *Client) encrypted(topic string) bool {
+	if kc.aead == nil {
+		return false
+	}
+	name, _ := kc.config.logicalTopic(topic)
+	return kc.config.EncryptedTopics.contains(name)
+}
+
+// Encrypt the value of a message published to an encrypted topic, adding
+// its nonce to the headers. Other messages are returned as they are.
+func (kc *Client) encrypt(topic string, value []byte, opts PublishOptions) ([]byte, PublishOptions, error) {
+	if !kc.encrypted(topic) || value == nil {
+		return value, opts, nil
+	}
+
+	nonce := make([]byte, kc.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, opts, fmt.Errorf("kafka: generating nonce: %v", err)
+	}
+
+	// Don't add the nonce to the caller's map
+	headers := make(map[string]string, len(opts.Headers)+1)
+	for k, v := range opts.Headers {
+		headers[k] = v
+	}
+	headers[encryptionNonceHeader] = base64.StdEncoding.EncodeToString(nonce)
+	opts.Headers = headers
+
+	return kc.aead.Seal(nil, nonce, value, nil), opts, nil
+}
+
+// Decrypt the value of a message from an encrypted topic. Reports false
+// when it can't be decrypted and must not be handled, after having sent it
+// to the dead-letter topic as it was received.
+func (kc *Client) decrypt(msg *sarama.ConsumerMessage, markOffset func(*sarama.ConsumerMessage)) ([]byte, bool) {
+	if !kc.encrypted(msg.Topic) || msg.Value == nil {
+		return msg.Value, true
+	}
+
+	value, err := kc.open(msg)
+	if err == nil {
+		return value, true
+	}
+
+	decryptionErrors.WithLabelValues(msg.Topic).Inc()
+	err = fmt.Errorf("kafka: decrypting message: %v", err)
+	kc.logger().Error("failed to decrypt message, dead-lettering", messageFields(msg, err))
+	if dltErr := kc.deadLetter(msg, err, 0); dltErr != nil {
+		kc.logger().Error("failed to dead-letter message, blocking partition", messageFields(msg, dltErr))
+		kc.block(msg.Topic, msg.Partition)
+		return nil, false
+	}
+	markOffset(msg)
+	return nil, false
+}
+
+func (kc *Client) open(msg *sarama.ConsumerMessage) ([]byte, error) {
+	var encoded []byte
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == encryptionNonceHeader {
+			encoded = h.Value
+		}
+	}
+	if encoded == nil {
+		return nil, errors.New("no " + encryptionNonceHeader + " header")
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %v", err)
+	}
+	if len(nonce) != kc.aead.NonceSize() {
+		return nil, fmt.Errorf("nonce must be %d bytes, got %d", kc.aead.NonceSize(), len(nonce))
+	}
+	return kc.aead.Open(nil, nonce, msg.Value, nil)
+}
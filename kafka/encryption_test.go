@@ -0,0 +1,135 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// A client encrypting the payments topic, dead-lettering to payments.dead
+func newEncryptionTestClient(t *testing.T, consumer GroupConsumer) (*Client, *recordingProducer) {
+	kc := newTestClient(consumer)
+	kc.config.EncryptedTopics = CommaList{"payments"}
+	kc.config.DeadLetterTopic = "payments.dead"
+	kc.config.DLTHeaderPrefix = "x-"
+	aead, err := newAEAD(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kc.aead = aead
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+	return kc, producer
+}
+
+// The message a consumer would get for a value encrypted by kc
+func encryptedMessage(t *testing.T, kc *Client, value string) *sarama.ConsumerMessage {
+	sealed, opts, err := kc.encrypt("payments", []byte(value), PublishOptions{Headers: map[string]string{"tenant": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &sarama.ConsumerMessage{Topic: "payments", Offset: 3, Value: sealed}
+	for k, v := range opts.Headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return msg
+}
+
+func TestEncryptedValuesAreDecryptedForTheHandler(t *testing.T) {
+	consumer := newMockConsumer()
+	kc, _ := newEncryptionTestClient(t, consumer)
+	msg := encryptedMessage(t, kc, `{"card":"4111"}`)
+	if bytes.Contains(msg.Value, []byte("4111")) {
+		t.Fatal("value published in the clear")
+	}
+
+	var got string
+	kc.Process(context.Background(), msg, func(_ context.Context, m Message) error {
+		got = m.Value
+		return nil
+	})
+	if got != `{"card":"4111"}` {
+		t.Fatalf("handler got %q", got)
+	}
+	if len(consumer.markedOffsets()) != 1 {
+		t.Fatal("decrypted message not committed")
+	}
+}
+
+func TestEncryptionUsesAFreshNonceAndKeepsTheCallersHeaders(t *testing.T) {
+	kc, _ := newEncryptionTestClient(t, newMockConsumer())
+	headers := map[string]string{"tenant": "a"}
+	first, opts, _ := kc.encrypt("payments", []byte("{}"), PublishOptions{Headers: headers})
+	second, _, _ := kc.encrypt("payments", []byte("{}"), PublishOptions{Headers: headers})
+
+	if bytes.Equal(first, second) {
+		t.Fatal("same ciphertext twice, the nonce was reused")
+	}
+	if _, ok := headers[encryptionNonceHeader]; ok {
+		t.Fatal("nonce added to the caller's headers")
+	}
+	if opts.Headers["tenant"] != "a" || opts.Headers[encryptionNonceHeader] == "" {
+		t.Fatalf("published headers are %q", opts.Headers)
+	}
+}
+
+func TestOtherTopicsAreNotEncrypted(t *testing.T) {
+	kc, _ := newEncryptionTestClient(t, newMockConsumer())
+	value, opts, err := kc.encrypt("orders", []byte("{}"), PublishOptions{})
+	if err != nil || string(value) != "{}" || opts.Headers != nil {
+		t.Fatalf("orders encrypted: %q, %q, %v", value, opts.Headers, err)
+	}
+}
+
+func TestUndecryptableMessagesAreDeadLettered(t *testing.T) {
+	tests := map[string]func(*sarama.ConsumerMessage){
+		"tampered value": func(msg *sarama.ConsumerMessage) { msg.Value[0] ^= 0xff },
+		"no nonce": func(msg *sarama.ConsumerMessage) {
+			msg.Headers = msg.Headers[:0]
+		},
+		"short nonce": func(msg *sarama.ConsumerMessage) {
+			for _, h := range msg.Headers {
+				if string(h.Key) == encryptionNonceHeader {
+					h.Value = []byte(base64.StdEncoding.EncodeToString([]byte("short")))
+				}
+			}
+		},
+	}
+	for name, corrupt := range tests {
+		t.Run(name, func(t *testing.T) {
+			consumer := newMockConsumer()
+			kc, producer := newEncryptionTestClient(t, consumer)
+			msg := encryptedMessage(t, kc, "{}")
+			corrupt(msg)
+			received := append([]byte(nil), msg.Value...)
+			before := counterValue(t, "kafka_decryption_errors_total", "payments")
+
+			kc.Process(context.Background(), msg, func(context.Context, Message) error {
+				t.Fatal("handler called with a message that can't be decrypted")
+				return nil
+			})
+
+			msgs := producer.published()
+			if len(msgs) != 1 || msgs[0].topic != "payments.dead" || !bytes.Equal(msgs[0].value, received) {
+				t.Fatalf("published %+v, want the message dead-lettered as received", msgs)
+			}
+			if len(consumer.markedOffsets()) != 1 {
+				t.Fatal("dead-lettered message not committed")
+			}
+			if got := counterValue(t, "kafka_decryption_errors_total", "payments"); got != before+1 {
+				t.Fatalf("kafka_decryption_errors_total went from %v to %v", before, got)
+			}
+		})
+	}
+}
+
+func TestEncryptionKeyMustBeAnAESKey(t *testing.T) {
+	for _, key := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := newAEAD(key); err == nil {
+			t.Fatalf("key %q accepted", key)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Header with an RFC 3339 time after which the message is not worth
+// handling anymore
+const expiresAtHeader = "expires-at"
+
+var expiredMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "expired_messages_total",
+	Help:      "Messages skipped because they expired before they were handled.",
+}, []string{"topic"})
+
+func init() {
+	prometheus.MustRegister(expiredMessages)
+}
+
+// Reports whether the message expired, per its expires-at header or else
+// by being older than Config.MaxMessageAge. Messages without a timestamp,
+// as with KAFKA_VERSION < 0.10.0, never expire by age.
+func (kc *Client) isExpired(msg *sarama.ConsumerMessage, now time.Time) bool {
+	for _, h := range msg.Headers {
+		if h == nil || string(h.Key) != expiresAtHeader {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, string(h.Value))
+		if err != nil {
+			fields := messageFields(msg, nil)
+			fields["header"], fields["value"] = expiresAtHeader, string(h.Value)
+			kc.logger().Warn("ignoring invalid expiry header", fields)
+			break
+		}
+		return now.After(expiresAt)
+	}
+
+	if kc.config.MaxMessageAge <= 0 || msg.Timestamp.IsZero() {
+		return false
+	}
+	return now.Sub(msg.Timestamp) > kc.config.MaxMessageAge
+}
+
+// Skip the message if it expired, reporting whether it did
+func (kc *Client) skipExpired(msg *sarama.ConsumerMessage, markOffset func(*sarama.ConsumerMessage)) bool {
+	if !kc.isExpired(msg, time.Now()) {
+		return false
+	}
+
+	expiredMessages.WithLabelValues(msg.Topic).Inc()
+	kc.logger().Info("skipping expired message", messageFields(msg, nil))
+	markOffset(msg)
+	return true
+}
@@ -0,0 +1,194 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Membership of the consumer group, as last reported by a rebalance
+// notification
+const (
+	groupJoining     = "joining"
+	groupMember      = "member"
+	groupRebalancing = "rebalancing"
+	groupError       = "error"
+)
+
+var errHealthTimeout = errors.New("kafka: metadata request timed out")
+
+// HealthCheck : The result of one check of Client.Health
+type HealthCheck struct {
+	OK     bool   `json:"ok"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Since when the check has been failing, nil while it passes or when
+	// it fails for a reason that doesn't make the client wedged
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+}
+
+// HealthStatus : The result of Client.Health, by check: brokers, group
+// and producer
+type HealthStatus struct {
+	Ready  bool                   `json:"ready"`
+	Live   bool                   `json:"live"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// When each check started failing, so liveness only fails once a problem
+// has lasted Config.HealthStallTimeout
+type healthState struct {
+	mu sync.Mutex
+
+	brokersFailingSince time.Time
+
+	group      string
+	groupSince time.Time
+
+	// Publish calls waiting on the producer, and since when none of them
+	// returned
+	publishing   int
+	publishSince time.Time
+}
+
+func (kc *Client) setGroupState(state string) {
+	kc.health.mu.Lock()
+	defer kc.health.mu.Unlock()
+
+	if kc.health.group != state {
+		kc.health.group = state
+		kc.health.groupSince = time.Now()
+	}
+}
+
+// Track a publish waiting on the producer, until the returned function
+// is called
+func (kc *Client) trackPublish() func() {
+	h := &kc.health
+	h.mu.Lock()
+	if h.publishing == 0 {
+		h.publishSince = time.Now()
+	}
+	h.publishing++
+	h.mu.Unlock()
+
+	return func() {
+		h.mu.Lock()
+		h.publishing--
+		// The producer took a message, so it isn't stuck
+		h.publishSince = time.Now()
+		h.mu.Unlock()
+	}
+}
+
+// Health : Checks the connection to the brokers. Ready reports whether
+// every check passes now: the brokers answer a metadata request within
+// Config.HealthCheckTimeout, the consumer is a member of its group, and no
+// publish has waited on the producer for longer than the check timeout.
+// Live reports whether the client is not wedged: no check has been failing
+// for longer than Config.HealthStallTimeout. A client that hasn't called
+// Consume has no group check.
+func (kc *Client) Health() HealthStatus {
+	now := time.Now()
+	status := HealthStatus{Ready: true, Live: true, Checks: make(map[string]HealthCheck, 3)}
+	add := func(name string, check HealthCheck) {
+		if !check.OK {
+			status.Ready = false
+			if check.FailingSince != nil && now.Sub(*check.FailingSince) > kc.config.HealthStallTimeout {
+				status.Live = false
+			}
+		}
+		status.Checks[name] = check
+	}
+
+	add("brokers", kc.checkBrokers())
+
+	kc.health.mu.Lock()
+	defer kc.health.mu.Unlock()
+
+	if group := kc.health.group; group != "" {
+		check := HealthCheck{OK: group == groupMember, Status: group}
+		if !check.OK {
+			check.FailingSince = timeRef(kc.health.groupSince)
+		}
+		add("group", check)
+	}
+
+	check := HealthCheck{OK: true, Status: "idle"}
+	if kc.health.publishing > 0 {
+		check.Status = "publishing"
+		if now.Sub(kc.health.publishSince) > kc.config.HealthCheckTimeout {
+			check = HealthCheck{
+				Status:       "stalled",
+				Error:        "no publish returned in " + now.Sub(kc.health.publishSince).Round(time.Second).String(),
+				FailingSince: timeRef(kc.health.publishSince),
+			}
+		}
+	}
+	add("producer", check)
+
+	return status
+}
+
+// Refresh the metadata of the subscribed topics, or of every topic when
+// none is
+func (kc *Client) checkBrokers() HealthCheck {
+	if !kc.Connected() {
+		return HealthCheck{Status: "disconnected", Error: "client is not connected"}
+	}
+
+	kc.deliveriesMu.Lock()
+	client, err := kc.metadataClient()
+	kc.deliveriesMu.Unlock()
+
+	if err == nil {
+		done := make(chan error, 1)
+		go func() { done <- client.RefreshMetadata(kc.subscriptions()...) }()
+		select {
+		case err = <-done:
+		case <-time.After(kc.config.HealthCheckTimeout):
+			err = errHealthTimeout
+		}
+	}
+
+	kc.health.mu.Lock()
+	defer kc.health.mu.Unlock()
+	if err == nil {
+		kc.health.brokersFailingSince = time.Time{}
+		return HealthCheck{OK: true, Status: "reachable"}
+	}
+	if kc.health.brokersFailingSince.IsZero() {
+		kc.health.brokersFailingSince = time.Now()
+	}
+	return HealthCheck{Status: "unreachable", Error: err.Error(), FailingSince: timeRef(kc.health.brokersFailingSince)}
+}
+
+func timeRef(t time.Time) *time.Time {
+	return &t
+}
+
+// HealthHandler : Serves Health as JSON for a liveness probe, e.g.
+// /healthz, with status 503 once the client is wedged
+func (kc *Client) HealthHandler() http.Handler {
+	return kc.healthHandler(func(s HealthStatus) bool { return s.Live })
+}
+
+// ReadyHandler : Serves Health as JSON for a readiness probe, e.g.
+// /readyz, with status 503 while any check fails
+func (kc *Client) ReadyHandler() http.Handler {
+	return kc.healthHandler(func(s HealthStatus) bool { return s.Ready })
+}
+
+func (kc *Client) healthHandler(ok func(HealthStatus) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := kc.Health()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok(status) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// A connected client whose broker check asks broker for metadata
+func newHealthTestClient(t *testing.T, broker *sarama.MockBroker) *Client {
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+	})
+	config := sarama.NewConfig()
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = &tls.Config{InsecureSkipVerify: true}
+	config.Metadata.Retry.Backoff = 10 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kc := newTestClient(newMockConsumer())
+	kc.config.HealthCheckTimeout = 500 * time.Millisecond
+	kc.config.HealthStallTimeout = time.Minute
+	kc.connected = true
+	kc.subscribed = []string{"orders"}
+	kc.metadata = client
+	return kc
+}
+
+// The status code and body a health handler answers with
+func serveHealth(t *testing.T, h http.Handler) (int, HealthStatus) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	var status HealthStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("health isn't JSON: %v", err)
+	}
+	return rec.Code, status
+}
+
+func TestHealthPassesWhenEveryCheckDoes(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	defer broker.Close()
+	kc := newHealthTestClient(t, broker)
+	defer kc.metadata.Close()
+	kc.setGroupState(groupMember)
+
+	code, status := serveHealth(t, kc.ReadyHandler())
+	if code != http.StatusOK || !status.Ready || !status.Live {
+		t.Fatalf("ready handler answered %d with %+v", code, status)
+	}
+	for _, name := range []string{"brokers", "group", "producer"} {
+		if !status.Checks[name].OK {
+			t.Fatalf("%s check failed: %+v", name, status.Checks[name])
+		}
+	}
+	if status.Checks["brokers"].Status != "reachable" || status.Checks["producer"].Status != "idle" {
+		t.Fatalf("checks are %+v", status.Checks)
+	}
+}
+
+func TestHealthHasNoGroupCheckBeforeConsume(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	defer broker.Close()
+	kc := newHealthTestClient(t, broker)
+	defer kc.metadata.Close()
+
+	if _, ok := kc.Health().Checks["group"]; ok {
+		t.Fatal("group checked before Consume was called")
+	}
+}
+
+func TestARebalanceFailsReadinessAndThenLiveness(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	defer broker.Close()
+	kc := newHealthTestClient(t, broker)
+	defer kc.metadata.Close()
+	kc.setGroupState(groupRebalancing)
+
+	if code, status := serveHealth(t, kc.ReadyHandler()); code != http.StatusServiceUnavailable || status.Ready {
+		t.Fatalf("ready during a rebalance: %d %+v", code, status)
+	}
+	if code, status := serveHealth(t, kc.HealthHandler()); code != http.StatusOK || !status.Live {
+		t.Fatalf("not live as soon as a rebalance started: %d %+v", code, status)
+	}
+
+	// Still rebalancing once the stall timeout is up
+	kc.health.mu.Lock()
+	kc.health.groupSince = time.Now().Add(-2 * time.Minute)
+	kc.health.mu.Unlock()
+	code, status := serveHealth(t, kc.HealthHandler())
+	if code != http.StatusServiceUnavailable || status.Live {
+		t.Fatalf("live after rebalancing past the stall timeout: %d %+v", code, status)
+	}
+	if check := status.Checks["group"]; check.Status != groupRebalancing || check.FailingSince == nil {
+		t.Fatalf("group check is %+v", check)
+	}
+
+	kc.setGroupState(groupMember)
+	if status := kc.Health(); !status.Ready || !status.Live {
+		t.Fatalf("not healthy after the rebalance: %+v", status)
+	}
+}
+
+func TestAStuckPublishFailsTheProducerCheck(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	defer broker.Close()
+	kc := newHealthTestClient(t, broker)
+	defer kc.metadata.Close()
+
+	done := kc.trackPublish()
+	if check := kc.Health().Checks["producer"]; !check.OK || check.Status != "publishing" {
+		t.Fatalf("producer check of a publish in progress is %+v", check)
+	}
+
+	kc.health.mu.Lock()
+	kc.health.publishSince = time.Now().Add(-2 * time.Minute)
+	kc.health.mu.Unlock()
+	status := kc.Health()
+	if check := status.Checks["producer"]; check.OK || check.Status != "stalled" || status.Live {
+		t.Fatalf("stuck publish reported as %+v", status)
+	}
+
+	done()
+	if check := kc.Health().Checks["producer"]; !check.OK || check.Status != "idle" {
+		t.Fatalf("producer check after the publish returned is %+v", check)
+	}
+}
+
+func TestUnreachableBrokersAreFailingSinceTheFirstFailedCheck(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	kc := newHealthTestClient(t, broker)
+	defer kc.metadata.Close()
+	broker.Close()
+
+	first := kc.Health().Checks["brokers"]
+	if first.OK || first.Status != "unreachable" || first.FailingSince == nil {
+		t.Fatalf("brokers check with the broker down is %+v", first)
+	}
+	second := kc.Health().Checks["brokers"]
+	if second.FailingSince == nil || !second.FailingSince.Equal(*first.FailingSince) {
+		t.Fatalf("failing since %v, then %v", first.FailingSince, second.FailingSince)
+	}
+}
+
+func TestADisconnectedClientIsNotReadyButLive(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+	kc.config.HealthCheckTimeout = time.Second
+	kc.config.HealthStallTimeout = time.Minute
+
+	status := kc.Health()
+	if status.Ready || !status.Live || status.Checks["brokers"].Status != "disconnected" {
+		t.Fatalf("health of a client that isn't connected is %+v", status)
+	}
+}
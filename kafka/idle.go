@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Policies for Config.OnIdle
+const (
+	// IdleAlert only logs partitions that went quiet
+	IdleAlert = "alert"
+	// IdleReconnect also replaces the consumer when the broker has messages
+	// on a quiet partition that were never delivered
+	IdleReconnect = "reconnect"
+)
+
+var (
+	secondsSinceLastMessage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kafka",
+		Name:      "seconds_since_last_message",
+		Help:      "Seconds since the last message of an assigned partition, with KAFKA_IDLE_TIMEOUT.",
+	}, []string{"topic", "partition"})
+
+	idleReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kafka",
+		Name:      "idle_reconnects_total",
+		Help:      "Consumers replaced because an assigned partition stalled.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(secondsSinceLastMessage, idleReconnects)
+}
+
+type partitionActivity struct {
+	at      time.Time
+	offset  int64 // -1 until a message was received
+	alerted bool
+}
+
+// Record that a message of its partition was received
+func (kc *Client) touch(msg *sarama.ConsumerMessage) {
+	if kc.config.IdleTimeout <= 0 {
+		return
+	}
+
+	kc.activityMu.Lock()
+	defer kc.activityMu.Unlock()
+
+	if kc.activity == nil {
+		kc.activity = make(map[topicPartition]partitionActivity)
+	}
+	kc.activity[topicPartition{msg.Topic, msg.Partition}] = partitionActivity{at: time.Now(), offset: msg.Offset}
+}
+
+// Check the assigned partitions for stalls every half Config.IdleTimeout
+func (kc *Client) watchIdle() {
+	interval := kc.config.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kc.done:
+			return
+		case <-ticker.C:
+			kc.checkIdle()
+		}
+	}
+}
+
+func (kc *Client) checkIdle() {
+	now := time.Now()
+	assigned := kc.currentConsumer().Subscriptions()
+
+	// The last offset received of the partitions that just went quiet
+	idle := make(map[topicPartition]int64)
+
+	kc.activityMu.Lock()
+	if kc.activity == nil {
+		kc.activity = make(map[topicPartition]partitionActivity)
+	}
+	owned := make(map[topicPartition]bool)
+	for topic, partitions := range assigned {
+		name, _ := kc.config.logicalTopic(topic)
+		watched := len(kc.config.IdleTopics) == 0 || kc.config.IdleTopics.contains(name)
+
+		for _, partition := range partitions {
+			tp := topicPartition{topic, partition}
+			owned[tp] = true
+
+			// Newly assigned partitions are timed from now
+			a, ok := kc.activity[tp]
+			if !ok {
+				a = partitionActivity{at: now, offset: -1}
+				kc.activity[tp] = a
+			}
+
+			since := now.Sub(a.at)
+			secondsSinceLastMessage.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(since.Seconds())
+			if !watched || a.alerted || since < kc.config.IdleTimeout {
+				continue
+			}
+			a.alerted = true
+			kc.activity[tp] = a
+			idle[tp] = a.offset
+		}
+	}
+	for tp := range kc.activity {
+		if !owned[tp] {
+			delete(kc.activity, tp)
+			secondsSinceLastMessage.DeleteLabelValues(tp.topic, strconv.Itoa(int(tp.partition)))
+		}
+	}
+	kc.activityMu.Unlock()
+
+	if len(idle) == 0 {
+		return
+	}
+	for tp := range idle {
+		kc.logger().Warn("no message received within the idle timeout", Fields{"topic": tp.topic, "partition": tp.partition, "idle_timeout": kc.config.IdleTimeout.String()})
+	}
+	if kc.config.OnIdle != IdleReconnect {
+		return
+	}
+
+	stalled, err := kc.stalled(idle)
+	if err != nil {
+		kc.logger().Error("cannot check quiet partitions for undelivered messages", errorFields(err))
+		return
+	}
+	if len(stalled) == 0 {
+		return
+	}
+
+	kc.logger().Warn("partitions have undelivered messages, reconnecting consumer", Fields{"partitions": fmt.Sprint(stalled)})
+	idleReconnects.Inc()
+	kc.consumerMu.Lock()
+	err = kc.swapConsumer(kc.subscribed)
+	kc.consumerMu.Unlock()
+	if err != nil {
+		// Consume handles the closed consumer as with any other failure
+		kc.logger().Error("failed to reconnect idle consumer", errorFields(err))
+	}
+
+	// Give the new consumer a full Config.IdleTimeout
+	kc.activityMu.Lock()
+	kc.activity = nil
+	kc.activityMu.Unlock()
+}
+
+// The quiet partitions whose high watermark is ahead of what was received
+// and committed, i.e. that are stalled rather than merely quiet. Partitions
+// nothing was ever received or committed for can't be told apart and are
+// left out.
+func (kc *Client) stalled(idle map[topicPartition]int64) ([]string, error) {
+	committed, err := kc.CommittedOffsets()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := kc.adminConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(kc.brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var stalled []string
+	for tp, last := range idle {
+		position := last + 1
+		if c, ok := committed[tp.topic][tp.partition]; ok && c > position {
+			position = c
+		}
+		if last < 0 && position <= 0 {
+			continue
+		}
+
+		hwm, err := client.GetOffset(tp.topic, tp.partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		if hwm > position {
+			stalled = append(stalled, tp.topic+"/"+strconv.Itoa(int(tp.partition)))
+		}
+	}
+	return stalled, nil
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var inflightHandlers = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "kafka",
+	Name:      "inflight_handlers",
+	Help:      "Message handlers currently running.",
+})
+
+func init() {
+	prometheus.MustRegister(inflightHandlers)
+}
+
+func (kc *Client) handlerStarted() {
+	atomic.AddInt64(&kc.inflightCount, 1)
+	inflightHandlers.Inc()
+}
+
+func (kc *Client) handlerFinished() {
+	atomic.AddInt64(&kc.inflightCount, -1)
+	inflightHandlers.Dec()
+}
+
+// Warn when more than Config.InflightWarnThreshold handlers have been
+// running for longer than Config.InflightWarnDuration. Handlers piling up
+// usually means they are stuck on something, e.g. an unresponsive printer,
+// and memory will keep growing until they recover.
+func (kc *Client) watchInflight() {
+	interval := kc.config.InflightWarnDuration / 6
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var since time.Time
+	for {
+		select {
+		case <-kc.done:
+			return
+		case now := <-ticker.C:
+			n := atomic.LoadInt64(&kc.inflightCount)
+			if n <= int64(kc.config.InflightWarnThreshold) {
+				since = time.Time{}
+				continue
+			}
+			if since.IsZero() {
+				since = now
+				continue
+			}
+			if now.Sub(since) >= kc.config.InflightWarnDuration {
+				kc.logger().Warn("message handlers in flight for long, handlers may be stuck", Fields{"handlers": n, "for": now.Sub(since).Round(time.Second).String()})
+				since = now
+			}
+		}
+	}
+}
@@ -1,39 +1,415 @@
 package kafka
 
 import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"net"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"encoding/base64"
 
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 	"github.com/joeshaw/envdecode"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/xeipuuv/gojsonschema"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// Values of Config.OffsetReset
+const (
+	// OffsetResetNewest starts at the next message produced
+	OffsetResetNewest = "newest"
+	// OffsetResetOldest starts at the oldest message still retained
+	OffsetResetOldest = "oldest"
+)
+
+// Values of Config.IsolationLevel
+const (
+	IsolationReadUncommitted = "read_uncommitted"
+	// IsolationReadCommitted hides records of open and aborted
+	// transactions
+	IsolationReadCommitted = "read_committed"
+)
+
+// Values of Config.PartitionStrategy
+const (
+	PartitionStrategyRoundRobin = "roundrobin"
+	PartitionStrategyRange      = "range"
+	// PartitionStrategySticky keeps partitions where they were across
+	// rebalances as far as balance allows
+	PartitionStrategySticky = "sticky"
 )
 
 // Config : Configuration for Kafka from ENV
 type Config struct {
-	URL           string `env:"KAFKA_URL,required"`
-	TrustedCert   string `env:"KAFKA_TRUSTED_CERT,required"`
-	ClientCertKey string `env:"KAFKA_CLIENT_CERT_KEY,required"`
-	ClientCert    string `env:"KAFKA_CLIENT_CERT,required"`
+	URL           string `env:"KAFKA_URL"`
+	TrustedCert   string `env:"KAFKA_TRUSTED_CERT"`
+	ClientCertKey string `env:"KAFKA_CLIENT_CERT_KEY"`
+	ClientCert    string `env:"KAFKA_CLIENT_CERT"`
 	Prefix        string `env:"KAFKA_PREFIX"`
 	ConsumerGroup string `env:"KAFKA_CONSUMER_GROUP,default=heroku-kafka-demo-go"`
+	Version       string `env:"KAFKA_VERSION"`
+
+	// Topics consumed, without prefix
+	Topics CommaList `env:"KAFKA_TOPICS,default=order_events"`
+
+	// Join a new group of our own, named after ConsumerGroup with a random
+	// suffix, that starts from the oldest offsets, e.g. to inspect a topic
+	// without touching the offsets of the real group
+	EphemeralGroup bool `env:"KAFKA_EPHEMERAL_GROUP"`
+
+	// host:port pairs of the brokers, used instead of URL when set
+	Brokers CommaList `env:"KAFKA_BROKERS"`
+
+	// PEM files (e.g. mounted Kubernetes secrets), taking precedence over
+	// the inline values above when set
+	TrustedCertFile   string `env:"KAFKA_TRUSTED_CERT_FILE"`
+	ClientCertFile    string `env:"KAFKA_CLIENT_CERT_FILE"`
+	ClientCertKeyFile string `env:"KAFKA_CLIENT_CERT_KEY_FILE"`
+	// PKCS#12 bundle with the client cert and key, used when the PEM cert
+	// and key above are not set
+	ClientP12File     string `env:"KAFKA_CLIENT_P12_FILE"`
+	ClientP12Password string `env:"KAFKA_CLIENT_P12_PASSWORD"`
+	// Reload the client cert files on every TLS handshake so rotated certs
+	// are picked up without a restart
+	CertAutoReload bool `env:"KAFKA_CERT_AUTO_RELOAD"`
+	// Oldest TLS version accepted, and the cipher suites offered for TLS 1.2
+	// and below by their Go names, e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Go's defaults when empty.
+	TLSMinVersion   string    `env:"KAFKA_TLS_MIN_VERSION,default=1.2"`
+	TLSCipherSuites CommaList `env:"KAFKA_TLS_CIPHER_SUITES"`
+	// SASL over TLS, one of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, with the
+	// credentials of the user. Off when empty; when set, the client cert
+	// and key are optional.
+	SASLMechanism string `env:"KAFKA_SASL_MECHANISM"`
+	Username      string `env:"KAFKA_USERNAME"`
+	Password      string `env:"KAFKA_PASSWORD"`
+
+	// Per-topic prefixes, e.g. "order_events=tenantA.,print_jobs=tenantB.".
+	// Topics without an entry use Prefix, and so does the consumer group
+	// unless GroupPrefix is set.
+	TopicPrefixes PrefixMap `env:"KAFKA_TOPIC_PREFIXES"`
+	GroupPrefix   string    `env:"KAFKA_GROUP_PREFIX"`
+
+	// Deduplication is disabled unless a cache size is given
+	DedupHeader    string `env:"KAFKA_DEDUP_HEADER,default=idempotency-key"`
+	DedupCacheSize int    `env:"KAFKA_DEDUP_CACHE_SIZE"`
+
+	// What to do when a handler fails permanently: skip, block or crash
+	OnPermanentError string `env:"KAFKA_ON_PERMANENT_ERROR,default=skip"`
+	MaxRetries       int    `env:"KAFKA_MAX_RETRIES"`
+	// Wait before the first retry of a failed handler, doubled on every
+	// retry after it up to RetryBackoffMax
+	RetryBackoff    time.Duration `env:"KAFKA_RETRY_BACKOFF,default=100ms"`
+	RetryBackoffMax time.Duration `env:"KAFKA_RETRY_BACKOFF_MAX,default=10s"`
+	// Skip a message, dead-lettering it, once its handler failed more than
+	// this many times in a row, retries and redeliveries included, instead
+	// of blocking its partition forever. Counted in memory by each process.
+	// 0 disables the check.
+	PoisonThreshold int `env:"KAFKA_POISON_THRESHOLD"`
+	// Commit only the messages handlers Ack, rather than every message
+	// whose handler returned nil. Failed messages are still resolved by
+	// OnPermanentError.
+	ManualAck bool `env:"KAFKA_MANUAL_ACK"`
+
+	// Include the full payload in the receipt log (off for PII reasons)
+	LogPayload bool `env:"KAFKA_LOG_PAYLOAD"`
+	// JSON fields blanked from payloads before they are logged
+	RedactFields CommaList `env:"KAFKA_REDACT_FIELDS"`
+	// Log 1 in N message receipts per partition. Errors are always logged.
+	LogSampleRate int `env:"KAFKA_LOG_SAMPLE_RATE,default=1"`
+
+	// Label metrics with a hash bucket of the key instead of the raw key.
+	// Raw keys give a series per key, only turn it off for small key sets.
+	KeyHashForMetrics bool `env:"KAFKA_KEY_HASH_FOR_METRICS,default=true"`
+
+	// Skipped messages are published here when set, or to <topic>.dlq with
+	// DeadLetterPerTopic, which keeps the dead letters of each topic apart
+	DeadLetterTopic    string `env:"KAFKA_DEAD_LETTER_TOPIC"`
+	DeadLetterPerTopic bool   `env:"KAFKA_DEAD_LETTER_PER_TOPIC"`
+	DLTHeaderPrefix    string `env:"KAFKA_DLT_HEADER_PREFIX,default=x-"`
+
+	// Instead of skipping a failed message, publish it to <topic>.retry to
+	// be handled again after RetryDelay, up to MaxRetryRounds times. With
+	// RetryTiers, the n-th retry goes to <topic>.retry.<delay> of the n-th
+	// tier instead, e.g. <topic>.retry.1m then <topic>.retry.10m, and
+	// MaxRetryRounds and RetryDelay are ignored.
+	RetryTopics    bool          `env:"KAFKA_RETRY_TOPICS"`
+	RetryDelay     time.Duration `env:"KAFKA_RETRY_DELAY,default=5m"`
+	MaxRetryRounds int           `env:"KAFKA_MAX_RETRY_ROUNDS,default=3"`
+	RetryTiers     DurationList  `env:"KAFKA_RETRY_TIERS"`
+
+	// JSON schema the values of SchemaTopics are validated against before
+	// they are handled. Invalid messages go to DeadLetterTopic.
+	SchemaFile   string    `env:"KAFKA_SCHEMA_FILE"`
+	SchemaTopics CommaList `env:"KAFKA_SCHEMA_TOPICS"`
+
+	// Base64 AES key the values of EncryptedTopics are AES-GCM encrypted
+	// with, on publish and consume. Messages that can't be decrypted go to
+	// DeadLetterTopic.
+	EncryptionKey   string    `env:"KAFKA_ENCRYPTION_KEY"`
+	EncryptedTopics CommaList `env:"KAFKA_ENCRYPTED_TOPICS"`
+
+	// What to do when a topic's decoder fails, per topic, e.g.
+	// "order_events=dlt". See SetDecoder.
+	OnDecodeError DecodePolicies `env:"KAFKA_ON_DECODE_ERROR"`
+
+	// Newest schema-version header this consumer understands, unchecked
+	// when 0, and the decode error policy for messages that are newer
+	MaxSchemaVersion        int    `env:"KAFKA_MAX_SCHEMA_VERSION"`
+	OnSchemaVersionMismatch string `env:"KAFKA_ON_SCHEMA_VERSION_MISMATCH,default=fail"`
+
+	// Largest message the consumer fetches, unlimited when 0. Larger
+	// messages block their partition unless SkipOversized is set.
+	FetchMax      int32 `env:"KAFKA_FETCH_MAX"`
+	SkipOversized bool  `env:"KAFKA_SKIP_OVERSIZED"`
+
+	// Size of the internal sarama channels. Larger buffers allow more
+	// messages in flight for higher throughput, at the cost of memory.
+	ChannelBufferSize int `env:"KAFKA_CHANNEL_BUFFER_SIZE,default=256"`
+
+	// Producer retries on broker errors such as leader elections. Without
+	// an idempotent producer a retry can write a message twice.
+	ProducerRetryMax     int           `env:"KAFKA_PRODUCER_RETRY_MAX,default=3"`
+	ProducerRetryBackoff time.Duration `env:"KAFKA_PRODUCER_RETRY_BACKOFF,default=100ms"`
+	ProducerIdempotent   bool          `env:"KAFKA_PRODUCER_IDEMPOTENT"`
+	// Requests the producers send a broker before waiting for a response,
+	// sarama's default of 5 (1 when idempotent) when 0. With more than one
+	// a retried request can land after a later one.
+	MaxOpenRequests int `env:"KAFKA_MAX_OPEN_REQUESTS"`
+
+	// Batching of the async producer: a batch is sent once any of these
+	// triggers is reached. Batching raises throughput but delays each
+	// message by up to FlushFrequency.
+	FlushMessages  int           `env:"KAFKA_FLUSH_MESSAGES,default=1"`
+	FlushBytes     int           `env:"KAFKA_FLUSH_BYTES"`
+	FlushFrequency time.Duration `env:"KAFKA_FLUSH_FREQUENCY"`
+
+	// Report deliveries of the async producer, logging them and counting
+	// them in kafka_messages_delivered_total. Needs Consume running, which
+	// drains the deliveries, or the producer stalls.
+	TrackSuccesses bool `env:"KAFKA_TRACK_SUCCESSES"`
+	// Log the broker leading the partition of every delivery report and
+	// count reports by broker on the debug endpoint
+	Debug bool `env:"KAFKA_DEBUG"`
+
+	// Registry sarama records its broker-level metrics into. A new
+	// registry is created on Connect when left nil.
+	MetricRegistry metrics.Registry
+	// Where the client logs to, StdLogger when left nil
+	Logger Logger
+
+	// Where partitions without a committed offset, or whose committed offset
+	// is out of range, start: newest or oldest
+	OffsetReset string `env:"KAFKA_OFFSET_RESET,default=newest"`
+	// Which records consumers read when producers use transactions:
+	// read_uncommitted or read_committed
+	IsolationLevel string `env:"KAFKA_ISOLATION_LEVEL,default=read_uncommitted"`
+
+	// Consumer group membership. The rebalance timeout must stay below the
+	// session timeout, otherwise a member waiting on a slow join can be
+	// evicted by the coordinator before the join completes.
+	SessionTimeout        time.Duration `env:"KAFKA_SESSION_TIMEOUT,default=30s"`
+	RebalanceTimeout      time.Duration `env:"KAFKA_REBALANCE_TIMEOUT,default=20s"`
+	RebalanceRetryMax     int           `env:"KAFKA_REBALANCE_RETRY_MAX,default=4"`
+	RebalanceRetryBackoff time.Duration `env:"KAFKA_REBALANCE_RETRY_BACKOFF,default=2s"`
+	// How partitions are assigned to the members of the group: roundrobin,
+	// range, or sticky with NativeConsumerGroup
+	PartitionStrategy string `env:"KAFKA_PARTITION_STRATEGY,default=roundrobin"`
+	// Consume with sarama's native consumer group. sarama-cluster, which is
+	// deprecated and doesn't work with newer sarama releases, is only used
+	// when disabled, as a fallback until it is removed.
+	NativeConsumerGroup bool `env:"KAFKA_NATIVE_CONSUMER_GROUP,default=true"`
+
+	// Retries of failed offset commits, with the backoff doubling after
+	// each. When they all fail, consumption can be paused until the
+	// rebalance that follows. sarama-cluster only: sarama's consumer group
+	// can't commit on demand, so with NativeConsumerGroup the retries don't
+	// apply and PauseOnCommitFailure is rejected.
+	CommitRetryMax       int           `env:"KAFKA_COMMIT_RETRY_MAX,default=3"`
+	CommitRetryBackoff   time.Duration `env:"KAFKA_COMMIT_RETRY_BACKOFF,default=500ms"`
+	PauseOnCommitFailure bool          `env:"KAFKA_PAUSE_ON_COMMIT_FAILURE"`
+
+	// How long group coordinator errors may keep coming, e.g. while
+	// brokers restart, before they are logged again
+	CoordinatorErrorThreshold time.Duration `env:"KAFKA_COORDINATOR_ERROR_THRESHOLD,default=1m"`
+
+	// Recreate the consumer when it stops delivering messages instead of
+	// returning ErrConsumerClosed from Consume
+	AutoReconnect    bool          `env:"KAFKA_AUTO_RECONNECT"`
+	ReconnectBackoff time.Duration `env:"KAFKA_RECONNECT_BACKOFF,default=5s"`
+
+	// Round-trip self test, off by default as it writes to the cluster
+	EnableSelfTest  bool          `env:"KAFKA_ENABLE_SELF_TEST"`
+	HealthTopic     string        `env:"KAFKA_HEALTH_TOPIC,default=health_checks"`
+	SelfTestTimeout time.Duration `env:"KAFKA_SELF_TEST_TIMEOUT,default=10s"`
+
+	// How long a health check may wait on the brokers or a publish on the
+	// producer, and how long checks may fail before the client is deemed
+	// wedged
+	HealthCheckTimeout time.Duration `env:"KAFKA_HEALTH_CHECK_TIMEOUT,default=5s"`
+	HealthStallTimeout time.Duration `env:"KAFKA_HEALTH_STALL_TIMEOUT,default=1m"`
+
+	// Handle messages with the same key in order on one of Workers workers,
+	// instead of a goroutine per message
+	OrderedByKey bool `env:"KAFKA_ORDERED_BY_KEY"`
+	Workers      int  `env:"KAFKA_WORKERS,default=16"`
+	// Otherwise how many messages of a partition are handled at once. More
+	// than one gives up ordering within the partition for throughput.
+	MaxConcurrentPerPartition int `env:"KAFKA_MAX_CONCURRENT_PER_PARTITION,default=1"`
+
+	// Periodically publish the progress of this consumer here, off unless
+	// a topic is given
+	StatusTopic    string        `env:"KAFKA_STATUS_TOPIC"`
+	StatusInterval time.Duration `env:"KAFKA_STATUS_INTERVAL,default=30s"`
+
+	// Where EmitJobStatus publishes print job status events
+	PrintJobStatusTopic string `env:"KAFKA_PRINT_JOB_STATUS_TOPIC,default=print_job_status"`
+
+	// How long an assigned partition of IdleTopics (all topics when empty)
+	// may go without messages before OnIdle applies, disabled when zero
+	IdleTimeout time.Duration `env:"KAFKA_IDLE_TIMEOUT"`
+	IdleTopics  CommaList     `env:"KAFKA_IDLE_TOPICS"`
+	OnIdle      string        `env:"KAFKA_ON_IDLE,default=alert"`
+
+	// Skip messages older than this instead of handling them, e.g. print
+	// jobs the customer won't wait for anymore. 0 disables the check.
+	// Needs KAFKA_VERSION >= 0.10.0 for messages to carry a timestamp.
+	MaxMessageAge time.Duration `env:"KAFKA_MAX_MESSAGE_AGE"`
+
+	// Stop consuming after this many messages, e.g. to reprocess a fixed
+	// number of jobs. 0 means no limit.
+	MaxMessages int `env:"KAFKA_MAX_MESSAGES"`
+
+	// Batches collected by ConsumeBatch
+	BatchSize    int           `env:"KAFKA_BATCH_SIZE,default=100"`
+	BatchTimeout time.Duration `env:"KAFKA_BATCH_TIMEOUT,default=1s"`
+
+	// Warn when more handlers than the threshold have been running for
+	// longer than the duration. A threshold of 0 disables the warning.
+	InflightWarnThreshold int           `env:"KAFKA_INFLIGHT_WARN_THRESHOLD,default=1000"`
+	InflightWarnDuration  time.Duration `env:"KAFKA_INFLIGHT_WARN_DURATION,default=1m"`
+
+	// Spool messages that can't be published to a local file and replay
+	// them once the brokers are reachable again
+	EnableSpool         bool          `env:"KAFKA_ENABLE_SPOOL"`
+	SpoolDir            string        `env:"KAFKA_SPOOL_DIR,default=spool"`
+	SpoolMaxBytes       int64         `env:"KAFKA_SPOOL_MAX_BYTES,default=104857600"`
+	SpoolReplayInterval time.Duration `env:"KAFKA_SPOOL_REPLAY_INTERVAL,default=10s"`
+
+	// How long Shutdown waits for in-flight handlers to finish
+	ShutdownTimeout time.Duration `env:"KAFKA_SHUTDOWN_TIMEOUT,default=30s"`
+
+	// Set by Connect with EphemeralGroup
+	ephemeralSuffix string
 }
 
 // Client : exported kafka
 type Client struct {
-	Producer sarama.AsyncProducer
-	Consumer *cluster.Consumer
+	// The sarama producers behind the default Producer, for direct use
+	Producer     sarama.AsyncProducer
+	SyncProducer sarama.SyncProducer
+	Consumer     GroupConsumer
+
+	// Consumers of the retry tiers, created by the first consume call and
+	// read by the loops of the latest one, retryCtx, until it returns
+	retryMu        sync.Mutex
+	retryConsumers []GroupConsumer
+	retryCtx       context.Context
+	retryLoops     sync.WaitGroup
+	producer       Producer
+	customProducer bool
+
+	config    *Config
+	brokers   []string
+	tlsConfig *tls.Config
+	dedup     *dedupCache
+	archiver  *archiver
+	latency   metrics.Histogram
+
+	revokedHooks []func(topic string, partitions []int32)
+	redactor     func([]byte) []byte
+	decoders     map[string]Decoder
+	codecs       map[string]Codec
+	schema       *gojsonschema.Schema
+	aead         cipher.AEAD
+	keyFunc      func([]byte) ([]byte, error)
+	middlewares  []Middleware
+	headerRoutes []headerRoute
+	topicRoutes  map[string]Handler
+	spanHooks    SpanHooks
+
+	publishFailedHooks []func(*PublishError)
+	shutdownHooks      []func(context.Context) error
+
+	receiptsMu sync.Mutex
+	receipts   map[topicPartition]uint64
 
-	config *Config
+	blockedMu sync.Mutex
+	blocked   map[string]map[int32]bool
+
+	failuresMu sync.Mutex
+	failures   map[topicPartition]offsetFailures
+
+	activityMu sync.Mutex
+	activity   map[topicPartition]partitionActivity
+
+	processedMu sync.Mutex
+	processed   chan ProcessedEvent
+
+	// Delivery reports by broker, with Config.Debug
+	deliveriesMu   sync.Mutex
+	deliveries     map[string]*brokerDeliveries
+	metadata       sarama.Client
+	metadataClosed bool
+
+	watchMu   sync.Mutex
+	stopWatch chan struct{}
+
+	health healthState
+
+	inflight      sync.WaitGroup
+	inflightCount int64
+	drainMu       sync.RWMutex
+	draining      bool // Set once Shutdown waits for the handlers
+
+	// Closed on Shutdown to stop background goroutines
+	done     chan struct{}
+	stopOnce sync.Once // Makes Shutdown run only once
+
+	// Guards Consumer and its topics while it is being recreated
+	consumerMu sync.RWMutex
+	subscribed []string
+
+	retryingCommit int32
+
+	// When group coordinator errors started, zero once reachable again
+	coordinatorMu     sync.Mutex
+	coordinatorSince  time.Time
+	coordinatorLogged time.Time
+	// Closed when consumption resumes after a failed offset commit
+	pauseMu sync.Mutex
+	paused  chan struct{}
+
+	producerMu     sync.RWMutex
+	producerClosed bool
+	// Closed on Shutdown before the producers are, unblocking publishes
+	closing chan struct{}
+
+	connectMu sync.Mutex
+	connected bool
 }
 
 // Message is the raw data received by a consumer
@@ -43,15 +419,47 @@ type Message struct {
 	Topic     string          `json:"topic"`
 	Value     string          `json:"value"`
 	Metadata  messageMetadata `json:"metadata"`
+	// Record headers, by key. Of a header sent more than once, the last
+	// value is kept. Empty before KAFKA_VERSION 0.11.0.
+	Headers map[string][]byte `json:"headers,omitempty"`
+
+	tombstone    bool
+	contentType  string
+	codec        Codec
+	logicalTopic string
+	ack          func()
+	log          Logger
+}
+
+// LogicalTopic : The topic without its configured prefix, e.g.
+// "order_events" for "tenantA.order_events". Topics that don't carry
+// their prefix are returned as is.
+func (m Message) LogicalTopic() string {
+	if m.logicalTopic == "" {
+		return m.Topic
+	}
+	return m.logicalTopic
+}
+
+// Header : The value of a header of the message, and whether it was set
+func (m Message) Header(key string) (string, bool) {
+	v, ok := m.Headers[key]
+	return string(v), ok
+}
+
+// IsTombstone : Reports whether the message is a tombstone, i.e. its value
+// is nil rather than merely empty. On compacted topics this marks the key
+// as deleted.
+func (m Message) IsTombstone() bool {
+	return m.tombstone
 }
 
 type messageMetadata struct {
 	ReceivedAt time.Time `json:"received_at"`
 }
 
-// Connect : Connects to the Kafka brokers
-func (kc *Client) Connect() *Client {
-	fmt.Println("Connecting to Kafka brokers...")
+// LoadConfig : Loads the Kafka configuration from ENV
+func LoadConfig() *Config {
 	config := Config{}
 	envdecode.MustDecode(&config)
 
@@ -63,114 +471,786 @@ func (kc *Client) Connect() *Client {
 		config.ClientCert = decodeBase64(config.ClientCert)
 	}
 
-	tlsConfig := config.createTLSConfig()
-	brokerAddrs := config.brokerAddresses()
+	return &config
+}
+
+// NewClient : Creates a client for the given configuration, for callers
+// that don't load it from ENV. The cert values must be PEM, not base64.
+func NewClient(cfg *Config, opts ...ClientOption) *Client {
+	kc := &Client{config: cfg}
+	for _, opt := range opts {
+		opt(kc)
+	}
+	return kc
+}
+
+// ErrAlreadyConnected is returned by Connect when the client is connected
+var ErrAlreadyConnected = errors.New("kafka: already connected")
+
+// Connect : Connects to the Kafka brokers, loading the configuration from
+// ENV unless the client was created with NewClient. Cancelling ctx aborts
+// the connection attempt, closing anything that was already created, so
+// Connect can be called again after it failed. Once connected it returns
+// ErrAlreadyConnected, and after Shutdown ErrProducerClosed.
+func (kc *Client) Connect(ctx context.Context) (err error) {
+	kc.connectMu.Lock()
+	defer kc.connectMu.Unlock()
+
+	if kc.connected {
+		return ErrAlreadyConnected
+	}
+	kc.producerMu.RLock()
+	closed := kc.producerClosed
+	kc.producerMu.RUnlock()
+	if closed {
+		return ErrProducerClosed
+	}
+
+	if kc.config == nil {
+		kc.config = LoadConfig()
+	}
+	config := kc.config
+	config.logger().Info("connecting to Kafka brokers", nil)
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.EphemeralGroup && config.ephemeralSuffix == "" {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return err
+		}
+		config.ephemeralSuffix = fmt.Sprintf("-ephemeral-%x", suffix)
+		config.logger().Warn("joining ephemeral consumer group, delete it when done", Fields{"group": config.group()})
+	}
+	config.logSummary()
+
+	version, err := config.kafkaVersion(sarama.MinVersion)
+	if err != nil {
+		return err
+	}
+	if config.DedupCacheSize > 0 && !version.IsAtLeast(sarama.V0_11_0_0) {
+		config.logger().Warn("message headers require KAFKA_VERSION >= 0.11.0, deduplication will have no effect", nil)
+	}
+
+	if config.MetricRegistry == nil {
+		config.MetricRegistry = metrics.NewRegistry()
+	}
+	config.exportProducerMetrics()
+	saramaMetrics.setRegistry(config.MetricRegistry)
+
+	if config.SchemaFile != "" {
+		if kc.schema, err = loadSchema(config.SchemaFile); err != nil {
+			return err
+		}
+	}
+
+	if config.EncryptionKey != "" {
+		if kc.aead, err = newAEAD(config.EncryptionKey); err != nil {
+			return err
+		}
+	}
+
+	tlsConfig, err := config.createTLSConfig()
+	if err != nil {
+		return err
+	}
+	brokerAddrs, err := config.BrokerAddresses()
+	if err != nil {
+		return err
+	}
+	config.logger().Info("brokers", Fields{"brokers": strings.Join(brokerAddrs, ",")})
+
+	trustedCert, err := config.trustedCert()
+	if err != nil {
+		return err
+	}
 
 	// verify broker certs
 	for _, b := range brokerAddrs {
-		ok, err := verifyServerCert(tlsConfig, config.TrustedCert, b)
+		ok, err := verifyServerCert(ctx, tlsConfig, trustedCert, b)
 		if err != nil {
-			log.Fatal("Get Server Cert Error: ", err)
+			return fmt.Errorf("get server cert error for broker %s: %v", b, err)
 		}
 
 		if !ok {
-			log.Fatalf("Broker %s has invalid certificate!", b)
+			return fmt.Errorf("broker %s has invalid certificate", b)
 		}
 	}
-	log.Println("All broker server certificates are valid!")
+	config.logger().Info("all broker server certificates are valid", nil)
 
-	kc.Consumer = config.createKafkaConsumer(brokerAddrs, tlsConfig)
-	kc.Producer = config.createKafkaProducer(brokerAddrs, tlsConfig)
-	kc.config = &config
-	return kc
+	// Close whatever was created if a later step fails or ctx is cancelled
+	var consumer GroupConsumer
+	var producer sarama.AsyncProducer
+	var syncProducer sarama.SyncProducer
+	defer func() {
+		if err == nil {
+			return
+		}
+		if consumer != nil {
+			consumer.Close()
+		}
+		if producer != nil {
+			producer.Close()
+		}
+		if syncProducer != nil {
+			syncProducer.Close()
+		}
+	}()
+
+	topics := config.topics()
+	if consumer, err = config.createKafkaConsumer(brokerAddrs, tlsConfig, config.group(), topics); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if producer, err = config.createKafkaProducer(brokerAddrs, tlsConfig); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if syncProducer, err = config.createKafkaSyncProducer(brokerAddrs, tlsConfig); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// A producer set with SetProducer is kept
+	closing := make(chan struct{})
+	pub := kc.producer
+	if pub == nil {
+		p := &SaramaProducer{
+			Async:   producer,
+			Sync:    syncProducer,
+			Version: version,
+			closing: closing,
+		}
+		pub = p
+		if config.EnableSpool {
+			// Replays what a previous run left behind before going on
+			sp, err := newSpoolingProducer(p, config.SpoolDir, config.SpoolMaxBytes, config.SpoolReplayInterval, config.logger())
+			if err != nil {
+				return err
+			}
+			pub = sp
+		}
+	}
+
+	// Nothing is set on the client until every step succeeded, so a failed
+	// attempt can be retried
+	kc.Consumer = consumer
+	kc.subscribed = topics
+	kc.Producer = producer
+	kc.SyncProducer = syncProducer
+	kc.customProducer = kc.producer != nil
+	kc.producer = pub
+	kc.brokers = brokerAddrs
+	kc.tlsConfig = tlsConfig
+
+	kc.latency = newLatencyHistogram()
+	kc.done = make(chan struct{})
+	kc.closing = closing
+
+	go kc.watchProducer(producer)
+	if config.InflightWarnThreshold > 0 {
+		go kc.watchInflight()
+	}
+	if config.IdleTimeout > 0 {
+		go kc.watchIdle()
+	}
+	if config.StatusTopic != "" {
+		go kc.reportStatus()
+	}
+
+	if kc.redactor == nil && len(config.RedactFields) > 0 {
+		kc.redactor = JSONFieldRedactor(config.RedactFields)
+	}
+
+	if config.DedupCacheSize > 0 {
+		kc.dedup = newDedupCache(config.DedupCacheSize)
+	}
+
+	kc.connected = true
+	return nil
+}
+
+// Connected : Reports whether Connect succeeded and Shutdown wasn't called
+// since
+func (kc *Client) Connected() bool {
+	kc.connectMu.Lock()
+	defer kc.connectMu.Unlock()
+
+	return kc.connected
+}
+
+// Validate : Checks the configuration for values that can't work
+func (kc *Config) Validate() error {
+	if _, err := kc.BrokerAddresses(); err != nil {
+		return err
+	}
+	version, err := kc.kafkaVersion(sarama.MinVersion)
+	if err != nil {
+		return err
+	}
+
+	type certEnv struct{ name, inline, file string }
+	certs := []certEnv{
+		{"KAFKA_TRUSTED_CERT", kc.TrustedCert, kc.TrustedCertFile},
+	}
+	// A PKCS#12 bundle can stand in for the client cert and key, and SASL
+	// can replace them altogether
+	if kc.SASLMechanism == "" && (kc.ClientP12File == "" || kc.hasPEMClientCert()) {
+		certs = append(certs,
+			certEnv{"KAFKA_CLIENT_CERT_KEY", kc.ClientCertKey, kc.ClientCertKeyFile},
+			certEnv{"KAFKA_CLIENT_CERT", kc.ClientCert, kc.ClientCertFile},
+		)
+	}
+	for _, c := range certs {
+		if c.inline == "" && c.file == "" {
+			return fmt.Errorf("either %s or %s_FILE must be set", c.name, c.name)
+		}
+	}
+
+	if kc.CertAutoReload && (kc.ClientCertFile == "" || kc.ClientCertKeyFile == "") {
+		return errors.New("KAFKA_CERT_AUTO_RELOAD requires KAFKA_CLIENT_CERT_FILE and KAFKA_CLIENT_CERT_KEY_FILE")
+	}
+	if _, err := kc.tlsMinVersion(); err != nil {
+		return err
+	}
+	if _, err := kc.tlsCipherSuites(); err != nil {
+		return err
+	}
+	switch kc.SASLMechanism {
+	case "":
+	case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+		if kc.Username == "" || kc.Password == "" {
+			return fmt.Errorf("KAFKA_SASL_MECHANISM %s requires KAFKA_USERNAME and KAFKA_PASSWORD", kc.SASLMechanism)
+		}
+	default:
+		return fmt.Errorf("KAFKA_SASL_MECHANISM must be one of %s, %s or %s, got %q",
+			SASLPlain, SASLScramSHA256, SASLScramSHA512, kc.SASLMechanism)
+	}
+
+	switch kc.OnPermanentError {
+	case PermanentErrorSkip, PermanentErrorBlock, PermanentErrorCrash:
+	default:
+		return fmt.Errorf("KAFKA_ON_PERMANENT_ERROR must be one of %s, %s or %s, got %q",
+			PermanentErrorSkip, PermanentErrorBlock, PermanentErrorCrash, kc.OnPermanentError)
+	}
+
+	if kc.OrderedByKey && kc.Workers < 1 {
+		return fmt.Errorf("KAFKA_WORKERS must be at least 1, got %d", kc.Workers)
+	}
+	if kc.MaxConcurrentPerPartition < 1 {
+		return fmt.Errorf("KAFKA_MAX_CONCURRENT_PER_PARTITION must be at least 1, got %d", kc.MaxConcurrentPerPartition)
+	}
+
+	if kc.StatusTopic != "" && kc.StatusInterval <= 0 {
+		return fmt.Errorf("KAFKA_STATUS_INTERVAL must be positive, got %s", kc.StatusInterval)
+	}
+	switch kc.OffsetReset {
+	case OffsetResetNewest, OffsetResetOldest:
+	default:
+		return fmt.Errorf("KAFKA_OFFSET_RESET must be %s or %s, got %q", OffsetResetNewest, OffsetResetOldest, kc.OffsetReset)
+	}
+	switch kc.IsolationLevel {
+	case IsolationReadUncommitted:
+	case IsolationReadCommitted:
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.New("KAFKA_ISOLATION_LEVEL read_committed requires KAFKA_VERSION >= 0.11.0")
+		}
+	default:
+		return fmt.Errorf("KAFKA_ISOLATION_LEVEL must be %s or %s, got %q", IsolationReadUncommitted, IsolationReadCommitted, kc.IsolationLevel)
+	}
+	if kc.IdleTimeout < 0 {
+		return fmt.Errorf("KAFKA_IDLE_TIMEOUT must not be negative, got %s", kc.IdleTimeout)
+	}
+	switch kc.OnIdle {
+	case IdleAlert, IdleReconnect:
+	default:
+		return fmt.Errorf("KAFKA_ON_IDLE must be %s or %s, got %q", IdleAlert, IdleReconnect, kc.OnIdle)
+	}
+
+	if kc.MaxMessageAge < 0 {
+		return fmt.Errorf("KAFKA_MAX_MESSAGE_AGE must not be negative, got %s", kc.MaxMessageAge)
+	}
+	if kc.MaxMessageAge > 0 && !version.IsAtLeast(sarama.V0_10_0_0) {
+		return errors.New("KAFKA_MAX_MESSAGE_AGE requires KAFKA_VERSION >= 0.10.0 for message timestamps")
+	}
+
+	if kc.MaxMessages < 0 {
+		return fmt.Errorf("KAFKA_MAX_MESSAGES must not be negative, got %d", kc.MaxMessages)
+	}
+
+	if kc.BatchSize < 1 || kc.BatchTimeout <= 0 {
+		return fmt.Errorf("KAFKA_BATCH_SIZE must be at least 1 and KAFKA_BATCH_TIMEOUT positive, got %d and %s",
+			kc.BatchSize, kc.BatchTimeout)
+	}
+
+	if kc.LogSampleRate < 1 {
+		return fmt.Errorf("KAFKA_LOG_SAMPLE_RATE must be at least 1, got %d", kc.LogSampleRate)
+	}
+
+	if kc.MaxRetries < 0 {
+		return fmt.Errorf("KAFKA_MAX_RETRIES must not be negative, got %d", kc.MaxRetries)
+	}
+	if kc.RetryBackoff < 0 || kc.RetryBackoffMax < kc.RetryBackoff {
+		return fmt.Errorf("KAFKA_RETRY_BACKOFF must not be negative nor exceed KAFKA_RETRY_BACKOFF_MAX, got %s and %s",
+			kc.RetryBackoff, kc.RetryBackoffMax)
+	}
+	if kc.RetryTopics {
+		if kc.OnPermanentError != PermanentErrorSkip {
+			return errors.New("KAFKA_RETRY_TOPICS requires KAFKA_ON_PERMANENT_ERROR=skip")
+		}
+		if kc.RetryDelay <= 0 || kc.MaxRetryRounds < 1 {
+			return fmt.Errorf("KAFKA_RETRY_DELAY must be positive and KAFKA_MAX_RETRY_ROUNDS at least 1, got %s and %d",
+				kc.RetryDelay, kc.MaxRetryRounds)
+		}
+		seen := make(map[string]bool, len(kc.RetryTiers))
+		for _, d := range kc.RetryTiers {
+			if d <= 0 {
+				return fmt.Errorf("KAFKA_RETRY_TIERS must be positive, got %s", d)
+			}
+			name := shortDuration(d)
+			if seen[name] {
+				return fmt.Errorf("KAFKA_RETRY_TIERS must not repeat a delay, got %s twice", name)
+			}
+			seen[name] = true
+		}
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.New("KAFKA_RETRY_TOPICS requires KAFKA_VERSION >= 0.11.0 to carry headers")
+		}
+	}
+	if kc.HealthCheckTimeout <= 0 || kc.HealthStallTimeout <= 0 {
+		return fmt.Errorf("KAFKA_HEALTH_CHECK_TIMEOUT and KAFKA_HEALTH_STALL_TIMEOUT must be positive, got %s and %s",
+			kc.HealthCheckTimeout, kc.HealthStallTimeout)
+	}
+	if kc.PoisonThreshold < 0 {
+		return fmt.Errorf("KAFKA_POISON_THRESHOLD must not be negative, got %d", kc.PoisonThreshold)
+	}
+
+	if kc.FetchMax < 0 {
+		return fmt.Errorf("KAFKA_FETCH_MAX must not be negative, got %d", kc.FetchMax)
+	}
+
+	if kc.ChannelBufferSize < 0 {
+		return fmt.Errorf("KAFKA_CHANNEL_BUFFER_SIZE must not be negative, got %d", kc.ChannelBufferSize)
+	}
+
+	if kc.ProducerRetryMax < 0 {
+		return fmt.Errorf("KAFKA_PRODUCER_RETRY_MAX must not be negative, got %d", kc.ProducerRetryMax)
+	}
+	if kc.ProducerRetryBackoff < 0 {
+		return fmt.Errorf("KAFKA_PRODUCER_RETRY_BACKOFF must not be negative, got %s", kc.ProducerRetryBackoff)
+	}
+	if kc.MaxOpenRequests < 0 {
+		return fmt.Errorf("KAFKA_MAX_OPEN_REQUESTS must not be negative, got %d", kc.MaxOpenRequests)
+	}
+	if kc.ProducerIdempotent {
+		if kc.MaxOpenRequests > 1 {
+			return fmt.Errorf("KAFKA_PRODUCER_IDEMPOTENT requires KAFKA_MAX_OPEN_REQUESTS of 1 or unset, got %d", kc.MaxOpenRequests)
+		}
+		if kc.ProducerRetryMax < 1 {
+			return errors.New("KAFKA_PRODUCER_IDEMPOTENT requires KAFKA_PRODUCER_RETRY_MAX >= 1")
+		}
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.New("KAFKA_PRODUCER_IDEMPOTENT requires KAFKA_VERSION >= 0.11.0")
+		}
+	}
+
+	if kc.RebalanceTimeout <= 0 || kc.RebalanceTimeout >= kc.SessionTimeout {
+		return fmt.Errorf("KAFKA_REBALANCE_TIMEOUT must be positive and less than KAFKA_SESSION_TIMEOUT (%s), got %s",
+			kc.SessionTimeout, kc.RebalanceTimeout)
+	}
+	if kc.RebalanceRetryMax < 0 {
+		return fmt.Errorf("KAFKA_REBALANCE_RETRY_MAX must not be negative, got %d", kc.RebalanceRetryMax)
+	}
+	if kc.EnableSpool {
+		if kc.SpoolDir == "" {
+			return errors.New("KAFKA_ENABLE_SPOOL requires KAFKA_SPOOL_DIR")
+		}
+		if kc.SpoolMaxBytes <= 0 || kc.SpoolReplayInterval <= 0 {
+			return errors.New("KAFKA_SPOOL_MAX_BYTES and KAFKA_SPOOL_REPLAY_INTERVAL must be positive")
+		}
+	}
+
+	if len(kc.Topics) == 0 {
+		return errors.New("KAFKA_TOPICS must list at least one topic")
+	}
+	if kc.CoordinatorErrorThreshold <= 0 {
+		return fmt.Errorf("KAFKA_COORDINATOR_ERROR_THRESHOLD must be positive, got %s", kc.CoordinatorErrorThreshold)
+	}
+	if kc.CommitRetryMax < 0 || kc.CommitRetryBackoff <= 0 {
+		return fmt.Errorf("KAFKA_COMMIT_RETRY_MAX must not be negative and KAFKA_COMMIT_RETRY_BACKOFF must be positive, got %d and %s",
+			kc.CommitRetryMax, kc.CommitRetryBackoff)
+	}
+
+	if kc.NativeConsumerGroup && kc.Version != "" && !version.IsAtLeast(nativeGroupMinVersion) {
+		return errors.New("KAFKA_NATIVE_CONSUMER_GROUP requires KAFKA_VERSION >= 0.10.2, set it to false for older clusters")
+	}
+	if kc.NativeConsumerGroup && kc.PauseOnCommitFailure {
+		return errors.New("KAFKA_PAUSE_ON_COMMIT_FAILURE requires KAFKA_NATIVE_CONSUMER_GROUP=false, sarama's consumer group can't retry commits")
+	}
+	switch kc.PartitionStrategy {
+	case PartitionStrategyRoundRobin, PartitionStrategyRange:
+	case PartitionStrategySticky:
+		// sarama-cluster only implements range and roundrobin
+		if !kc.NativeConsumerGroup {
+			return errors.New("KAFKA_PARTITION_STRATEGY sticky requires KAFKA_NATIVE_CONSUMER_GROUP")
+		}
+	default:
+		return fmt.Errorf("KAFKA_PARTITION_STRATEGY must be %s, %s or %s, got %q",
+			PartitionStrategyRoundRobin, PartitionStrategyRange, PartitionStrategySticky, kc.PartitionStrategy)
+	}
+
+	if kc.FlushMessages < 0 || kc.FlushBytes < 0 || kc.FlushFrequency < 0 {
+		return errors.New("KAFKA_FLUSH_MESSAGES, KAFKA_FLUSH_BYTES and KAFKA_FLUSH_FREQUENCY must not be negative")
+	}
+	if kc.FlushMessages == 0 && kc.FlushBytes == 0 && kc.FlushFrequency == 0 {
+		return errors.New("at least one of KAFKA_FLUSH_MESSAGES, KAFKA_FLUSH_BYTES or KAFKA_FLUSH_FREQUENCY must be set")
+	}
+
+	if kc.deadLettering() && !version.IsAtLeast(sarama.V0_11_0_0) {
+		return errors.New("dead-lettering requires KAFKA_VERSION >= 0.11.0 to carry headers")
+	}
+	if kc.SchemaFile != "" && (len(kc.SchemaTopics) == 0 || !kc.deadLettering()) {
+		return errors.New("KAFKA_SCHEMA_FILE requires KAFKA_SCHEMA_TOPICS and KAFKA_DEAD_LETTER_TOPIC or KAFKA_DEAD_LETTER_PER_TOPIC")
+	}
+	if len(kc.EncryptedTopics) > 0 && (kc.EncryptionKey == "" || !kc.deadLettering()) {
+		return errors.New("KAFKA_ENCRYPTED_TOPICS requires KAFKA_ENCRYPTION_KEY and KAFKA_DEAD_LETTER_TOPIC or KAFKA_DEAD_LETTER_PER_TOPIC")
+	}
+	if kc.EncryptionKey != "" {
+		if _, err := newAEAD(kc.EncryptionKey); err != nil {
+			return err
+		}
+	}
+	if kc.MaxSchemaVersion < 0 {
+		return fmt.Errorf("KAFKA_MAX_SCHEMA_VERSION must not be negative, got %d", kc.MaxSchemaVersion)
+	}
+	if kc.MaxSchemaVersion > 0 {
+		switch kc.OnSchemaVersionMismatch {
+		case DecodeErrorFail, DecodeErrorSkip:
+		case DecodeErrorDLT:
+			if !kc.deadLettering() {
+				return errors.New("KAFKA_ON_SCHEMA_VERSION_MISMATCH=dlt requires KAFKA_DEAD_LETTER_TOPIC or KAFKA_DEAD_LETTER_PER_TOPIC")
+			}
+		default:
+			return fmt.Errorf("KAFKA_ON_SCHEMA_VERSION_MISMATCH must be %s, %s or %s, got %q",
+				DecodeErrorFail, DecodeErrorDLT, DecodeErrorSkip, kc.OnSchemaVersionMismatch)
+		}
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return errors.New("KAFKA_MAX_SCHEMA_VERSION requires KAFKA_VERSION >= 0.11.0 to carry headers")
+		}
+	}
+	for topic, policy := range kc.OnDecodeError {
+		if policy == DecodeErrorDLT && !kc.deadLettering() {
+			return fmt.Errorf("KAFKA_ON_DECODE_ERROR %s=dlt requires KAFKA_DEAD_LETTER_TOPIC or KAFKA_DEAD_LETTER_PER_TOPIC", topic)
+		}
+	}
+
+	return nil
 }
 
 func decodeBase64(base64Data string) string {
 	value, err := base64.StdEncoding.DecodeString(base64Data)
 	if err != nil {
-		log.Fatal("Cannot parse base64")
+		fatal(defaultLogger, "cannot parse base64", errorFields(err))
 	}
 	return string(value)
 }
 
-// ShowNotifications : Show the rebalance notifications of consumers
-func (kc *Client) ShowNotifications() {
-	fmt.Println("Starting Kafka notifications go routine...")
-	for {
+// Handle the rebalance notifications and log the errors of a consumer
+// until it closes both channels or stop is closed. Started by Consume and
+// ConsumeBatch for every consumer they read from.
+func (kc *Client) watchConsumer(consumer GroupConsumer, stop <-chan struct{}) {
+	notifications := consumer.Notifications()
+	consumerErrors := consumer.Errors()
+	for notifications != nil || consumerErrors != nil {
 		select {
-		case notification := <-kc.Consumer.Notifications():
+		case <-stop:
+			return
+		case notification, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
 			if notification != nil {
-				fmt.Println("Notification Type: ", notification.Type)
-				fmt.Println("Notification Current: ", notification.Current)
+				kc.logger().Info("rebalance notification", Fields{"type": notification.Type.String(), "current": notification.Current})
+				kc.handleRebalance(notification)
 			}
-		case success := <-kc.Producer.Successes():
-			if success != nil {
-				fmt.Println("Successfull delivery to: ", success.Topic)
-				fmt.Println("Message: ", success.Value)
+		case err, ok := <-consumerErrors:
+			if !ok {
+				consumerErrors = nil
+				continue
+			}
+			if err == nil {
+				continue
+			}
+			consumerErrorCount.Inc()
+			if !kc.handleOversized(err) && !kc.handleCommitError(err) && !kc.handleCoordinatorError(err) {
+				kc.logger().Error("consumer error", errorFields(err))
 			}
 		}
 	}
 }
 
-// ShowErrors : Show the error notifications of consumers
-func (kc *Client) ShowErrors() {
-	fmt.Println("Starting Kafka Errors go routine...")
-	for {
+// Watch the given consumer in place of the one watched so far, if any.
+// The watcher of a consumer also ends on its own once it is closed, but
+// stopping it keeps a consumer from being watched twice, e.g. when Consume
+// is called again.
+func (kc *Client) watchCurrentConsumer(consumer GroupConsumer) {
+	stop := make(chan struct{})
+
+	kc.watchMu.Lock()
+	if kc.stopWatch != nil {
+		close(kc.stopWatch)
+	}
+	kc.stopWatch = stop
+	kc.watchMu.Unlock()
+
+	go kc.watchConsumer(consumer, stop)
+}
+
+// Count and log the deliveries and errors of the async producer until it
+// is closed. Started once by Connect.
+func (kc *Client) watchProducer(producer sarama.AsyncProducer) {
+	successes := producer.Successes()
+	producerErrors := producer.Errors()
+	for successes != nil || producerErrors != nil {
 		select {
-		case error := <-kc.Consumer.Errors():
-			if error != nil {
-				fmt.Println("Error occoured: ", error)
+		case success, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			if success != nil {
+				messagesDelivered.WithLabelValues(success.Topic).Inc()
+				kc.recordDelivery(success, nil)
+				fields := Fields{"topic": success.Topic, "partition": success.Partition, "offset": success.Offset}
+				if success.Value != nil {
+					if value, err := success.Value.Encode(); err == nil {
+						fields["payload"] = string(kc.redact(value))
+					}
+				}
+				kc.logger().Debug("successful delivery", fields)
+			}
+		case perr, ok := <-producerErrors:
+			if !ok {
+				producerErrors = nil
+				continue
 			}
-		case error := <-kc.Producer.Errors():
-			if error != nil {
-				fmt.Println("Error occoured: ", error)
+			if perr != nil {
+				kc.handleProducerError(perr)
 			}
 		}
 	}
 }
 
-func (kc *Config) createTLSConfig() *tls.Config {
+func (kc *Config) createTLSConfig() (*tls.Config, error) {
+	trustedCert, err := kc.trustedCert()
+	if err != nil {
+		return nil, err
+	}
 	roots := x509.NewCertPool()
-	ok := roots.AppendCertsFromPEM([]byte(kc.TrustedCert))
+	ok := roots.AppendCertsFromPEM([]byte(trustedCert))
 	if !ok {
-		log.Println("Unable to parse Root Cert:", kc.TrustedCert)
+		kc.logger().Error("unable to parse root cert", Fields{"cert": trustedCert})
+	}
+
+	// Setup certs for Sarama. With SASL the client cert is optional.
+	var certs []tls.Certificate
+	if kc.SASLMechanism == "" || kc.hasPEMClientCert() || kc.ClientP12File != "" {
+		cert, err := kc.clientCertificate()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
 	}
-	// Setup certs for Sarama
-	cert, err := tls.X509KeyPair([]byte(kc.ClientCert), []byte(kc.ClientCertKey))
+
+	minVersion, err := kc.tlsMinVersion()
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := kc.tlsCipherSuites()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	tlsConfig := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
+		Certificates:       certs,
 		InsecureSkipVerify: true,
 		RootCAs:            roots,
+		MinVersion:         minVersion,
+		CipherSuites:       cipherSuites,
+	}
+	if kc.CertAutoReload && len(certs) > 0 {
+		reloader := &certReloader{certFile: kc.ClientCertFile, keyFile: kc.ClientCertKeyFile, logger: kc.logger(), cert: &certs[0]}
+		tlsConfig.GetClientCertificate = reloader.getClientCertificate
 	}
 
 	// tlsConfig.BuildNameToCertificate()
-	return tlsConfig
+	return tlsConfig, nil
+}
+
+// Reloads the client keypair from disk whenever a new connection is made.
+// If the files can't be loaded (e.g. caught mid-rotation) the last good
+// keypair is used.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   Logger
+
+	mu   sync.Mutex
+	cert *tls.Certificate
 }
 
-// Extract the host:port pairs from the Kafka URL(s)
-func (kc *Config) brokerAddresses() []string {
-	urls := strings.Split(kc.URL, ",")
-	addrs := make([]string, len(urls))
-	for i, v := range urls {
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		r.logger.Warn("unable to reload client cert, using the previous one", errorFields(err))
+		return r.cert, nil
+	}
+	r.cert = &cert
+	return r.cert, nil
+}
+
+// The trusted CA cert, from TrustedCertFile when set
+func (kc *Config) trustedCert() (string, error) {
+	return readCertFile(kc.TrustedCertFile, kc.TrustedCert)
+}
+
+// The client cert from PEM, or from the PKCS#12 bundle when no PEM cert
+// and key are set
+func (kc *Config) clientCertificate() (tls.Certificate, error) {
+	if !kc.hasPEMClientCert() && kc.ClientP12File != "" {
+		return loadP12(kc.ClientP12File, kc.ClientP12Password)
+	}
+
+	clientCert, err := readCertFile(kc.ClientCertFile, kc.ClientCert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	clientCertKey, err := readCertFile(kc.ClientCertKeyFile, kc.ClientCertKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair([]byte(clientCert), []byte(clientCertKey))
+}
+
+func (kc *Config) hasPEMClientCert() bool {
+	return (kc.ClientCert != "" || kc.ClientCertFile != "") &&
+		(kc.ClientCertKey != "" || kc.ClientCertKeyFile != "")
+}
+
+func loadP12(path, password string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot read PKCS#12 file: %v", err)
+	}
+
+	// Unlike pkcs12.Decode, ToPEM accepts bundles that carry the CA chain
+	// along with the client cert
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err == pkcs12.ErrIncorrectPassword {
+		return tls.Certificate{}, fmt.Errorf("cannot decode PKCS#12 file %s: wrong KAFKA_CLIENT_P12_PASSWORD", path)
+	}
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot decode PKCS#12 file %s: %v", path, err)
+	}
+
+	var key []byte
+	var certs [][]byte
+	for _, b := range blocks {
+		switch b.Type {
+		case "PRIVATE KEY":
+			key = pem.EncodeToMemory(b)
+		case "CERTIFICATE":
+			certs = append(certs, pem.EncodeToMemory(b))
+		}
+	}
+	if key == nil || len(certs) == 0 {
+		return tls.Certificate{}, fmt.Errorf("cannot decode PKCS#12 file %s: expected a private key and a certificate", path)
+	}
+
+	// The client cert has to come first, followed by its chain, but bundles
+	// list them in any order
+	for i := range certs {
+		chain := append([][]byte{certs[i]}, certs[:i]...)
+		chain = append(chain, certs[i+1:]...)
+		cert, err := tls.X509KeyPair(bytes.Join(chain, nil), key)
+		if err != nil {
+			continue
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return tls.Certificate{}, fmt.Errorf("cannot decode PKCS#12 file %s: %v", path, err)
+		}
+		return cert, nil
+	}
+	return tls.Certificate{}, fmt.Errorf("cannot decode PKCS#12 file %s: no certificate matches the private key", path)
+}
+
+// Read cert material from path, or return the inline value if no path is
+// given
+func readCertFile(path string, inline string) (string, error) {
+	if path == "" {
+		return inline, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read cert file: %v", err)
+	}
+	return string(data), nil
+}
+
+// BrokerAddresses : The host:port pairs of the brokers, either Brokers or
+// the hosts of the comma separated URLs in URL
+func (kc *Config) BrokerAddresses() ([]string, error) {
+	if len(kc.Brokers) > 0 {
+		return append([]string(nil), kc.Brokers...), nil
+	}
+
+	var addrs []string
+	for _, v := range strings.Split(kc.URL, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
 		u, err := url.Parse(v)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("invalid KAFKA_URL %q: %v", v, err)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid KAFKA_URL %q: expected scheme://host:port", v)
 		}
-		addrs[i] = u.Host
+		addrs = append(addrs, u.Host)
 	}
-	return addrs
+	if len(addrs) == 0 {
+		return nil, errors.New("either KAFKA_URL or KAFKA_BROKERS must be set")
+	}
+	return addrs, nil
 }
 
-func verifyServerCert(tc *tls.Config, caCert string, url string) (bool, error) {
+func verifyServerCert(ctx context.Context, tc *tls.Config, caCert string, url string) (bool, error) {
 	// Create connection to server
-	conn, err := tls.Dial("tcp", url, tc)
+	var dialer net.Dialer
+	rawConn, err := dialer.DialContext(ctx, "tcp", url)
 	if err != nil {
 		return false, err
 	}
+	defer rawConn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		rawConn.SetDeadline(deadline)
+	}
+	conn := tls.Client(rawConn, tc)
+	if err := conn.Handshake(); err != nil {
+		return false, err
+	}
 
 	// Pull servers cert
 	serverCert := conn.ConnectionState().PeerCertificates[0]
@@ -184,7 +1264,6 @@ func verifyServerCert(tc *tls.Config, caCert string, url string) (bool, error) {
 	// Verify Server Cert
 	opts := x509.VerifyOptions{Roots: roots}
 	if _, err := serverCert.Verify(opts); err != nil {
-		log.Println("Unable to verify Server Cert")
 		return false, err
 	}
 
@@ -197,75 +1276,335 @@ func verifyServerCert(tc *tls.Config, caCert string, url string) (bool, error) {
 // For the demo app, there's only one group, but a production app
 // could use separate groups for e.g. processing events and archiving
 // raw events to S3 for longer term storage
-func (kc *Config) createKafkaConsumer(brokers []string, tc *tls.Config) *cluster.Consumer {
-	config := cluster.NewConfig()
+func (kc *Config) createKafkaConsumer(brokers []string, tc *tls.Config, group string, topics []string) (GroupConsumer, error) {
+	// sarama-cluster accepts these and then delivers nothing
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("kafka: no topics to consume, resolved topics: %q", topics)
+	}
+	for _, t := range topics {
+		if strings.TrimSpace(t) == "" {
+			return nil, fmt.Errorf("kafka: empty topic name in resolved topics: %q", topics)
+		}
+	}
+
+	kc.logger().Info("consuming topics", Fields{"topics": topics, "brokers": brokers})
+
+	if !kc.NativeConsumerGroup {
+		return kc.createClusterConsumer(brokers, tc, group, topics)
+	}
 
+	config := sarama.NewConfig()
+	if err := kc.applyConsumerConfig(config, tc, nativeGroupMinVersion); err != nil {
+		return nil, err
+	}
+	config.Consumer.Offsets.CommitInterval = 0
+	config.Consumer.Offsets.AutoCommit.Interval = time.Second
+	switch kc.PartitionStrategy {
+	case PartitionStrategyRange:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	case PartitionStrategySticky:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky
+	default:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newNativeConsumer(brokers, group, topics, config, kc.logger())
+}
+
+// Set what both consumer groups share on config, with the protocol version
+// fallback when Config.Version is unset
+func (kc *Config) applyConsumerConfig(config *sarama.Config, tc *tls.Config, fallback sarama.KafkaVersion) error {
 	config.Net.TLS.Config = tc
 	config.Net.TLS.Enable = true
-	config.Group.PartitionStrategy = cluster.StrategyRoundRobin
-	config.Group.Return.Notifications = true
+	kc.applySASL(config)
+	version, err := kc.kafkaVersion(fallback)
+	if err != nil {
+		return err
+	}
+	config.Version = version
+	config.ChannelBufferSize = kc.ChannelBufferSize
+	config.MetricRegistry = kc.MetricRegistry
 	config.ClientID = strings.Join([]string{kc.ConsumerGroup, time.Now().Format("20200102150405")}, "-")
 	config.Consumer.Return.Errors = true
-	config.Consumer.Offsets.CommitInterval = time.Second
-	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	config.Consumer.Fetch.Max = kc.FetchMax
+	config.Consumer.Offsets.Initial = kc.initialOffset()
+	config.Consumer.IsolationLevel = kc.isolationLevel()
+	config.Consumer.Group.Session.Timeout = kc.SessionTimeout
+	config.Consumer.Group.Rebalance.Timeout = kc.RebalanceTimeout
+	config.Consumer.Group.Rebalance.Retry.Max = kc.RebalanceRetryMax
+	config.Consumer.Group.Rebalance.Retry.Backoff = kc.RebalanceRetryBackoff
+	return nil
+}
 
-	topics := []string{kc.topic("order_events")}
+// Create the Kafka asynchronous producer
+func (kc *Config) createKafkaProducer(brokers []string, tc *tls.Config) (sarama.AsyncProducer, error) {
+	config, err := kc.producerConfig(tc)
+	if err != nil {
+		return nil, err
+	}
+	return sarama.NewAsyncProducer(brokers, config)
+}
 
-	log.Printf("Consuming topic %s on brokers: %s", topics, brokers)
+// The sarama config of the asynchronous producer, also reported by Summary
+func (kc *Config) producerConfig(tc *tls.Config) (*sarama.Config, error) {
+	config := sarama.NewConfig()
 
-	err := config.Validate()
+	config.Net.TLS.Config = tc
+	config.Net.TLS.Enable = true
+	kc.applySASL(config)
+	config.Producer.Return.Errors = true
+	// Successes must then be drained, which watchProducer does
+	config.Producer.Return.Successes = kc.TrackSuccesses
+	config.Producer.RequiredAcks = sarama.WaitForAll // Default is WaitForLocal
+	config.Producer.Flush.Messages = kc.FlushMessages
+	config.Producer.Flush.Bytes = kc.FlushBytes
+	config.Producer.Flush.Frequency = kc.FlushFrequency
+	config.ChannelBufferSize = kc.ChannelBufferSize
+	config.MetricRegistry = kc.MetricRegistry
+	version, err := kc.kafkaVersion(config.Version)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	config.Version = version
+	config.ClientID = strings.Join([]string{kc.ConsumerGroup, time.Now().Format("20200102150405")}, "-")
+	kc.applyProducerRetry(config)
 
-	consumer, err := cluster.NewConsumer(brokers, kc.group(), topics, config)
-	if err != nil {
-		log.Fatal(err)
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
-	return consumer
+	return config, nil
 }
 
-// Create the Kafka asynchronous producer
-func (kc *Config) createKafkaProducer(brokers []string, tc *tls.Config) sarama.AsyncProducer {
+// Create the Kafka synchronous producer, used when the caller needs to know
+// where a message landed before moving on
+func (kc *Config) createKafkaSyncProducer(brokers []string, tc *tls.Config) (sarama.SyncProducer, error) {
 	config := sarama.NewConfig()
 
 	config.Net.TLS.Config = tc
 	config.Net.TLS.Enable = true
+	kc.applySASL(config)
 	config.Producer.Return.Errors = true
-	config.Producer.RequiredAcks = sarama.WaitForAll // Default is WaitForLocal
-	config.Producer.Flush.Messages = 1
+	config.Producer.Return.Successes = true // Required by the sync producer
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.ChannelBufferSize = kc.ChannelBufferSize
+	config.MetricRegistry = kc.MetricRegistry
+	version, err := kc.kafkaVersion(config.Version)
+	if err != nil {
+		return nil, err
+	}
+	config.Version = version
 	config.ClientID = strings.Join([]string{kc.ConsumerGroup, time.Now().Format("20200102150405")}, "-")
+	kc.applyProducerRetry(config)
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return sarama.NewSyncProducer(brokers, config)
+}
+
+// Authenticate with Config.SASLMechanism, if set, on top of TLS
+func (kc *Config) applySASL(config *sarama.Config) {
+	if kc.SASLMechanism == "" {
+		return
+	}
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.Mechanism = sarama.SASLMechanism(kc.SASLMechanism)
+	config.Net.SASL.User = kc.Username
+	config.Net.SASL.Password = kc.Password
+	if kc.SASLMechanism != SASLPlain {
+		config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClient(kc.SASLMechanism)
+	}
+}
+
+// Retries on broker errors. An idempotent producer lets the broker drop
+// the duplicates a retry would otherwise create, which requires acks from
+// all replicas and a single in-flight request per broker.
+func (kc *Config) applyProducerRetry(config *sarama.Config) {
+	config.Producer.Retry.Max = kc.ProducerRetryMax
+	config.Producer.Retry.Backoff = kc.ProducerRetryBackoff
+	if kc.MaxOpenRequests > 0 {
+		config.Net.MaxOpenRequests = kc.MaxOpenRequests
+	}
+
+	if kc.ProducerIdempotent {
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+	}
+}
+
+// ProduceSync : Produces a single message to a topic given by its name
+// without prefix and waits for the broker to acknowledge it, returning the
+// partition and offset it was written to.
+// It can't carry the nonce of Config.EncryptedTopics, so publishing to
+// those fails.
+func (kc *Client) ProduceSync(topic string, value []byte) (int32, int64, error) {
+	kc.producerMu.RLock()
+	defer kc.producerMu.RUnlock()
+	if kc.producerClosed {
+		return 0, 0, ErrProducerClosed
+	}
+	topic = kc.config.topic(topic)
+	if kc.encrypted(topic) {
+		return 0, 0, fmt.Errorf("kafka: ProduceSync can't publish to encrypted topic %s, use PublishWithOptions", topic)
+	}
 
-	err := config.Validate()
+	partition, offset, err := kc.producer.ProduceSync(topic, value)
 	if err != nil {
-		log.Fatal(err)
+		countSyncProducerError(topic, err)
 	}
-	producer, err := sarama.NewAsyncProducer(brokers, config)
+	countPublished(topic, err)
+	return partition, offset, err
+}
+
+// IsDuplicate : Reports whether a message carrying the same idempotency key
+// header was handled successfully recently. Always false when
+// deduplication is disabled or the message has no such header.
+func (kc *Client) IsDuplicate(msg *sarama.ConsumerMessage) bool {
+	key, ok := kc.idempotencyKey(msg)
+	return ok && kc.dedup.seen(key)
+}
+
+// Whether the message is to be skipped as a duplicate. Copies on a retry
+// topic carry the key of the message that failed, and are never skipped.
+func (kc *Client) skipDuplicate(msg *sarama.ConsumerMessage) bool {
+	return kc.retryRound(msg) == 0 && kc.IsDuplicate(msg)
+}
+
+// Remember the idempotency key of a successfully handled message, so
+// later deliveries of it are skipped
+func (kc *Client) rememberHandled(msg *sarama.ConsumerMessage) {
+	if key, ok := kc.idempotencyKey(msg); ok {
+		kc.dedup.add(key)
+	}
+}
+
+func (kc *Client) idempotencyKey(msg *sarama.ConsumerMessage) (string, bool) {
+	if kc.dedup == nil {
+		return "", false
+	}
+
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == kc.config.DedupHeader {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// Parse the configured Kafka version, falling back to the given default
+func (kc *Config) kafkaVersion(fallback sarama.KafkaVersion) (sarama.KafkaVersion, error) {
+	if kc.Version == "" {
+		return fallback, nil
+	}
+
+	version, err := sarama.ParseKafkaVersion(kc.Version)
 	if err != nil {
-		log.Fatal(err)
+		return fallback, fmt.Errorf("KAFKA_VERSION must be a Kafka version such as 2.1.0, got %q", kc.Version)
 	}
+	return version, nil
+}
 
-	return producer
+// WithTopics : Sets the topics consumed, without prefix, in place of
+// KAFKA_TOPICS, e.g. LoadConfig().WithTopics("order_events", "print_jobs")
+func (kc *Config) WithTopics(topics ...string) *Config {
+	kc.Topics = topics
+	return kc
 }
 
-// Prepends prefix to topic if provided
+// The topics the consumer subscribes to
+func (kc *Config) topics() []string {
+	topics := make([]string, len(kc.Topics))
+	for i, t := range kc.Topics {
+		topics[i] = kc.topic(t)
+	}
+	return topics
+}
+
+// Prepends the topic's own prefix, or the global one, if provided
 func (kc *Config) topic(topicName string) string {
-	topic := ""
+	prefix, ok := kc.TopicPrefixes[topicName]
+	if !ok {
+		prefix = kc.Prefix
+	}
+
+	return strings.Join([]string{prefix, topicName}, "")
+}
+
+// The logical name of a full topic name, i.e. with its prefix removed.
+// Reports false if the topic doesn't carry the prefix configured for it.
+func (kc *Config) logicalTopic(fullName string) (string, bool) {
+	for name, prefix := range kc.TopicPrefixes {
+		if fullName == prefix+name {
+			return name, true
+		}
+	}
+
+	if !strings.HasPrefix(fullName, kc.Prefix) {
+		return fullName, false
+	}
+	return strings.TrimPrefix(fullName, kc.Prefix), true
+}
 
-	if kc.Prefix != "" {
-		topic = strings.Join([]string{kc.Prefix, topicName}, "")
+// The sarama isolation level for Config.IsolationLevel
+func (kc *Config) isolationLevel() sarama.IsolationLevel {
+	if kc.IsolationLevel == IsolationReadCommitted {
+		return sarama.ReadCommitted
 	}
+	return sarama.ReadUncommitted
+}
 
-	return topic
+// The sarama initial offset for Config.OffsetReset. Ephemeral groups
+// always read from the oldest offset.
+func (kc *Config) initialOffset() int64 {
+	if kc.OffsetReset == OffsetResetOldest || kc.EphemeralGroup {
+		return sarama.OffsetOldest
+	}
+	return sarama.OffsetNewest
 }
 
 // Prepend prefix to consumer group if provided
 func (kc *Config) group() string {
-	group := kc.ConsumerGroup
+	return kc.prefixGroup(kc.ConsumerGroup) + kc.ephemeralSuffix
+}
+
+func (kc *Config) prefixGroup(group string) string {
+	prefix := kc.GroupPrefix
+	if prefix == "" {
+		prefix = kc.Prefix
+	}
 
-	if kc.Prefix != "" {
-		group = strings.Join([]string{kc.Prefix, group}, "")
+	if prefix != "" {
+		group = strings.Join([]string{prefix, group}, "")
 	}
 
 	return group
 }
+
+// PrefixMap : Topic name to prefix mapping, decoded from a comma separated
+// list of topic=prefix pairs
+type PrefixMap map[string]string
+
+// Decode : Implements envdecode.Decoder
+func (m *PrefixMap) Decode(value string) error {
+	prefixes := make(PrefixMap)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid topic prefix %q, expected topic=prefix", pair)
+		}
+		prefixes[parts[0]] = parts[1]
+	}
+
+	*m = prefixes
+	return nil
+}
@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrLeaveTimeout is returned by LeaveGroup when leaving took longer than
+// Config.ShutdownTimeout
+var ErrLeaveTimeout = errors.New("kafka: timed out leaving the consumer group")
+
+var groupLeaves = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "consumer_group_leaves_total",
+	Help:      "Times the consumer left its group, by result.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(groupLeaves)
+}
+
+// LeaveGroup : Commits the offsets marked so far and then closes the
+// consumer, leaving the group cleanly. Committing first means the members
+// taking over the partitions resume exactly where we stopped, which keeps
+// the rebalance during a planned scale-down short. Gives up after
+// Config.ShutdownTimeout so a slow coordinator can't hang a deployment.
+func (kc *Client) LeaveGroup() error {
+	kc.consumerMu.RLock()
+	consumer := kc.Consumer
+	kc.consumerMu.RUnlock()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		if err := consumer.CommitOffsets(); err != nil {
+			kc.logger().Error("failed to commit offsets before leaving the group", errorFields(err))
+		}
+		done <- consumer.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			groupLeaves.WithLabelValues("error").Inc()
+			return err
+		}
+	case <-time.After(kc.config.ShutdownTimeout):
+		groupLeaves.WithLabelValues("timeout").Inc()
+		return ErrLeaveTimeout
+	}
+
+	groupLeaves.WithLabelValues("ok").Inc()
+	kc.logger().Info("left consumer group", Fields{"group": kc.config.group(), "took": time.Since(start).Round(time.Millisecond).String()})
+	return nil
+}
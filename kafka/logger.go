@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Fields : Structured context of a log entry, e.g. the topic, partition
+// and offset of a message
+type Fields map[string]interface{}
+
+// Logger : Where the client logs to, set with Config.Logger. Adapters for
+// zap, zerolog and the like only need to map the levels, and the fields to
+// theirs, and filter by their own level; the loggers of this package write
+// every level.
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+}
+
+// Used when Config.Logger is nil
+var defaultLogger Logger = StdLogger{}
+
+// StdLogger : Logs through the standard library's log package, or Logger
+// when set, as "LEVEL msg key=value ..." with the fields sorted by key.
+// The default.
+type StdLogger struct {
+	Logger *log.Logger
+}
+
+// Debug : Implements Logger
+func (l StdLogger) Debug(msg string, fields Fields) { l.print("DEBUG", msg, fields) }
+
+// Info : Implements Logger
+func (l StdLogger) Info(msg string, fields Fields) { l.print("INFO", msg, fields) }
+
+// Warn : Implements Logger
+func (l StdLogger) Warn(msg string, fields Fields) { l.print("WARN", msg, fields) }
+
+// Error : Implements Logger
+func (l StdLogger) Error(msg string, fields Fields) { l.print("ERROR", msg, fields) }
+
+func (l StdLogger) print(level string, msg string, fields Fields) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for _, k := range keys {
+		v := fmt.Sprint(fields[k])
+		if strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+
+	if l.Logger != nil {
+		l.Logger.Println(b.String())
+		return
+	}
+	log.Println(b.String())
+}
+
+// JSONLogger : Logs one JSON object per line, with the time, level and
+// message next to the fields, for log pipelines that index fields
+type JSONLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLogger : Creates a JSONLogger writing to w, e.g. os.Stdout
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// Debug : Implements Logger
+func (l *JSONLogger) Debug(msg string, fields Fields) { l.write("debug", msg, fields) }
+
+// Info : Implements Logger
+func (l *JSONLogger) Info(msg string, fields Fields) { l.write("info", msg, fields) }
+
+// Warn : Implements Logger
+func (l *JSONLogger) Warn(msg string, fields Fields) { l.write("warn", msg, fields) }
+
+// Error : Implements Logger
+func (l *JSONLogger) Error(msg string, fields Fields) { l.write("error", msg, fields) }
+
+func (l *JSONLogger) write(level string, msg string, fields Fields) {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		// Errors marshal to {} otherwise
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": "error", "msg": "cannot encode log entry", "error": err.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "%s\n", line)
+}
+
+// The logger of the config, Config.Logger or the default
+func (kc *Config) logger() Logger {
+	if kc.Logger == nil {
+		return defaultLogger
+	}
+	return kc.Logger
+}
+
+func (kc *Client) logger() Logger {
+	return kc.config.logger()
+}
+
+// The logger of the client the message was consumed by, for middlewares
+func (m Message) logger() Logger {
+	if m.log == nil {
+		return defaultLogger
+	}
+	return m.log
+}
+
+// Log an error and exit, for the failures the client can't continue after
+func fatal(l Logger, msg string, fields Fields) {
+	l.Error(msg, fields)
+	os.Exit(1)
+}
+
+// The fields locating a message, with the error of the entry if any
+func messageFields(msg *sarama.ConsumerMessage, err error) Fields {
+	fields := Fields{
+		"topic":     msg.Topic,
+		"partition": msg.Partition,
+		"offset":    msg.Offset,
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	return fields
+}
+
+// Fields with an error only
+func errorFields(err error) Fields {
+	return Fields{"error": err}
+}
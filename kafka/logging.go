@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const correlationIDHeader = "correlation-id"
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// Log an entry for a consumed message. The payload is left out
+// unless Config.LogPayload is set, as it may contain customer data. Only
+// the first of every Config.LogSampleRate messages of a partition is
+// logged.
+func (kc *Client) logReceipt(msg *sarama.ConsumerMessage, receivedAt time.Time) {
+	if !kc.sampleReceipt(msg.Topic, msg.Partition) {
+		return
+	}
+
+	fields := messageFields(msg, nil)
+	fields["value_size"] = len(msg.Value)
+	fields["received_at"] = receivedAt.Format(time.RFC3339Nano)
+	if len(msg.Key) > 0 {
+		fields["key"] = string(msg.Key)
+	}
+	if msg.Value == nil {
+		fields["tombstone"] = true
+	}
+
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == correlationIDHeader {
+			fields["correlation_id"] = string(h.Value)
+		}
+	}
+
+	if kc.config.LogPayload {
+		fields["payload"] = string(kc.redact(msg.Value))
+	}
+
+	kc.logger().Info("message received", fields)
+}
+
+// Count the receipt and report whether it is one to log
+func (kc *Client) sampleReceipt(topic string, partition int32) bool {
+	if kc.config.LogSampleRate <= 1 {
+		return true
+	}
+
+	kc.receiptsMu.Lock()
+	defer kc.receiptsMu.Unlock()
+
+	if kc.receipts == nil {
+		kc.receipts = make(map[topicPartition]uint64)
+	}
+	tp := topicPartition{topic, partition}
+	n := kc.receipts[tp]
+	kc.receipts[tp] = n + 1
+
+	return n%uint64(kc.config.LogSampleRate) == 0
+}
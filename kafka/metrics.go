@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// KeyBuckets is the number of buckets KeyBucket hashes keys into
+const KeyBuckets = 64
+
+var messagesConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "messages_consumed_total",
+	Help:      "Messages received by the consumer.",
+}, []string{"topic", "key"})
+
+var messageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "kafka",
+	Name:      "message_processing_seconds",
+	Help:      "Time from the first handler attempt on a message to its last, retries included.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"topic"})
+
+var messagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "messages_failed_total",
+	Help:      "Messages whose handler still failed once the retries were exhausted.",
+}, []string{"topic"})
+
+var consumerErrorCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "consumer_errors_total",
+	Help:      "Errors reported by the consumer, commit and coordinator errors included.",
+})
+
+var rebalances = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "rebalances_total",
+	Help:      "Consumer group rebalances this member took part in, by result.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(messagesConsumed, messageDuration, messagesFailed, consumerErrorCount, rebalances)
+}
+
+// MetricsHandler : Serves the metrics of the default Prometheus registry,
+// which the client registers its own with, e.g. on /metrics
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// KeyBucket : Stable bucket in [0, KeyBuckets) for a message key, for use
+// as a metric label where raw keys would blow up cardinality
+func KeyBucket(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % KeyBuckets)
+}
+
+// The key label of a message. Raw keys are unbounded (one series per print
+// job), so they are replaced with their bucket unless
+// Config.KeyHashForMetrics was turned off.
+func (kc *Client) keyLabel(key []byte) string {
+	if kc.config.KeyHashForMetrics {
+		return strconv.Itoa(KeyBucket(key))
+	}
+	return string(key)
+}
@@ -0,0 +1,19 @@
+package kafka
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeyLabel(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+	kc.config.KeyHashForMetrics = true
+	if got, want := kc.keyLabel([]byte("job-1")), strconv.Itoa(KeyBucket([]byte("job-1"))); got != want {
+		t.Fatalf("hashed key label is %q, want %q", got, want)
+	}
+
+	kc.config.KeyHashForMetrics = false
+	if got := kc.keyLabel([]byte("job-1")); got != "job-1" {
+		t.Fatalf("raw key label is %q, want job-1", got)
+	}
+}
@@ -0,0 +1,112 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Middleware : Wraps a Handler, http-style, to add behaviour around it
+// such as logging, metrics or enrichment of the message
+type Middleware func(next Handler) Handler
+
+var (
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kafka",
+		Name:      "handler_duration_seconds",
+		Help:      "Time spent in the handler per attempt.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic"})
+
+	handlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka",
+		Name:      "handler_errors_total",
+		Help:      "Handler attempts that returned an error.",
+	}, []string{"topic"})
+
+	handlerPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kafka",
+		Name:      "handler_panics_total",
+		Help:      "Handler attempts that panicked.",
+	}, []string{"topic"})
+)
+
+func init() {
+	prometheus.MustRegister(handlerDuration, handlerErrors, handlerPanics)
+}
+
+// Use : Adds a middleware around the handlers given to Consume, Dispatch
+// and Process. The first middleware added is the outermost one, and every
+// retry of a message goes through the whole chain again. Batch handlers
+// aren't wrapped. Call before Consume.
+func (kc *Client) Use(mw Middleware) {
+	kc.middlewares = append(kc.middlewares, mw)
+}
+
+// Wrap the handler in the middlewares, the first one outermost
+func (kc *Client) wrap(handler Handler) Handler {
+	for i := len(kc.middlewares) - 1; i >= 0; i-- {
+		handler = kc.middlewares[i](handler)
+	}
+	return handler
+}
+
+// Recover : Middleware turning a panic in the handler into an error, so
+// the message is retried and resolved like any other failure instead of
+// crashing the process. The stack is logged.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					handlerPanics.WithLabelValues(msg.Topic).Inc()
+					msg.logger().Error("handler panicked", Fields{
+						"topic":     msg.Topic,
+						"partition": msg.Partition,
+						"offset":    msg.Offset,
+						"panic":     fmt.Sprint(r),
+						"stack":     string(debug.Stack()),
+					})
+					err = fmt.Errorf("kafka: handler panicked: %v", r)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Metrics : Middleware recording the duration of every handler attempt in
+// kafka_handler_duration_seconds and its failures in
+// kafka_handler_errors_total, by topic
+func Metrics() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			handlerDuration.WithLabelValues(msg.Topic).Observe(time.Since(start).Seconds())
+			if err != nil {
+				handlerErrors.WithLabelValues(msg.Topic).Inc()
+			}
+			return err
+		}
+	}
+}
+
+// SpanStarter : Starts a span for a handler attempt, returning the context
+// the handler runs with and a function ending the span with its result
+type SpanStarter func(ctx context.Context, msg Message) (context.Context, func(err error))
+
+// Tracing : Middleware running every handler attempt in a span, started
+// by whatever tracer start wraps
+func Tracing(start SpanStarter) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (err error) {
+			ctx, end := start(ctx, msg)
+			defer func() { end(err) }()
+			return next(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GroupConsumer whose channels the tests feed and close
+type mockConsumer struct {
+	messages      chan *sarama.ConsumerMessage
+	errors        chan error
+	notifications chan *Notification
+
+	mu     sync.Mutex
+	marked []*sarama.ConsumerMessage
+	closed bool
+}
+
+func newMockConsumer() *mockConsumer {
+	return &mockConsumer{
+		messages:      make(chan *sarama.ConsumerMessage, 16),
+		errors:        make(chan error, 16),
+		notifications: make(chan *Notification, 16),
+	}
+}
+
+func (c *mockConsumer) Messages() <-chan *sarama.ConsumerMessage   { return c.messages }
+func (c *mockConsumer) Errors() <-chan error                       { return c.errors }
+func (c *mockConsumer) Notifications() <-chan *Notification        { return c.notifications }
+func (c *mockConsumer) CommitOffsets() error                       { return nil }
+func (c *mockConsumer) Subscriptions() map[string][]int32          { return nil }
+func (c *mockConsumer) HighWaterMarks() map[string]map[int32]int64 { return nil }
+
+func (c *mockConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.marked = append(c.marked, msg)
+}
+
+func (c *mockConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.messages)
+		close(c.errors)
+		close(c.notifications)
+	}
+	return nil
+}
+
+func (c *mockConsumer) markedOffsets() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	offsets := make([]int64, len(c.marked))
+	for i, msg := range c.marked {
+		offsets[i] = msg.Offset
+	}
+	return offsets
+}
+
+// A client consuming from consumer, with the defaults of LoadConfig
+func newTestClient(consumer GroupConsumer) *Client {
+	return &Client{
+		config: &Config{
+			MaxConcurrentPerPartition: 1,
+			ChannelBufferSize:         16,
+			OnPermanentError:          PermanentErrorSkip,
+			LogSampleRate:             1,
+			KeyHashForMetrics:         true,
+			Logger:                    discardLogger{},
+		},
+		Consumer: consumer,
+		latency:  newLatencyHistogram(),
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, Fields) {}
+func (discardLogger) Info(string, Fields)  {}
+func (discardLogger) Warn(string, Fields)  {}
+func (discardLogger) Error(string, Fields) {}
+
+// The value of a counter of the default registry, by its full name and
+// the value of its only label, "" for a counter without labels
+func counterValue(t *testing.T, name, label string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gathering metrics: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := m.GetLabel()
+			if (label == "" && len(labels) == 0) || (len(labels) == 1 && labels[0].GetValue() == label) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
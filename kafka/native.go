@@ -0,0 +1,290 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Oldest protocol version sarama's consumer group works with, used when
+// Config.Version is unset
+var nativeGroupMinVersion = sarama.V0_10_2_0
+
+// NotificationType : The stage of a rebalance a Notification reports
+type NotificationType uint8
+
+// Values of NotificationType
+const (
+	// UnknownNotification is the zero value
+	UnknownNotification NotificationType = iota
+	// RebalanceStart : The partitions held so far are being released
+	RebalanceStart
+	// RebalanceOK : The consumer joined the group's new generation
+	RebalanceOK
+	// RebalanceError : The rebalance failed and is retried
+	RebalanceError
+)
+
+func (t NotificationType) String() string {
+	switch t {
+	case RebalanceStart:
+		return "rebalance start"
+	case RebalanceOK:
+		return "rebalance OK"
+	case RebalanceError:
+		return "rebalance error"
+	}
+	return "unknown"
+}
+
+// Notification : A rebalance of the consumer group, as seen by this member
+type Notification struct {
+	Type NotificationType
+	// Partitions by topic taken on, given up and held after the rebalance
+	Claimed  map[string][]int32
+	Released map[string][]int32
+	Current  map[string][]int32
+}
+
+// GroupConsumer : A member of a consumer group, as used by Client. The
+// adapter over sarama's consumer group implements it, and so does the one
+// over sarama-cluster used when Config.NativeConsumerGroup is disabled.
+type GroupConsumer interface {
+	Messages() <-chan *sarama.ConsumerMessage
+	Errors() <-chan error
+	Notifications() <-chan *Notification
+	MarkOffset(msg *sarama.ConsumerMessage, metadata string)
+	CommitOffsets() error
+	Subscriptions() map[string][]int32
+	HighWaterMarks() map[string]map[int32]int64
+	Close() error
+}
+
+// Adapts a sarama.ConsumerGroup to GroupConsumer: messages of every claim
+// are multiplexed on a single channel, and the start and end of each
+// session are reported as rebalance notifications.
+type nativeConsumer struct {
+	group   sarama.ConsumerGroup
+	topics  []string
+	backoff time.Duration
+	logger  Logger
+
+	messages      chan *sarama.ConsumerMessage
+	notifications chan *Notification
+
+	mu      sync.Mutex
+	session sarama.ConsumerGroupSession
+	claims  map[string]map[int32]sarama.ConsumerGroupClaim
+	current map[string][]int32
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newNativeConsumer(brokers []string, group string, topics []string, config *sarama.Config, logger Logger) (*nativeConsumer, error) {
+	cg, err := sarama.NewConsumerGroup(brokers, group, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &nativeConsumer{
+		group:         cg,
+		topics:        topics,
+		backoff:       config.Consumer.Group.Rebalance.Retry.Backoff,
+		logger:        logger,
+		messages:      make(chan *sarama.ConsumerMessage, config.ChannelBufferSize),
+		notifications: make(chan *Notification),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// Join a new session after every rebalance until closed. Failed joins are
+// retried forever, as sarama-cluster does.
+func (c *nativeConsumer) run() {
+	defer close(c.done)
+	defer close(c.notifications)
+	defer close(c.messages)
+
+	for {
+		err := c.group.Consume(c.ctx, c.topics, c)
+		if c.ctx.Err() != nil || err == sarama.ErrClosedConsumerGroup {
+			return
+		}
+		if err != nil {
+			c.logger.Error("consumer group session failed", errorFields(err))
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(c.backoff):
+			}
+		}
+	}
+}
+
+// Setup : Implements sarama.ConsumerGroupHandler
+func (c *nativeConsumer) Setup(sess sarama.ConsumerGroupSession) error {
+	c.mu.Lock()
+	previous := c.current
+	c.session = sess
+	c.claims = make(map[string]map[int32]sarama.ConsumerGroupClaim)
+	c.current = sess.Claims()
+	c.mu.Unlock()
+
+	c.notify(&Notification{
+		Type:     RebalanceOK,
+		Claimed:  subtractPartitions(sess.Claims(), previous),
+		Released: subtractPartitions(previous, sess.Claims()),
+		Current:  sess.Claims(),
+	})
+	return nil
+}
+
+// Cleanup : Implements sarama.ConsumerGroupHandler. Marks are dropped from
+// now on, the session's offsets being committed as it is released.
+func (c *nativeConsumer) Cleanup(sess sarama.ConsumerGroupSession) error {
+	c.mu.Lock()
+	c.session = nil
+	c.claims = nil
+	current := c.current
+	c.mu.Unlock()
+
+	c.notify(&Notification{
+		Type:    RebalanceStart,
+		Current: current,
+	})
+	return nil
+}
+
+// ConsumeClaim : Implements sarama.ConsumerGroupHandler
+func (c *nativeConsumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	c.mu.Lock()
+	if c.claims != nil {
+		if c.claims[claim.Topic()] == nil {
+			c.claims[claim.Topic()] = make(map[int32]sarama.ConsumerGroupClaim)
+		}
+		c.claims[claim.Topic()][claim.Partition()] = claim
+	}
+	c.mu.Unlock()
+
+	for {
+		select {
+		case <-sess.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			select {
+			case c.messages <- msg:
+			case <-sess.Context().Done():
+				return nil
+			}
+		}
+	}
+}
+
+// Notifications must be drained, as with sarama-cluster
+func (c *nativeConsumer) notify(n *Notification) {
+	select {
+	case c.notifications <- n:
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *nativeConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func (c *nativeConsumer) Errors() <-chan error {
+	return c.group.Errors()
+}
+
+func (c *nativeConsumer) Notifications() <-chan *Notification {
+	return c.notifications
+}
+
+// MarkOffset : Marks the message in the current session. Messages of a
+// session that already ended are left to be delivered again.
+func (c *nativeConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil {
+		c.session.MarkMessage(msg, metadata)
+	}
+}
+
+// CommitOffsets : sarama commits marked offsets every
+// Consumer.Offsets.AutoCommit.Interval and when a session ends, including
+// on Close, and has no way to commit on demand
+func (c *nativeConsumer) CommitOffsets() error {
+	return nil
+}
+
+func (c *nativeConsumer) Subscriptions() map[string][]int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := make(map[string][]int32, len(c.current))
+	for topic, partitions := range c.current {
+		subs[topic] = append([]int32(nil), partitions...)
+	}
+	return subs
+}
+
+func (c *nativeConsumer) HighWaterMarks() map[string]map[int32]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hwms := make(map[string]map[int32]int64, len(c.claims))
+	for topic, claims := range c.claims {
+		hwms[topic] = make(map[int32]int64, len(claims))
+		for partition, claim := range claims {
+			hwms[topic][partition] = claim.HighWaterMarkOffset()
+		}
+	}
+	return hwms
+}
+
+// Close : Ends the session, which commits the marked offsets, and leaves
+// the group
+func (c *nativeConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		<-c.done
+		c.closeErr = c.group.Close()
+	})
+	return c.closeErr
+}
+
+// The partitions of a that aren't in b
+func subtractPartitions(a, b map[string][]int32) map[string][]int32 {
+	diff := make(map[string][]int32)
+	for topic, partitions := range a {
+		for _, p := range partitions {
+			if !containsPartition(b[topic], p) {
+				diff[topic] = append(diff[topic], p)
+			}
+		}
+	}
+	return diff
+}
+
+func containsPartition(partitions []int32, partition int32) bool {
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var oversizedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "oversized_messages_total",
+	Help:      "Messages larger than KAFKA_FETCH_MAX that could not be consumed.",
+}, []string{"topic"})
+
+func init() {
+	prometheus.MustRegister(oversizedMessages)
+}
+
+// Handle a message larger than Config.FetchMax. sarama reports it without
+// its offset and moves past it, so unless Config.SkipOversized is set the
+// partition is blocked, leaving the message to be consumed once FetchMax
+// has been raised. Reports whether err was such an error.
+func (kc *Client) handleOversized(err error) bool {
+	cerr, ok := err.(*sarama.ConsumerError)
+	if !ok || cerr.Err != sarama.ErrMessageTooLarge {
+		return false
+	}
+
+	oversizedMessages.WithLabelValues(cerr.Topic).Inc()
+	fields := Fields{"topic": cerr.Topic, "partition": cerr.Partition, "fetch_max": kc.config.FetchMax}
+	if kc.config.SkipOversized {
+		kc.logger().Warn("skipping message larger than KAFKA_FETCH_MAX", fields)
+		return true
+	}
+
+	kc.logger().Error("message larger than KAFKA_FETCH_MAX, blocking partition. "+
+		"Raise KAFKA_FETCH_MAX or set KAFKA_SKIP_OVERSIZED and restart.", fields)
+	kc.block(cerr.Topic, cerr.Partition)
+	return true
+}
@@ -0,0 +1,182 @@
+package kafka
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Offsets of a partition waiting on an earlier one before the partition's
+// commits are given up on until it is delivered again
+const maxPendingOffsets = 10000
+
+var commitStalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "commit_stalls_total",
+	Help:      "Partitions whose commits stopped behind a message that was never marked, by topic.",
+}, []string{"topic"})
+
+func init() {
+	prometheus.MustRegister(commitStalls)
+}
+
+// partitionPool handles the messages of every partition on up to
+// Config.MaxConcurrentPerPartition workers of its own, so a slow partition
+// doesn't hold back the others. With a single worker a partition is
+// handled in order. Offsets are marked in offset order whatever the order
+// handlers finish in.
+type partitionPool struct {
+	workers int
+	buffer  int
+
+	mu     sync.Mutex
+	queues map[topicPartition]chan job
+	orders map[topicPartition]*commitOrder
+}
+
+func newPartitionPool(workers int, buffer int) *partitionPool {
+	return &partitionPool{
+		workers: workers,
+		buffer:  buffer,
+		queues:  make(map[topicPartition]chan job),
+		orders:  make(map[topicPartition]*commitOrder),
+	}
+}
+
+// Queue the message on the workers of its partition, starting them on the
+// first message. Blocks while the partition's queue is full, which holds
+// back the consume loop, unless the job's ctx is cancelled; the message is
+// then dropped, left uncommitted along with everything after it.
+func (kc *Client) submitPartition(p *partitionPool, j job) {
+	tp := topicPartition{j.msg.Topic, j.msg.Partition}
+
+	p.mu.Lock()
+	queue, ok := p.queues[tp]
+	if !ok {
+		queue = make(chan job, p.buffer)
+		p.queues[tp] = queue
+		order := kc.newCommitOrder(tp)
+		p.orders[tp] = order
+		for i := 0; i < p.workers; i++ {
+			go func() {
+				for j := range queue {
+					kc.process(j.ctx, j.msg, j.handler, order.done)
+					kc.inflight.Done()
+				}
+			}()
+		}
+	}
+	order := p.orders[tp]
+	p.mu.Unlock()
+
+	if !kc.startInflight() {
+		return
+	}
+	order.add(j.msg.Offset)
+	select {
+	case queue <- j:
+	case <-j.ctx.Done():
+		kc.inflight.Done()
+	}
+}
+
+// Stop accepting messages. Workers exit once their queue is drained.
+func (p *partitionPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, queue := range p.queues {
+		close(queue)
+	}
+}
+
+// Marks the offsets of a partition only once every earlier message was
+// marked too, so a commit never skips a message still being handled.
+// A message that is never marked, on a blocked partition or when it
+// couldn't be dead-lettered, holds back every later one; once
+// maxPendingOffsets are waiting on it the partition is reported as stalled
+// and nothing more is tracked until it is delivered again, so memory stays
+// bounded. What was handled meanwhile is delivered again then.
+type commitOrder struct {
+	tp     topicPartition
+	mark   func(*sarama.ConsumerMessage)
+	logger Logger
+
+	mu       sync.Mutex
+	pending  []int64 // Offsets not marked yet, ascending
+	finished map[int64]*sarama.ConsumerMessage
+	last     int64 // Last offset added
+	stalled  bool
+}
+
+func (kc *Client) newCommitOrder(tp topicPartition) *commitOrder {
+	return &commitOrder{tp: tp, mark: kc.markOffset, logger: kc.logger(), last: -1}
+}
+
+func (o *commitOrder) add(offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// An offset at or before the last one means the partition is delivered
+	// again from its committed offset, e.g. after a rebalance, and what
+	// was pending won't be committed from here
+	if offset <= o.last {
+		o.pending = nil
+		o.stalled = false
+	}
+	o.last = offset
+	if o.stalled {
+		return
+	}
+	if len(o.pending) == 0 {
+		o.finished = make(map[int64]*sarama.ConsumerMessage)
+	}
+	if len(o.pending) >= maxPendingOffsets {
+		o.stall()
+		return
+	}
+	o.pending = append(o.pending, offset)
+}
+
+// Give up on the commits of the partition until it starts over. Marking
+// anything past the oldest pending offset could commit a message that was
+// never handled.
+func (o *commitOrder) stall() {
+	o.logger.Error("offset commits stalled behind a message that was never marked", Fields{
+		"topic":     o.tp.topic,
+		"partition": o.tp.partition,
+		"offset":    o.pending[0],
+		"pending":   len(o.pending),
+	})
+	commitStalls.WithLabelValues(o.tp.topic).Inc()
+	o.stalled = true
+	o.pending = nil
+	o.finished = nil
+}
+
+func (o *commitOrder) done(msg *sarama.ConsumerMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stalled {
+		return
+	}
+	i := sort.Search(len(o.pending), func(i int) bool { return o.pending[i] >= msg.Offset })
+	if i == len(o.pending) || o.pending[i] != msg.Offset {
+		// Delivered before the partition started over
+		return
+	}
+	o.finished[msg.Offset] = msg
+
+	var last *sarama.ConsumerMessage
+	for len(o.pending) > 0 && o.finished[o.pending[0]] != nil {
+		last = o.finished[o.pending[0]]
+		delete(o.finished, o.pending[0])
+		o.pending = o.pending[1:]
+	}
+	if last != nil {
+		o.mark(last)
+	}
+}
@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// A commit order recording what it marks
+func newTestCommitOrder() (*commitOrder, func() []int64) {
+	var mu sync.Mutex
+	var marked []int64
+	o := &commitOrder{
+		tp:     topicPartition{"orders", 0},
+		logger: discardLogger{},
+		last:   -1,
+		mark: func(msg *sarama.ConsumerMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			marked = append(marked, msg.Offset)
+		},
+	}
+	return o, func() []int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int64(nil), marked...)
+	}
+}
+
+func TestCommitOrderMarksInOffsetOrder(t *testing.T) {
+	o, marked := newTestCommitOrder()
+	for offset := int64(0); offset < 3; offset++ {
+		o.add(offset)
+	}
+
+	o.done(&sarama.ConsumerMessage{Offset: 2})
+	o.done(&sarama.ConsumerMessage{Offset: 1})
+	if got := marked(); len(got) != 0 {
+		t.Fatalf("marked %v while offset 0 is still being handled", got)
+	}
+	o.done(&sarama.ConsumerMessage{Offset: 0})
+	if got := marked(); !reflect.DeepEqual(got, []int64{2}) {
+		t.Fatalf("marked %v, want [2]", got)
+	}
+}
+
+func TestCommitOrderStallsBehindAMessageNeverMarked(t *testing.T) {
+	o, marked := newTestCommitOrder()
+	stalls := func() float64 { return counterValue(t, "kafka_commit_stalls_total", "orders") }
+	before := stalls()
+
+	// Offset 0 is never marked, e.g. its partition was blocked
+	for offset := int64(0); offset <= maxPendingOffsets; offset++ {
+		o.add(offset)
+		if offset > 0 {
+			o.done(&sarama.ConsumerMessage{Offset: offset})
+		}
+	}
+
+	if got := stalls() - before; got != 1 {
+		t.Fatalf("counted %v stalls, want 1", got)
+	}
+	if len(o.pending) != 0 || len(o.finished) != 0 {
+		t.Fatalf("still tracking %d pending and %d finished offsets", len(o.pending), len(o.finished))
+	}
+	o.done(&sarama.ConsumerMessage{Offset: 0})
+	if got := marked(); len(got) != 0 {
+		t.Fatalf("marked %v past the stalled offset", got)
+	}
+
+	// Delivered again from the committed offset, tracked from there
+	o.add(0)
+	o.done(&sarama.ConsumerMessage{Offset: 0})
+	if got := marked(); !reflect.DeepEqual(got, []int64{0}) {
+		t.Fatalf("marked %v after the partition started over, want [0]", got)
+	}
+}
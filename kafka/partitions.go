@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// ConsumePartitions : Consumes a fixed set of partitions of a topic
+// directly, bypassing consumer group balancing, e.g. for a replay worker
+// that must process partition 3 only. Offsets are committed manually under
+// the "<group>-partitions" group, so they never clash with the balanced
+// consumer. Messages of a partition are handled one at a time, in order,
+// through the same pipeline as Process. Returns when ctx is cancelled, or
+// with nil once Shutdown is called, which waits for the running handlers
+// like it does for those of Consume.
+func (kc *Client) ConsumePartitions(ctx context.Context, topic string, partitions []int32, handler Handler) error {
+	config := sarama.NewConfig()
+	config.Net.TLS.Config = kc.tlsConfig
+	config.Net.TLS.Enable = true
+	kc.config.applySASL(config)
+	version, err := kc.config.kafkaVersion(config.Version)
+	if err != nil {
+		return err
+	}
+	config.Version = version
+	config.ChannelBufferSize = kc.config.ChannelBufferSize
+	config.MetricRegistry = kc.config.MetricRegistry
+	config.Consumer.Offsets.Initial = kc.config.initialOffset()
+	config.Consumer.IsolationLevel = kc.config.isolationLevel()
+
+	client, err := sarama.NewClient(kc.brokers, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	offsets, err := sarama.NewOffsetManagerFromClient(kc.config.group()+"-partitions", client)
+	if err != nil {
+		return err
+	}
+	defer offsets.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	// Stop the partition consumers and wait for their handlers before the
+	// last offsets are flushed
+	var wg sync.WaitGroup
+	var poms []sarama.PartitionOffsetManager
+	var pcs []sarama.PartitionConsumer
+	defer func() {
+		for _, pc := range pcs {
+			pc.AsyncClose()
+		}
+		wg.Wait()
+		for _, pom := range poms {
+			pom.Close()
+		}
+	}()
+
+	topic = kc.config.topic(topic)
+	for _, partition := range partitions {
+		pom, err := offsets.ManagePartition(topic, partition)
+		if err != nil {
+			return err
+		}
+		poms = append(poms, pom)
+
+		next, _ := pom.NextOffset()
+		pc, err := consumer.ConsumePartition(topic, partition, next)
+		if err != nil {
+			return err
+		}
+		pcs = append(pcs, pc)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mark := func(msg *sarama.ConsumerMessage) {
+				pom.MarkOffset(msg.Offset+1, "")
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-kc.done:
+					return
+				case msg, ok := <-pc.Messages():
+					if !ok || !kc.startInflight() {
+						return
+					}
+					kc.process(ctx, msg, handler, mark)
+					kc.inflight.Done()
+				}
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-kc.done:
+		return nil
+	}
+}
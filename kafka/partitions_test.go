@@ -0,0 +1,171 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// A broker behind TLS with a throwaway self-signed certificate, as the
+// client always connects with TLS
+func newTLSMockBroker(t *testing.T) *sarama.MockBroker {
+	certPEM, keyPEM := selfSignedCert(t)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sarama.NewMockBrokerListener(t, 1, tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}}))
+}
+
+// A client whose partitions consumer reads partition 0 of print_jobs from
+// broker, with no committed offset, starting from the oldest offset
+func newPartitionsTestClient(t *testing.T, broker *sarama.MockBroker, fetch sarama.MockResponse) *Client {
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("print_jobs", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("print_jobs", 0, sarama.OffsetOldest, 0).
+			SetOffset("print_jobs", 0, sarama.OffsetNewest, 0),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "print-partitions", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("print-partitions", "print_jobs", 0, -1, "", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"FetchRequest":        fetch,
+	})
+
+	kc := newTestClient(newMockConsumer())
+	kc.config.ConsumerGroup = "print"
+	kc.config.OffsetReset = OffsetResetOldest
+	kc.config.MetricRegistry = metrics.NewRegistry()
+	kc.brokers = []string{broker.Addr()}
+	kc.tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	return kc
+}
+
+func TestOffsetResetOldestStartsAnEmptyPartitionAtZero(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	defer broker.Close()
+
+	// The partition is empty when the group joins, with no committed offset,
+	// and three messages are produced after a few empty fetches
+	empty := sarama.NewMockFetchResponse(t, 1).SetHighWaterMark("print_jobs", 0, 0)
+	produced := sarama.NewMockFetchResponse(t, 3).SetHighWaterMark("print_jobs", 0, 3)
+	for offset := int64(0); offset < 3; offset++ {
+		produced.SetMessage("print_jobs", 0, offset, sarama.StringEncoder("{}"))
+	}
+	kc := newPartitionsTestClient(t, broker, sarama.NewMockSequence(empty, empty, empty, produced))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	first := make(chan int64, 1)
+	err := kc.ConsumePartitions(ctx, "print_jobs", []int32{0}, func(_ context.Context, msg Message) error {
+		select {
+		case first <- msg.Offset:
+		default:
+		}
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("ConsumePartitions returned %v", err)
+	}
+
+	select {
+	case offset := <-first:
+		if offset != 0 {
+			t.Fatalf("first consumed offset is %d, want 0", offset)
+		}
+	default:
+		t.Fatal("nothing was consumed")
+	}
+	fetches := 0
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.FetchRequest); ok {
+			fetches++
+		}
+	}
+	if fetches < 4 {
+		t.Fatalf("%d fetches, want the empty ones before the messages", fetches)
+	}
+}
+
+func TestInitialOffset(t *testing.T) {
+	for _, c := range []struct {
+		cfg  Config
+		want int64
+	}{
+		{Config{OffsetReset: OffsetResetNewest}, sarama.OffsetNewest},
+		{Config{OffsetReset: OffsetResetOldest}, sarama.OffsetOldest},
+		{Config{OffsetReset: OffsetResetNewest, EphemeralGroup: true}, sarama.OffsetOldest},
+	} {
+		if got := c.cfg.initialOffset(); got != c.want {
+			t.Errorf("%+v gave %d, want %d", c.cfg, got, c.want)
+		}
+	}
+}
+
+func TestShutdownWaitsForPartitionHandlers(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	defer broker.Close()
+	fetch := sarama.NewMockFetchResponse(t, 1).
+		SetHighWaterMark("print_jobs", 0, 1).
+		SetMessage("print_jobs", 0, 0, sarama.StringEncoder("{}"))
+
+	kc := newPartitionsTestClient(t, broker, fetch)
+	connected, _ := newConnectedTestClient(t)
+	kc.done, kc.closing, kc.producer = connected.done, connected.closing, connected.producer
+	kc.config.ShutdownTimeout = 5 * time.Second
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	returned := make(chan error, 1)
+	go func() {
+		returned <- kc.ConsumePartitions(context.Background(), "print_jobs", []int32{0}, func(context.Context, Message) error {
+			once.Do(func() {
+				close(started)
+				<-release
+			})
+			return nil
+		})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was consumed")
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- kc.Shutdown(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown returned while a partition handler was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatalf("ConsumePartitions returned %v after Shutdown, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumePartitions still running after Shutdown")
+	}
+}
@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Ping : Checks that every broker is reachable, presents a valid
+// certificate and answers a metadata request. Unlike Connect it does not
+// join the consumer group, so it can be used to validate credentials and
+// network access without triggering a rebalance. Fails once ctx is done,
+// the dial, handshake and metadata request of every broker being bounded
+// by its deadline.
+func Ping(ctx context.Context, cfg *Config) error {
+	tlsConfig, err := cfg.createTLSConfig()
+	if err != nil {
+		return err
+	}
+	trustedCert, err := cfg.trustedCert()
+	if err != nil {
+		return err
+	}
+
+	config := sarama.NewConfig()
+	config.Net.TLS.Config = tlsConfig
+	config.Net.TLS.Enable = true
+	cfg.applySASL(config)
+	if config.Version, err = cfg.kafkaVersion(config.Version); err != nil {
+		return err
+	}
+
+	brokers, err := cfg.BrokerAddresses()
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range brokers {
+		if err := boundTimeouts(ctx, config); err != nil {
+			return fmt.Errorf("broker %s: %v", addr, err)
+		}
+
+		ok, err := verifyServerCert(ctx, tlsConfig, trustedCert, addr)
+		if err != nil {
+			return fmt.Errorf("broker %s: %v", addr, err)
+		}
+		if !ok {
+			return fmt.Errorf("broker %s has invalid certificate", addr)
+		}
+
+		broker := sarama.NewBroker(addr)
+		if err := broker.Open(config); err != nil {
+			return fmt.Errorf("broker %s: %v", addr, err)
+		}
+
+		_, err = broker.GetMetadata(&sarama.MetadataRequest{})
+		broker.Close()
+		if err != nil {
+			return fmt.Errorf("broker %s: metadata request failed: %v", addr, err)
+		}
+	}
+
+	return nil
+}
+
+// Shorten the network timeouts of a sarama connection to what is left
+// until the deadline of ctx, as sarama's broker takes no context
+func boundTimeouts(ctx context.Context, config *sarama.Config) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	left := time.Until(deadline)
+	if left <= 0 {
+		return context.DeadlineExceeded
+	}
+	for _, timeout := range []*time.Duration{&config.Net.DialTimeout, &config.Net.ReadTimeout, &config.Net.WriteTimeout} {
+		if *timeout > left {
+			*timeout = left
+		}
+	}
+	return nil
+}
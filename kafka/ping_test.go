@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A self-signed certificate and its key, PEM encoded
+func selfSignedCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "broker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// A config trusting a self-signed certificate, used as the client cert too
+func pingConfig(t *testing.T, broker string) *Config {
+	cert, key := selfSignedCert(t)
+	return &Config{
+		Brokers:       []string{broker},
+		TrustedCert:   cert,
+		ClientCert:    cert,
+		ClientCertKey: key,
+		TLSMinVersion: "1.2",
+	}
+}
+
+func TestPingGivesUpOnABrokerThatNeverHandshakes(t *testing.T) {
+	// Accepts connections and never answers the TLS client hello
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cfg := pingConfig(t, ln.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := Ping(ctx, cfg); err == nil {
+		t.Fatal("ping succeeded without a handshake")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("ping took %s, past the deadline of its context", elapsed)
+	}
+}
+
+func TestPingFailsOnceItsContextIsDone(t *testing.T) {
+	cfg := pingConfig(t, "127.0.0.1:1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Ping(ctx, cfg); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("ping after its context was cancelled returned %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var poisonMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "poison_messages_total",
+	Help:      "Messages skipped after failing on every delivery.",
+}, []string{"topic"})
+
+func init() {
+	prometheus.MustRegister(poisonMessages)
+}
+
+// Consecutive failed handler attempts on the message at offset
+type offsetFailures struct {
+	offset int64
+	count  int
+}
+
+// Count the failed attempts of a delivery of the message, the handler's
+// retries included, reporting whether it has now failed more than
+// Config.PoisonThreshold times in a row. Failures are counted per
+// partition and start over when another offset fails or the partition
+// makes progress. The count is local to the process: it is lost on
+// restart, so a message that crashes the process is never detected.
+func (kc *Client) isPoison(msg *sarama.ConsumerMessage, attempts int) bool {
+	if kc.config.PoisonThreshold <= 0 {
+		return false
+	}
+
+	kc.failuresMu.Lock()
+	defer kc.failuresMu.Unlock()
+
+	if kc.failures == nil {
+		kc.failures = make(map[topicPartition]offsetFailures)
+	}
+	tp := topicPartition{msg.Topic, msg.Partition}
+	f := kc.failures[tp]
+	if f.offset != msg.Offset {
+		f = offsetFailures{offset: msg.Offset}
+	}
+	f.count += attempts
+	kc.failures[tp] = f
+
+	return f.count > kc.config.PoisonThreshold
+}
+
+// Forget the failures of a partition once one of its messages was handled
+func (kc *Client) clearFailures(msg *sarama.ConsumerMessage) {
+	if kc.config.PoisonThreshold <= 0 {
+		return
+	}
+
+	kc.failuresMu.Lock()
+	defer kc.failuresMu.Unlock()
+
+	delete(kc.failures, topicPartition{msg.Topic, msg.Partition})
+}
+
+// Move a poison message out of the way: dead-letter it when a dead-letter
+// topic is set and commit past it
+func (kc *Client) skipPoison(msg *sarama.ConsumerMessage, cause error, retries int, markOffset func(*sarama.ConsumerMessage)) {
+	poisonMessages.WithLabelValues(msg.Topic).Inc()
+	fields := messageFields(msg, cause)
+	fields["poison_threshold"] = kc.config.PoisonThreshold
+	kc.logger().Error("message failed too many times in a row, skipping it as poison", fields)
+
+	if kc.config.deadLettering() {
+		if err := kc.deadLetter(msg, cause, retries); err != nil {
+			kc.logger().Error("failed to dead-letter message, blocking partition", messageFields(msg, err))
+			kc.block(msg.Topic, msg.Partition)
+			return
+		}
+	}
+	kc.clearFailures(msg)
+	markOffset(msg)
+}
+
+// Let the partitions blocked by failed messages be retried. After a
+// rebalance they start over from their committed offset, so the failed
+// messages are delivered again.
+func (kc *Client) unblockAll() {
+	kc.blockedMu.Lock()
+	defer kc.blockedMu.Unlock()
+
+	kc.blocked = nil
+}
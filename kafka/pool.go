@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+type job struct {
+	ctx     context.Context
+	msg     *sarama.ConsumerMessage
+	handler Handler
+}
+
+// keyedPool routes messages to a fixed set of workers by a hash of their
+// key, so all messages for a key are handled one at a time and in order,
+// while different keys are handled in parallel. Messages without a key are
+// routed by partition instead. Offsets are marked in offset order per
+// partition, so a fast key never commits past a message of another key
+// still being handled.
+type keyedPool struct {
+	queues []chan sharedJob
+
+	mu     sync.Mutex
+	orders map[topicPartition]*commitOrder
+}
+
+func (kc *Client) newKeyedPool(workers int, buffer int) *keyedPool {
+	p := &keyedPool{
+		queues: make([]chan sharedJob, workers),
+		orders: make(map[topicPartition]*commitOrder),
+	}
+	for i := range p.queues {
+		queue := make(chan sharedJob, buffer)
+		p.queues[i] = queue
+		go func() {
+			for j := range queue {
+				kc.process(j.ctx, j.msg, j.handler, j.order.done)
+				kc.inflight.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// Queue the message on the worker for its key. Blocks while that worker's
+// queue is full, which holds back the consume loop, unless the job's ctx
+// is cancelled; the message is then dropped, left uncommitted along with
+// everything after it on its partition.
+func (kc *Client) submit(p *keyedPool, j job) {
+	tp := topicPartition{j.msg.Topic, j.msg.Partition}
+
+	p.mu.Lock()
+	order, ok := p.orders[tp]
+	if !ok {
+		order = kc.newCommitOrder(tp)
+		p.orders[tp] = order
+	}
+	p.mu.Unlock()
+
+	key := j.msg.Key
+	if key == nil {
+		key = []byte(strconv.Itoa(int(j.msg.Partition)))
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+
+	if !kc.startInflight() {
+		return
+	}
+	order.add(j.msg.Offset)
+	select {
+	case p.queues[h.Sum32()%uint32(len(p.queues))] <- sharedJob{j, order}:
+	case <-j.ctx.Done():
+		kc.inflight.Done()
+	}
+}
+
+// Stop accepting messages. Workers exit once their queue is drained.
+func (p *keyedPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+}
@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Two keys routed to different workers of a pool of the given size
+func keysOnDifferentWorkers(workers int) (string, string) {
+	worker := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % uint32(workers)
+	}
+	for i := 1; ; i++ {
+		if other := fmt.Sprintf("key-%d", i); worker(other) != worker("key-0") {
+			return "key-0", other
+		}
+	}
+}
+
+func TestKeyedPoolHandlesEachKeyInOrder(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+	pool := kc.newKeyedPool(4, 16)
+	defer pool.close()
+
+	var mu sync.Mutex
+	handled := make(map[string][]int64)
+	var wg sync.WaitGroup
+	handler := func(_ context.Context, msg Message) error {
+		defer wg.Done()
+		// Later messages of a key finishing first would show up out of order
+		time.Sleep(time.Duration(3-msg.Offset%3) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		handled[msg.Topic] = append(handled[msg.Topic], msg.Offset)
+		return nil
+	}
+
+	keys := []string{"a", "b", "c"}
+	for offset := int64(0); offset < 30; offset++ {
+		key := keys[offset%3]
+		wg.Add(1)
+		// The topic records the key, as the handler doesn't see it
+		kc.submit(pool, job{context.Background(), &sarama.ConsumerMessage{Topic: key, Key: []byte(key), Offset: offset}, handler})
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		var want []int64
+		for offset := int64(i); offset < 30; offset += 3 {
+			want = append(want, offset)
+		}
+		if !reflect.DeepEqual(handled[key], want) {
+			t.Fatalf("key %s handled as %v, want %v", key, handled[key], want)
+		}
+	}
+}
+
+func TestKeyedPoolDoesntCommitPastASlowKey(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	pool := kc.newKeyedPool(2, 16)
+	defer pool.close()
+	slow, fast := keysOnDifferentWorkers(2)
+
+	release := make(chan struct{})
+	fastDone := make(chan struct{})
+	handler := func(_ context.Context, msg Message) error {
+		if msg.Offset == 0 {
+			<-release
+		} else {
+			close(fastDone)
+		}
+		return nil
+	}
+	kc.submit(pool, job{context.Background(), &sarama.ConsumerMessage{Topic: "orders", Key: []byte(slow), Offset: 0}, handler})
+	kc.submit(pool, job{context.Background(), &sarama.ConsumerMessage{Topic: "orders", Key: []byte(fast), Offset: 1}, handler})
+
+	select {
+	case <-fastDone:
+	case <-time.After(time.Second):
+		t.Fatal("a key on another worker waited for the slow one")
+	}
+	if marked := consumer.markedOffsets(); len(marked) != 0 {
+		t.Fatalf("marked %v while offset 0 is still being handled", marked)
+	}
+
+	close(release)
+	kc.inflight.Wait()
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1}) {
+		t.Fatalf("marked %v, want [1] once both are handled", marked)
+	}
+}
+
+func TestKeyedPoolRoutesMessagesWithoutAKeyByPartition(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+	pool := kc.newKeyedPool(4, 16)
+	defer pool.close()
+
+	var mu sync.Mutex
+	var handled []int64
+	var wg sync.WaitGroup
+	handler := func(_ context.Context, msg Message) error {
+		defer wg.Done()
+		time.Sleep(time.Duration(3-msg.Offset%3) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, msg.Offset)
+		return nil
+	}
+	for offset := int64(0); offset < 10; offset++ {
+		wg.Add(1)
+		kc.submit(pool, job{context.Background(), &sarama.ConsumerMessage{Topic: "orders", Partition: 2, Offset: offset}, handler})
+	}
+	wg.Wait()
+
+	if want := []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(handled, want) {
+		t.Fatalf("handled %v, want the partition in order", handled)
+	}
+}
@@ -0,0 +1,167 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestTopicUsesItsOwnPrefix(t *testing.T) {
+	cfg := &Config{
+		Prefix:        "global.",
+		TopicPrefixes: PrefixMap{"order_events": "tenantA.", "print_jobs": "tenantB."},
+	}
+
+	if got := cfg.topic("order_events"); got != "tenantA.order_events" {
+		t.Fatalf("order_events resolved to %q", got)
+	}
+	if got := cfg.topic("print_jobs"); got != "tenantB.print_jobs" {
+		t.Fatalf("print_jobs resolved to %q", got)
+	}
+}
+
+func TestTopicFallsBackToTheGlobalPrefix(t *testing.T) {
+	cfg := &Config{Prefix: "global.", TopicPrefixes: PrefixMap{"order_events": "tenantA."}}
+
+	if got := cfg.topic("invoices"); got != "global.invoices" {
+		t.Fatalf("invoices resolved to %q, want the global prefix", got)
+	}
+	// An empty prefix in the map is an override too
+	cfg.TopicPrefixes["audit"] = ""
+	if got := cfg.topic("audit"); got != "audit" {
+		t.Fatalf("audit resolved to %q, want no prefix", got)
+	}
+}
+
+func TestTopicWithoutPrefixes(t *testing.T) {
+	for _, prefixes := range []PrefixMap{nil, {}} {
+		cfg := &Config{Prefix: "global.", TopicPrefixes: prefixes}
+		if got := cfg.topic("order_events"); got != "global.order_events" {
+			t.Fatalf("with %#v, order_events resolved to %q", prefixes, got)
+		}
+	}
+
+	cfg := &Config{}
+	if got := cfg.topic("order_events"); got != "order_events" {
+		t.Fatalf("without any prefix, order_events resolved to %q", got)
+	}
+}
+
+func TestGroupPrefix(t *testing.T) {
+	cfg := &Config{ConsumerGroup: "printers", Prefix: "global."}
+	if got := cfg.group(); got != "global.printers" {
+		t.Fatalf("group is %q, want the global prefix", got)
+	}
+
+	cfg.GroupPrefix = "tenantA."
+	if got := cfg.group(); got != "tenantA.printers" {
+		t.Fatalf("group is %q, want GroupPrefix", got)
+	}
+
+	// Topic prefixes don't apply to the group
+	cfg = &Config{ConsumerGroup: "printers", TopicPrefixes: PrefixMap{"printers": "tenantB."}}
+	if got := cfg.group(); got != "printers" {
+		t.Fatalf("group is %q, want no prefix", got)
+	}
+}
+
+func TestPrefixMapDecode(t *testing.T) {
+	var m PrefixMap
+	if err := m.Decode("order_events=tenantA., print_jobs=tenantB.,"); err != nil {
+		t.Fatalf("Decode returned %v", err)
+	}
+	want := PrefixMap{"order_events": "tenantA.", "print_jobs": "tenantB."}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("decoded %v, want %v", m, want)
+	}
+
+	if err := m.Decode(""); err != nil || len(m) != 0 {
+		t.Fatalf("empty value decoded to %v, %v", m, err)
+	}
+	if err := m.Decode("order_events"); err == nil {
+		t.Fatal("a pair without = was accepted")
+	}
+}
+
+// The message Process hands to the handler for a message of topic
+func processedMessage(t *testing.T, kc *Client, topic string) Message {
+	var got *Message
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: topic}, func(_ context.Context, msg Message) error {
+		got = &msg
+		return nil
+	})
+	if got == nil {
+		t.Fatalf("the message of %s wasn't handled", topic)
+	}
+	return *got
+}
+
+func TestLogicalTopic(t *testing.T) {
+	for _, c := range []struct {
+		prefix   string
+		prefixes PrefixMap
+		topic    string
+		want     string
+	}{
+		{"tenantA.", nil, "tenantA.order_events", "order_events"},
+		{"tenantA.", nil, "order_events", "order_events"},
+		{"tenantA.", nil, "tenantB.order_events", "tenantB.order_events"},
+		{"", nil, "order_events", "order_events"},
+		{"global.", PrefixMap{"print_jobs": "tenantB."}, "tenantB.print_jobs", "print_jobs"},
+	} {
+		kc := newTestClient(newMockConsumer())
+		kc.config.Prefix = c.prefix
+		kc.config.TopicPrefixes = c.prefixes
+
+		msg := processedMessage(t, kc, c.topic)
+		if got := msg.LogicalTopic(); got != c.want {
+			t.Errorf("prefix %q: LogicalTopic of %s is %q, want %q", c.prefix, c.topic, got, c.want)
+		}
+		if msg.Topic != c.topic {
+			t.Errorf("prefix %q: Topic is %q, want the full name %q", c.prefix, msg.Topic, c.topic)
+		}
+	}
+}
+
+func TestLogicalTopicOfAMessageNotFromProcess(t *testing.T) {
+	if got := (Message{Topic: "tenantA.order_events"}).LogicalTopic(); got != "tenantA.order_events" {
+		t.Fatalf("LogicalTopic is %q, want the topic as is", got)
+	}
+}
+
+func TestPublishedTopicsRoundTripUnderThePrefix(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+	kc.config.Prefix = "tenantA."
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	if err := kc.PublishWithOptions("order_events", []byte("{}"), PublishOptions{}); err != nil {
+		t.Fatalf("PublishWithOptions returned %v", err)
+	}
+	if err := kc.TryPublish("order_events", []byte("{}"), PublishOptions{}); err != nil {
+		t.Fatalf("TryPublish returned %v", err)
+	}
+	if _, _, err := kc.Publish(context.Background(), "order_events", nil, []byte("{}")); err != nil {
+		t.Fatalf("Publish returned %v", err)
+	}
+	if _, _, err := kc.ProduceSync("order_events", []byte("{}")); err != nil {
+		t.Fatalf("ProduceSync returned %v", err)
+	}
+
+	msgs := producer.published()
+	if len(msgs) != 4 {
+		t.Fatalf("published %d messages, want 4", len(msgs))
+	}
+	for _, p := range msgs {
+		if p.topic != "tenantA.order_events" {
+			t.Fatalf("published to %q, want tenantA.order_events", p.topic)
+		}
+		// Consumed back from the topic it was published to
+		msg := processedMessage(t, kc, p.topic)
+		if msg.Topic != "tenantA.order_events" || msg.LogicalTopic() != "order_events" {
+			t.Fatalf("consumed %q as %q", msg.Topic, msg.LogicalTopic())
+		}
+	}
+}
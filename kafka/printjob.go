@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// PrintJob : A job of the print service, as carried by the print job
+// topics
+type PrintJob struct {
+	JobID       string `json:"job_id"`
+	PrinterID   string `json:"printer_id"`
+	DocumentRef string `json:"document_ref"`
+	Copies      int    `json:"copies"`
+	Status      string `json:"status,omitempty"`
+}
+
+// Validate : Checks the job has the fields the print service needs,
+// reporting every missing one at once
+func (j PrintJob) Validate() error {
+	var verr ValidationError
+	if j.JobID == "" {
+		verr.Errors = append(verr.Errors, "job_id is required")
+	}
+	if j.PrinterID == "" {
+		verr.Errors = append(verr.Errors, "printer_id is required")
+	}
+	if j.DocumentRef == "" {
+		verr.Errors = append(verr.Errors, "document_ref is required")
+	}
+	if j.Copies < 1 {
+		verr.Errors = append(verr.Errors, "copies must be at least 1")
+	}
+	if len(verr.Errors) > 0 {
+		return &verr
+	}
+	return nil
+}
+
+// Status event of a print job, published by EmitJobStatus
+type printJobStatus struct {
+	JobID     string    `json:"job_id"`
+	Status    string    `json:"status"`
+	EmittedAt time.Time `json:"emitted_at"`
+}
+
+// EmitJobStatus : Publishes a status event of a print job to
+// Config.PrintJobStatusTopic, keyed by the job id so the events of a job
+// stay in order. The event is labelled as JSON when the messages can carry
+// headers, from KAFKA_VERSION 0.11.0.
+func (kc *Client) EmitJobStatus(jobID string, status string) error {
+	if jobID == "" || status == "" {
+		return errors.New("kafka: job status needs a job id and a status")
+	}
+
+	value, err := json.Marshal(printJobStatus{
+		JobID:     jobID,
+		Status:    status,
+		EmittedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	opts := PublishOptions{Key: []byte(jobID)}
+	// An invalid version was refused by Validate before Connect
+	if version, err := kc.config.kafkaVersion(sarama.MinVersion); err == nil && version.IsAtLeast(sarama.V0_11_0_0) {
+		opts.ContentType = ContentTypeJSON
+	}
+	return kc.PublishWithOptions(kc.config.PrintJobStatusTopic, value, opts)
+}
+
+// ConsumePrintJobs : Like Consume, with the messages decoded into print
+// jobs. A message that isn't a valid job fails with a *ValidationError
+// without reaching the handler. That failure is permanent: it isn't
+// retried, and is resolved according to Config.OnPermanentError at once,
+// e.g. dead-lettered with the missing fields in its headers.
+func (kc *Client) ConsumePrintJobs(ctx context.Context, handler func(context.Context, PrintJob) error, opts ...ConsumeOption) error {
+	return kc.Consume(ctx, func(ctx context.Context, msg Message) error {
+		var job PrintJob
+		if err := msg.Decode(&job); err != nil {
+			return &ValidationError{Errors: []string{err.Error()}}
+		}
+		if err := job.Validate(); err != nil {
+			return err
+		}
+		return handler(ctx, job)
+	}, opts...)
+}
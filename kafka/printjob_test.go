@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestEmitJobStatusLabelsJSONWhenHeadersAreSupported(t *testing.T) {
+	for version, want := range map[string]string{"": "", "0.10.2": "", "2.1.0": ContentTypeJSON} {
+		kc := newTestClient(newMockConsumer())
+		kc.config.Version = version
+		kc.config.PrintJobStatusTopic = "print_job_status"
+		producer := &recordingProducer{}
+		kc.SetProducer(producer)
+
+		if err := kc.EmitJobStatus("job-1", "printed"); err != nil {
+			t.Fatalf("version %q: EmitJobStatus returned %v", version, err)
+		}
+		msgs := producer.published()
+		if len(msgs) != 1 {
+			t.Fatalf("version %q: published %d messages", version, len(msgs))
+		}
+		if msgs[0].opts.ContentType != want {
+			t.Fatalf("version %q: content type %q, want %q", version, msgs[0].opts.ContentType, want)
+		}
+		if string(msgs[0].opts.Key) != "job-1" || msgs[0].topic != "print_job_status" {
+			t.Fatalf("version %q: published %+v", version, msgs[0])
+		}
+		var status printJobStatus
+		if err := json.Unmarshal(msgs[0].value, &status); err != nil || status.Status != "printed" {
+			t.Fatalf("version %q: value %s, %v", version, msgs[0].value, err)
+		}
+	}
+}
+
+func TestEmitJobStatusNeedsAJobAndAStatus(t *testing.T) {
+	kc := newTestClient(newMockConsumer())
+	kc.SetProducer(&recordingProducer{})
+	if err := kc.EmitJobStatus("", "printed"); err == nil {
+		t.Fatal("a status without a job id was published")
+	}
+}
+
+// Wait for the offsets consumer marked to become want
+func waitMarked(t *testing.T, consumer *mockConsumer, want []int64) {
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(consumer.markedOffsets(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("marked %v, want %v", consumer.markedOffsets(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInvalidPrintJobsAreDeadLetteredWithoutRetries(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.Version = "2.1.0"
+	kc.config.DeadLetterTopic = "dead"
+	kc.config.DLTHeaderPrefix = "x-"
+	// Retrying would take minutes
+	kc.config.MaxRetries = 3
+	kc.config.RetryBackoff = time.Minute
+	kc.config.RetryBackoffMax = time.Minute
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var handled []PrintJob
+	go kc.ConsumePrintJobs(ctx, func(_ context.Context, job PrintJob) error {
+		handled = append(handled, job)
+		return nil
+	})
+
+	consumer.messages <- &sarama.ConsumerMessage{Topic: "print_jobs", Offset: 1, Value: []byte(`{"job_id":"job-1"}`)}
+	waitMarked(t, consumer, []int64{1})
+	consumer.messages <- &sarama.ConsumerMessage{Topic: "print_jobs", Offset: 2, Value: []byte(`{"job_id":"job-2","printer_id":"kitchen","document_ref":"doc","copies":1}`)}
+	waitMarked(t, consumer, []int64{1, 2})
+
+	msgs := producer.published()
+	if len(msgs) != 1 || msgs[0].topic != "dead" {
+		t.Fatalf("published %+v, want one dead letter", msgs)
+	}
+	if errs := string(msgs[0].opts.RawHeaders["x-validation-errors"]); errs == "" {
+		t.Fatal("dead letter has no validation errors header")
+	}
+	if len(handled) != 1 || handled[0].JobID != "job-2" {
+		t.Fatalf("handled %+v, want only job-2", handled)
+	}
+}
+
+func TestValidationErrorsSkipTheRetryTopics(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.Version = "2.1.0"
+	kc.config.DeadLetterTopic = "dead"
+	kc.config.RetryTopics = true
+	kc.config.MaxRetryRounds = 3
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	calls := 0
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "print_jobs", Offset: 1}, func(context.Context, Message) error {
+		calls++
+		return &ValidationError{Errors: []string{"copies must be at least 1"}}
+	})
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+	if msgs := producer.published(); len(msgs) != 1 || msgs[0].topic != "dead" {
+		t.Fatalf("published %+v, want a dead letter rather than a retry", msgs)
+	}
+}
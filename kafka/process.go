@@ -0,0 +1,227 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Policies for Config.OnPermanentError
+const (
+	// PermanentErrorSkip commits the failed message and moves on
+	PermanentErrorSkip = "skip"
+	// PermanentErrorBlock stops processing the partition of the failed
+	// message. Its offset is not committed, so the message is delivered
+	// again after a restart or rebalance, until it has failed more than
+	// Config.PoisonThreshold times.
+	PermanentErrorBlock = "block"
+	// PermanentErrorCrash exits the process so an operator can intervene
+	PermanentErrorCrash = "crash"
+)
+
+// Handler : Processes a single consumed message
+type Handler func(ctx context.Context, msg Message) error
+
+// Process : Runs the handler for a consumed message and commits its offset
+// once handled. The handler is retried up to Config.MaxRetries times, after
+// which the error is treated as permanent and resolved according to
+// Config.OnPermanentError. A *ValidationError is permanent at once: a
+// malformed message fails the same way every time, so it is neither
+// retried nor sent to the retry topics.
+func (kc *Client) Process(ctx context.Context, msg *sarama.ConsumerMessage, handler Handler) {
+	kc.process(ctx, msg, handler, kc.markOffset)
+}
+
+// Whether a handler error can't be fixed by handling the message again
+func isPermanent(err error) bool {
+	_, ok := err.(*ValidationError)
+	return ok
+}
+
+// Process with a custom way of marking a message as processed, for
+// consumers that don't go through the consumer group
+func (kc *Client) process(ctx context.Context, msg *sarama.ConsumerMessage, handler Handler, markOffset func(*sarama.ConsumerMessage)) {
+	kc.handlerStarted()
+	defer kc.handlerFinished()
+
+	if kc.isBlocked(msg.Topic, msg.Partition) {
+		return
+	}
+
+	if kc.skipDuplicate(msg) {
+		kc.logger().Info("skipping duplicate message", messageFields(msg, nil))
+		markOffset(msg)
+		return
+	}
+
+	if kc.skipExpired(msg, markOffset) {
+		return
+	}
+
+	if !kc.checkSchemaVersion(msg, markOffset) {
+		return
+	}
+
+	value, ok := kc.decrypt(msg, markOffset)
+	if !ok {
+		return
+	}
+	value, ok = kc.decode(msg, value, markOffset)
+	if !ok {
+		return
+	}
+	if !kc.validateSchema(msg, value, markOffset) {
+		return
+	}
+
+	message := newMessage(msg)
+	message.Value = string(value)
+	message.codec = kc.codec(message.contentType)
+	message.logicalTopic, _ = kc.config.logicalTopic(msg.Topic)
+	message.ack = func() { markOffset(msg) }
+	message.log = kc.logger()
+	kc.logReceipt(msg, message.Metadata.ReceivedAt)
+	messagesConsumed.WithLabelValues(msg.Topic, kc.keyLabel(msg.Key)).Inc()
+
+	handler = kc.wrap(handler)
+	spanCtx, endSpan := kc.startConsumeSpan(ctx, message)
+	start := time.Now()
+	err := handler(spanCtx, message)
+	retries := 0
+	for ; err != nil && !isPermanent(err) && retries < kc.config.MaxRetries && kc.waitRetry(ctx, retries); retries++ {
+		err = handler(spanCtx, message)
+	}
+	elapsed := time.Since(start)
+	endSpan(err)
+	if err != nil && ctx.Err() != nil {
+		// Failed because consumption stopped, e.g. on SIGTERM, not because
+		// of the message: left uncommitted to be delivered again
+		kc.logger().Info("consumption stopped while handling message, leaving it for redelivery", messageFields(msg, err))
+		return
+	}
+	kc.latency.Update(int64(elapsed))
+	kc.emitProcessed(msg, elapsed, err)
+	if err == nil {
+		kc.rememberHandled(msg)
+		kc.clearFailures(msg)
+		if !kc.config.ManualAck {
+			markOffset(msg)
+		}
+		return
+	}
+
+	if kc.config.OnPermanentError != PermanentErrorSkip && kc.isPoison(msg, retries+1) {
+		kc.skipPoison(msg, err, retries, markOffset)
+		return
+	}
+
+	switch kc.config.OnPermanentError {
+	case PermanentErrorBlock:
+		kc.logger().Error("failed to process message, blocking partition", messageFields(msg, err))
+		kc.block(msg.Topic, msg.Partition)
+	case PermanentErrorCrash:
+		fatal(kc.logger(), "failed to process message", messageFields(msg, err))
+	default:
+		if round := kc.retryRound(msg); kc.config.RetryTopics && !isPermanent(err) && round < kc.config.retryRounds() {
+			fields := messageFields(msg, err)
+			fields["retry_delay"] = kc.config.retryTier(round + 1).delay.String()
+			kc.logger().Warn("failed to process message, retrying", fields)
+			if retryErr := kc.scheduleRetry(msg, round+1); retryErr != nil {
+				kc.logger().Error("failed to schedule retry of message", messageFields(msg, retryErr))
+				return
+			}
+			markOffset(msg)
+			return
+		}
+
+		kc.logger().Error("failed to process message, skipping", messageFields(msg, err))
+		if kc.config.deadLettering() {
+			if dltErr := kc.deadLetter(msg, err, retries); dltErr != nil {
+				kc.logger().Error("failed to dead-letter message", messageFields(msg, dltErr))
+				return
+			}
+		}
+		markOffset(msg)
+	}
+}
+
+// Wait before the given retry, counted from 0, of a failed handler: the
+// backoff doubles on every retry, up to Config.RetryBackoffMax. False when
+// ctx is done first, as consumption stopped.
+func (kc *Client) waitRetry(ctx context.Context, retry int) bool {
+	backoff := kc.config.RetryBackoff
+	for i := 0; i < retry && backoff < kc.config.RetryBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > kc.config.RetryBackoffMax {
+		backoff = kc.config.RetryBackoffMax
+	}
+	if backoff <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func newMessage(msg *sarama.ConsumerMessage) Message {
+	message := Message{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Topic:     msg.Topic,
+		Value:     string(msg.Value),
+		Metadata: messageMetadata{
+			ReceivedAt: time.Now(),
+		},
+		tombstone: msg.Value == nil,
+	}
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		if message.Headers == nil {
+			message.Headers = make(map[string][]byte, len(msg.Headers))
+		}
+		message.Headers[string(h.Key)] = h.Value
+		if string(h.Key) == contentTypeHeader {
+			message.contentType = string(h.Value)
+		}
+	}
+	return message
+}
+
+func (kc *Client) block(topic string, partition int32) {
+	kc.blockedMu.Lock()
+	defer kc.blockedMu.Unlock()
+
+	if kc.blocked == nil {
+		kc.blocked = make(map[string]map[int32]bool)
+	}
+	if kc.blocked[topic] == nil {
+		kc.blocked[topic] = make(map[int32]bool)
+	}
+	kc.blocked[topic][partition] = true
+}
+
+func (kc *Client) isBlocked(topic string, partition int32) bool {
+	kc.blockedMu.Lock()
+	defer kc.blockedMu.Unlock()
+
+	return kc.blocked[topic][partition]
+}
+
+// Mark the message as processed so its offset is committed on the next
+// commit interval
+func (kc *Client) markOffset(msg *sarama.ConsumerMessage) {
+	kc.consumerMu.RLock()
+	defer kc.consumerMu.RUnlock()
+
+	kc.Consumer.MarkOffset(msg, "")
+}
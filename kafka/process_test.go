@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+var errHandler = errors.New("printer offline")
+
+// A handler failing every call, counting the calls
+func failingHandler(calls *int) Handler {
+	return func(context.Context, Message) error {
+		*calls++
+		return errHandler
+	}
+}
+
+func TestPermanentErrorSkipCommitsAfterTheRetries(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.MaxRetries = 2
+
+	calls := 0
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "orders", Offset: 7}, failingHandler(&calls))
+
+	if calls != 3 {
+		t.Fatalf("handler called %d times, want 3", calls)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{7}) {
+		t.Fatalf("marked %v, want [7]", marked)
+	}
+}
+
+func TestPermanentErrorBlockStopsThePartition(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.OnPermanentError = PermanentErrorBlock
+
+	calls := 0
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 7}, failingHandler(&calls))
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 8}, failingHandler(&calls))
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "orders", Partition: 2, Offset: 3}, func(context.Context, Message) error {
+		return nil
+	})
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times on the blocked partition, want 1", calls)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{3}) {
+		t.Fatalf("marked %v, want only the other partition's [3]", marked)
+	}
+}
+
+func TestPermanentErrorCrashExits(t *testing.T) {
+	if os.Getenv("KAFKA_TEST_CRASH") == "1" {
+		kc := newTestClient(newMockConsumer())
+		kc.config.OnPermanentError = PermanentErrorCrash
+		calls := 0
+		kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "orders"}, failingHandler(&calls))
+		return
+	}
+
+	// The process exits, so the policy runs in a child test process
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPermanentErrorCrashExits$")
+	cmd.Env = append(os.Environ(), "KAFKA_TEST_CRASH=1")
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.Success() {
+		t.Fatalf("child returned %v, want a non-zero exit", err)
+	}
+}
+
+func TestRetriesBackOff(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.MaxRetries = 2
+	kc.config.RetryBackoff = 20 * time.Millisecond
+	kc.config.RetryBackoffMax = 30 * time.Millisecond
+
+	calls := 0
+	start := time.Now()
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "orders"}, failingHandler(&calls))
+
+	// 20ms then 30ms rather than 40ms, capped by the maximum
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("retries took %s, want about 50ms", elapsed)
+	}
+	if calls != 3 {
+		t.Fatalf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestCancellingCtxStopsTheBackoff(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.MaxRetries = 5
+	kc.config.RetryBackoff = time.Minute
+	kc.config.RetryBackoffMax = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	calls := 0
+	start := time.Now()
+	kc.Process(ctx, &sarama.ConsumerMessage{Topic: "orders"}, failingHandler(&calls))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Process returned %s after cancellation", elapsed)
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+	// Left for redelivery rather than skipped
+	if marked := consumer.markedOffsets(); len(marked) != 0 {
+		t.Fatalf("marked %v after cancellation", marked)
+	}
+}
+
+func TestPoisonCountsRetriesWithinADelivery(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.OnPermanentError = PermanentErrorBlock
+	kc.config.MaxRetries = 2
+	kc.config.PoisonThreshold = 2
+
+	calls := 0
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 7}, failingHandler(&calls))
+
+	if calls != 3 {
+		t.Fatalf("handler called %d times, want the attempt and 2 retries", calls)
+	}
+	if kc.isBlocked("orders", 1) {
+		t.Fatal("partition blocked by a message that failed more often than the threshold")
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{7}) {
+		t.Fatalf("marked %v, want the poison message skipped", marked)
+	}
+}
+
+func TestPoisonCountsAttemptsAcrossDeliveries(t *testing.T) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.OnPermanentError = PermanentErrorBlock
+	kc.config.MaxRetries = 1
+	kc.config.PoisonThreshold = 3
+	msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 7}
+
+	calls := 0
+	kc.Process(context.Background(), msg, failingHandler(&calls))
+	if !kc.isBlocked("orders", 1) || len(consumer.markedOffsets()) != 0 {
+		t.Fatal("message skipped after 2 attempts, under the threshold of 3")
+	}
+
+	// Delivered again after a rebalance
+	kc.unblockAll()
+	kc.Process(context.Background(), msg, failingHandler(&calls))
+	if kc.isBlocked("orders", 1) {
+		t.Fatal("partition blocked again after 4 attempts")
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{7}) {
+		t.Fatalf("marked %v, want the poison message skipped", marked)
+	}
+}
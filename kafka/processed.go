@@ -0,0 +1,68 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Events Processed buffers before dropping them
+const processedBufferSize = 256
+
+var processedDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "processed_events_dropped_total",
+	Help:      "Processed events dropped because the Processed channel was full.",
+})
+
+func init() {
+	prometheus.MustRegister(processedDropped)
+}
+
+// ProcessedEvent : Outcome of handling a message, once its retries are
+// exhausted. Err is the handler's last error, nil on success.
+type ProcessedEvent struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Duration  time.Duration
+	Err       error
+}
+
+// Processed : Channel receiving an event after every message went through
+// the handler, or a batch handler, for supervisors and tests observing
+// processing without instrumenting the handler. Messages skipped before
+// reaching the handler, e.g. duplicates, have no event. Events are only
+// sent once Processed was called, and are dropped and counted in
+// kafka_processed_events_dropped_total while the channel is full.
+func (kc *Client) Processed() <-chan ProcessedEvent {
+	kc.processedMu.Lock()
+	defer kc.processedMu.Unlock()
+	if kc.processed == nil {
+		kc.processed = make(chan ProcessedEvent, processedBufferSize)
+	}
+	return kc.processed
+}
+
+// Record the outcome of a handled message in the metrics, and send it to
+// the Processed channel if there is one
+func (kc *Client) emitProcessed(msg *sarama.ConsumerMessage, d time.Duration, err error) {
+	messageDuration.WithLabelValues(msg.Topic).Observe(d.Seconds())
+	if err != nil {
+		messagesFailed.WithLabelValues(msg.Topic).Inc()
+	}
+
+	kc.processedMu.Lock()
+	ch := kc.processed
+	kc.processedMu.Unlock()
+	if ch == nil {
+		return
+	}
+
+	select {
+	case ch <- ProcessedEvent{msg.Topic, msg.Partition, msg.Offset, d, err}:
+	default:
+		processedDropped.Inc()
+	}
+}
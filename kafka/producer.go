@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// Producer : What the client publishes through. Connect uses a
+// SaramaProducer, tests can substitute a fake with SetProducer.
+type Producer interface {
+	// Publish without waiting for the broker to acknowledge the message
+	Publish(topic string, value []byte, opts PublishOptions) error
+	// ProduceSync waits for the acknowledgement and returns the partition
+	// and offset the message was written to
+	ProduceSync(topic string, value []byte) (int32, int64, error)
+	Close() error
+}
+
+// SaramaProducer : Producer backed by sarama's async and sync producers
+type SaramaProducer struct {
+	Async sarama.AsyncProducer
+	Sync  sarama.SyncProducer
+	// Kafka version of the cluster, deciding which message fields can be
+	// set
+	Version sarama.KafkaVersion
+
+	// Closed by the client's Shutdown, see Publish
+	closing <-chan struct{}
+}
+
+// Publish : Implements Producer. Delivery errors are reported on the async
+// producer's errors channel. Blocks while the async producer is full, until
+// the client that connected it shuts down, then returns ErrProducerClosed.
+func (p *SaramaProducer) Publish(topic string, value []byte, opts PublishOptions) error {
+	msg, err := producerMessage(p.Version, topic, value, opts)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case p.Async.Input() <- msg:
+		return nil
+	case <-p.closing:
+		return ErrProducerClosed
+	}
+}
+
+// TryPublish : Like Publish, but returns ErrProducerBusy instead of
+// blocking when the async producer can't take the message right away
+func (p *SaramaProducer) TryPublish(topic string, value []byte, opts PublishOptions) error {
+	msg, err := producerMessage(p.Version, topic, value, opts)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case p.Async.Input() <- msg:
+		return nil
+	default:
+		return ErrProducerBusy
+	}
+}
+
+// PublishSync : Like Publish, but waits for the broker to acknowledge the
+// message and returns the partition and offset it was written to
+func (p *SaramaProducer) PublishSync(topic string, value []byte, opts PublishOptions) (int32, int64, error) {
+	msg, err := producerMessage(p.Version, topic, value, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	return p.Sync.SendMessage(msg)
+}
+
+// ProduceSync : Implements Producer
+func (p *SaramaProducer) ProduceSync(topic string, value []byte) (int32, int64, error) {
+	return p.Sync.SendMessage(&sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	})
+}
+
+// Close : Implements Producer, flushing any buffered messages first
+func (p *SaramaProducer) Close() error {
+	var errs []string
+	if err := p.Async.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("producer: %v", err))
+	}
+	if err := p.Sync.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("sync producer: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// SetProducer : Replaces the producer used by PublishWithOptions,
+// TryPublish and ProduceSync, e.g. with a fake in tests. Call before
+// Connect, which then leaves it in place. Dead letters and retries are
+// published through it too. The sarama producers in Client.Producer and
+// Client.SyncProducer are still created.
+func (kc *Client) SetProducer(p Producer) {
+	kc.producer = p
+}
@@ -0,0 +1,118 @@
+package kafka
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Classes of publish errors, as counted in kafka_producer_errors_total
+const (
+	publishErrorRetriable = "retriable"
+	publishErrorFatal     = "fatal"
+)
+
+var producerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "producer_errors_total",
+	Help:      "Messages that could not be published, by topic and whether publishing them again may succeed.",
+}, []string{"topic", "class"})
+
+func init() {
+	prometheus.MustRegister(producerErrors)
+}
+
+// Broker errors that are expected to clear up on their own, e.g. while a
+// leader is being elected
+var retriableErrors = map[sarama.KError]bool{
+	sarama.ErrUnknownTopicOrPartition:      true,
+	sarama.ErrLeaderNotAvailable:           true,
+	sarama.ErrNotLeaderForPartition:        true,
+	sarama.ErrRequestTimedOut:              true,
+	sarama.ErrBrokerNotAvailable:           true,
+	sarama.ErrReplicaNotAvailable:          true,
+	sarama.ErrNetworkException:             true,
+	sarama.ErrNotEnoughReplicas:            true,
+	sarama.ErrNotEnoughReplicasAfterAppend: true,
+	sarama.ErrKafkaStorageError:            true,
+}
+
+// PublishError : A message the async producer gave up on after its own
+// retries
+type PublishError struct {
+	Topic string
+	Key   []byte
+	Value []byte
+	// PublishOptions.Metadata of the message
+	Metadata interface{}
+	Err      error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("kafka: publishing to %s: %v", e.Topic, e.Err)
+}
+
+// IsRetriable : Reports whether publishing the message again may succeed,
+// e.g. after a leader election, as opposed to errors such as a message
+// being too large that will fail every time
+func IsRetriable(err error) bool {
+	switch e := err.(type) {
+	case *PublishError:
+		return IsRetriable(e.Err)
+	case *sarama.ProducerError:
+		return IsRetriable(e.Err)
+	case sarama.ProducerErrors:
+		return len(e) > 0 && IsRetriable(e[0])
+	case sarama.KError:
+		return retriableErrors[e]
+	case sarama.ConfigurationError, sarama.PacketEncodingError:
+		return false
+	}
+	// Connection errors
+	return true
+}
+
+// OnPublishFailed : Registers a function called with every message the
+// async producer failed to deliver for good, i.e. not IsRetriable. Set
+// PublishOptions.Metadata to tell the handler the message came from. The
+// function runs on the errors goroutine and must not block. Register hooks
+// before calling Consume.
+func (kc *Client) OnPublishFailed(fn func(*PublishError)) {
+	kc.publishFailedHooks = append(kc.publishFailedHooks, fn)
+}
+
+// Count and log a failed publish, notifying the hooks when it is fatal
+func (kc *Client) handleProducerError(perr *sarama.ProducerError) {
+	class := publishErrorFatal
+	if IsRetriable(perr.Err) {
+		class = publishErrorRetriable
+	}
+	producerErrors.WithLabelValues(perr.Msg.Topic, class).Inc()
+	kc.logger().Error("failed to publish", Fields{"topic": perr.Msg.Topic, "class": class, "error": perr.Err})
+	kc.recordDelivery(perr.Msg, perr.Err)
+
+	if class != publishErrorFatal || len(kc.publishFailedHooks) == 0 {
+		return
+	}
+
+	e := &PublishError{Topic: perr.Msg.Topic, Metadata: perr.Msg.Metadata, Err: perr.Err}
+	if perr.Msg.Key != nil {
+		e.Key, _ = perr.Msg.Key.Encode()
+	}
+	if perr.Msg.Value != nil {
+		e.Value, _ = perr.Msg.Value.Encode()
+	}
+	for _, fn := range kc.publishFailedHooks {
+		fn(e)
+	}
+}
+
+// Count a failed synchronous publish, whose caller gets the error itself
+func countSyncProducerError(topic string, err error) {
+	class := publishErrorFatal
+	if IsRetriable(err) {
+		class = publishErrorRetriable
+	}
+	producerErrors.WithLabelValues(topic, class).Inc()
+}
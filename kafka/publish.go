@@ -0,0 +1,324 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrProducerBusy is returned by TryPublish when the producer's input
+// queue is full
+var ErrProducerBusy = errors.New("kafka: producer queue is full")
+
+var producerQueueFull = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "producer_queue_full_total",
+	Help:      "Publishes rejected because the producer queue was full.",
+})
+
+var messagesDelivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "messages_delivered_total",
+	Help:      "Messages the async producer got acknowledged, with KAFKA_TRACK_SUCCESSES.",
+}, []string{"topic"})
+
+var messagesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "messages_published_total",
+	Help:      "Messages taken by the producers, acknowledged or not.",
+}, []string{"topic"})
+
+func init() {
+	prometheus.MustRegister(producerQueueFull, messagesDelivered, messagesPublished)
+}
+
+// PublishOptions : Optional settings for a published message
+type PublishOptions struct {
+	// Event time of the message, defaults to now
+	Timestamp time.Time
+	Key       []byte
+	Headers   map[string]string
+	// Headers with binary values, e.g. the Headers of a consumed message
+	// to carry them over. Headers wins for a key set in both.
+	RawHeaders map[string][]byte
+	// Sent in the content-type header, and picks the codec of PublishValue
+	ContentType string
+	// Not sent, handed back in the PublishError of a failed delivery
+	Metadata interface{}
+	// Context the message is published in, whose span becomes the parent
+	// of the producer span with WithSpanHooks
+	Context context.Context
+}
+
+// PublishWithOptions : Publishes a message through the async producer to
+// a topic given by its name without prefix, e.g. "order_events", which
+// gets the prefix consumers of the same environment read it under.
+// Delivery errors are reported on the producer's errors channel. Explicit
+// timestamps require KAFKA_VERSION >= 0.10.0 and headers >= 0.11.0.
+// Without a key, the key is derived with the function set by SetKeyFunc.
+// Values published to Config.EncryptedTopics are encrypted.
+func (kc *Client) PublishWithOptions(topic string, value []byte, opts PublishOptions) error {
+	kc.producerMu.RLock()
+	defer kc.producerMu.RUnlock()
+	if kc.producerClosed {
+		return ErrProducerClosed
+	}
+	topic = kc.config.topic(topic)
+
+	opts, err := kc.withKey(value, opts)
+	if err != nil {
+		return err
+	}
+	value, opts, err = kc.encrypt(topic, value, opts)
+	if err != nil {
+		return err
+	}
+	opts, endSpan := kc.startPublishSpan(opts.Context, topic, opts, true)
+	published := kc.trackPublish()
+	err = kc.producer.Publish(topic, value, opts)
+	published()
+	endSpan(err)
+	countPublished(topic, err)
+	return err
+}
+
+// TryPublish : Like PublishWithOptions, but returns ErrProducerBusy instead
+// of blocking when the producer can't take the message right away, e.g.
+// because brokers are slow. Lets a consume-then-produce pipeline apply
+// backpressure rather than block a handler indefinitely. Producers set
+// with SetProducer that have no TryPublish method of their own are
+// published to as with PublishWithOptions.
+func (kc *Client) TryPublish(topic string, value []byte, opts PublishOptions) error {
+	kc.producerMu.RLock()
+	defer kc.producerMu.RUnlock()
+	if kc.producerClosed {
+		return ErrProducerClosed
+	}
+	topic = kc.config.topic(topic)
+
+	opts, err := kc.withKey(value, opts)
+	if err != nil {
+		return err
+	}
+	value, opts, err = kc.encrypt(topic, value, opts)
+	if err != nil {
+		return err
+	}
+	opts, endSpan := kc.startPublishSpan(opts.Context, topic, opts, true)
+
+	p, ok := kc.producer.(interface {
+		TryPublish(topic string, value []byte, opts PublishOptions) error
+	})
+	if !ok {
+		published := kc.trackPublish()
+		err = kc.producer.Publish(topic, value, opts)
+		published()
+		endSpan(err)
+		countPublished(topic, err)
+		return err
+	}
+
+	err = p.TryPublish(topic, value, opts)
+	endSpan(err)
+	if err == ErrProducerBusy {
+		producerQueueFull.Inc()
+	}
+	countPublished(topic, err)
+	return err
+}
+
+func countPublished(topic string, err error) {
+	if err == nil {
+		messagesPublished.WithLabelValues(topic).Inc()
+	}
+}
+
+// Publish : Publishes a message through the sync producer to a topic
+// given by its name without prefix, waiting for the broker to acknowledge
+// it, and returns the partition and offset it was written to. Without a
+// key, the key is derived with the function set by SetKeyFunc, and values
+// published to Config.EncryptedTopics are encrypted. When ctx is done
+// first its error is returned, but the message may still be written.
+// Producers set with SetProducer that have no PublishSync method of their
+// own can only publish messages without key or headers.
+func (kc *Client) Publish(ctx context.Context, topic string, key, value []byte) (int32, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	type result struct {
+		partition int32
+		offset    int64
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		partition, offset, err := kc.publishSync(ctx, topic, key, value)
+		done <- result{partition, offset, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.partition, r.offset, r.err
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	}
+}
+
+func (kc *Client) publishSync(ctx context.Context, topic string, key, value []byte) (int32, int64, error) {
+	kc.producerMu.RLock()
+	defer kc.producerMu.RUnlock()
+	if kc.producerClosed {
+		return 0, 0, ErrProducerClosed
+	}
+	topic = kc.config.topic(topic)
+
+	opts, err := kc.withKey(value, PublishOptions{Key: key})
+	if err != nil {
+		return 0, 0, err
+	}
+	value, opts, err = kc.encrypt(topic, value, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var partition int32
+	var offset int64
+	p, ok := kc.producer.(interface {
+		PublishSync(topic string, value []byte, opts PublishOptions) (int32, int64, error)
+	})
+	// ProduceSync can't carry the span context
+	opts, endSpan := kc.startPublishSpan(ctx, topic, opts, ok)
+	defer func() { endSpan(err) }()
+	defer kc.trackPublish()()
+	switch {
+	case ok:
+		partition, offset, err = p.PublishSync(topic, value, opts)
+	case opts.Key == nil && !opts.hasHeaders():
+		partition, offset, err = kc.producer.ProduceSync(topic, value)
+	default:
+		err = errors.New("kafka: producer can't publish keys or headers synchronously")
+		return 0, 0, err
+	}
+	if err != nil {
+		countSyncProducerError(topic, err)
+	}
+	countPublished(topic, err)
+	return partition, offset, err
+}
+
+// Publish a message whose source offset is committed once this returns
+// nil, a dead letter or a retry, to a topic given by its full name. It goes
+// through the same producer as PublishWithOptions, so Config.EnableSpool
+// and SetProducer apply. The spool writes it right away or else spools it,
+// other producers wait for the broker to acknowledge it, unless they have
+// no PublishSync method.
+func (kc *Client) publishDurably(topic string, value []byte, opts PublishOptions) error {
+	kc.producerMu.RLock()
+	defer kc.producerMu.RUnlock()
+	if kc.producerClosed {
+		return ErrProducerClosed
+	}
+	defer kc.trackPublish()()
+
+	var err error
+	switch p := kc.producer.(type) {
+	case *SpoolingProducer:
+		err = p.Publish(topic, value, opts)
+	case interface {
+		PublishSync(topic string, value []byte, opts PublishOptions) (int32, int64, error)
+	}:
+		_, _, err = p.PublishSync(topic, value, opts)
+	default:
+		err = kc.producer.Publish(topic, value, opts)
+	}
+	countPublished(topic, err)
+	return err
+}
+
+// SetKeyFunc : Sets the function deriving the key of published messages
+// from their value when no key is given, so related events land on the same
+// partition without every caller extracting the key itself. See JSONKey.
+func (kc *Client) SetKeyFunc(fn func(value []byte) ([]byte, error)) {
+	kc.keyFunc = fn
+}
+
+// JSONKey : Key function using the value of a top-level field of JSON
+// payloads, e.g. "order_id". Strings are used as is, other values in their
+// JSON encoding.
+func JSONKey(field string) func(value []byte) ([]byte, error) {
+	return func(value []byte) ([]byte, error) {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return nil, err
+		}
+
+		raw, ok := doc[field]
+		if !ok {
+			return nil, fmt.Errorf("field %q not found", field)
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return []byte(s), nil
+		}
+		return raw, nil
+	}
+}
+
+func (kc *Client) withKey(value []byte, opts PublishOptions) (PublishOptions, error) {
+	if opts.Key != nil || kc.keyFunc == nil {
+		return opts, nil
+	}
+
+	key, err := kc.keyFunc(value)
+	if err != nil {
+		return opts, fmt.Errorf("kafka: deriving message key: %v", err)
+	}
+	opts.Key = key
+	return opts, nil
+}
+
+func (o PublishOptions) hasHeaders() bool {
+	return len(o.Headers) > 0 || len(o.RawHeaders) > 0
+}
+
+func producerMessage(version sarama.KafkaVersion, topic string, value []byte, opts PublishOptions) (*sarama.ProducerMessage, error) {
+	if !opts.Timestamp.IsZero() && !version.IsAtLeast(sarama.V0_10_0_0) {
+		return nil, errors.New("kafka: message timestamps require KAFKA_VERSION >= 0.10.0")
+	}
+	if (opts.hasHeaders() || opts.ContentType != "") && !version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.New("kafka: message headers require KAFKA_VERSION >= 0.11.0")
+	}
+
+	timestamp := opts.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:     topic,
+		Value:     sarama.ByteEncoder(value),
+		Timestamp: timestamp,
+		Metadata:  opts.Metadata,
+	}
+	if opts.Key != nil {
+		msg.Key = sarama.ByteEncoder(opts.Key)
+	}
+	for k, v := range opts.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	for k, v := range opts.RawHeaders {
+		if _, ok := opts.Headers[k]; !ok {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: v})
+		}
+	}
+	if opts.ContentType != "" {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(contentTypeHeader), Value: []byte(opts.ContentType)})
+	}
+	return msg, nil
+}
@@ -0,0 +1,48 @@
+package kafka
+
+// OnPartitionsRevoked : Registers a function called with the partitions
+// taken away from this consumer when a rebalance starts, so handlers can
+// flush state (e.g. commit an external offset store) before the partitions
+// move. Register hooks before calling Consume.
+//
+// Delivery stays at-least-once across a revocation: messages that were
+// handled but whose offsets were not committed yet will be delivered again
+// to whichever consumer is assigned the partition next.
+func (kc *Client) OnPartitionsRevoked(fn func(topic string, partitions []int32)) {
+	kc.revokedHooks = append(kc.revokedHooks, fn)
+}
+
+func (kc *Client) handleRebalance(n *Notification) {
+	switch n.Type {
+	case RebalanceStart:
+		kc.setGroupState(groupRebalancing)
+	case RebalanceOK:
+		rebalances.WithLabelValues("ok").Inc()
+		kc.setGroupState(groupMember)
+	case RebalanceError:
+		rebalances.WithLabelValues("error").Inc()
+		kc.setGroupState(groupError)
+	}
+
+	// The new generation starts from the committed offsets
+	if n.Type == RebalanceOK {
+		kc.resume()
+		kc.unblockAll()
+		kc.coordinatorRecovered()
+	}
+
+	// Both consumer groups rebalance eagerly: every partition currently held
+	// is released when a rebalance starts, and may be claimed again after
+	if n.Type != RebalanceStart {
+		return
+	}
+
+	for topic, partitions := range n.Current {
+		if len(partitions) == 0 {
+			continue
+		}
+		for _, fn := range kc.revokedHooks {
+			fn(topic, partitions)
+		}
+	}
+}
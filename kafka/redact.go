@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// SetRedactor : Sets the function applied to message payloads before they
+// are logged. The default leaves payloads untouched, unless
+// Config.RedactFields is set. Call before Consume.
+func (kc *Client) SetRedactor(fn func([]byte) []byte) {
+	kc.redactor = fn
+}
+
+func (kc *Client) redact(payload []byte) []byte {
+	if kc.redactor == nil {
+		return payload
+	}
+	return kc.redactor(payload)
+}
+
+// JSONFieldRedactor : Redactor that blanks the values of the given keys,
+// at any depth, in JSON payloads. Payloads that aren't valid JSON are
+// redacted entirely since they can't be inspected.
+func JSONFieldRedactor(fields []string) func([]byte) []byte {
+	keys := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		keys[f] = true
+	}
+
+	return func(payload []byte) []byte {
+		var value interface{}
+		if err := json.Unmarshal(payload, &value); err != nil {
+			return []byte(redacted)
+		}
+
+		out, err := json.Marshal(redactValue(value, keys))
+		if err != nil {
+			return []byte(redacted)
+		}
+		return out
+	}
+}
+
+func redactValue(value interface{}, keys map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if keys[k] {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(child, keys)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child, keys)
+		}
+	}
+	return value
+}
+
+// CommaList : List of values decoded from a comma separated ENV value
+type CommaList []string
+
+// Decode : Implements envdecode.Decoder
+func (l *CommaList) Decode(value string) error {
+	var values CommaList
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	*l = values
+	return nil
+}
+
+func (l CommaList) contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
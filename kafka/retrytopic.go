@@ -0,0 +1,238 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Suffix of the topics failed messages are retried from
+const retryTopicSuffix = ".retry"
+
+// Headers of messages on a retry topic, prefixed with
+// Config.DLTHeaderPrefix
+const (
+	retryHeaderAfter = "retry-after"
+	retryHeaderRound = "retry-round"
+)
+
+// DurationList : List of durations decoded from a comma separated ENV
+// value, e.g. "1m,10m,1h"
+type DurationList []time.Duration
+
+// Decode : Implements envdecode.Decoder
+func (l *DurationList) Decode(value string) error {
+	var values DurationList
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		values = append(values, d)
+	}
+
+	*l = values
+	return nil
+}
+
+// A stage of the retry topics: the suffix of its topics and how long
+// messages wait on them
+type retryTier struct {
+	suffix string
+	delay  time.Duration
+}
+
+// The retry tiers, one per Config.RetryTiers, or a single <topic>.retry
+// tier of Config.RetryDelay
+func (kc *Config) retryTiers() []retryTier {
+	if len(kc.RetryTiers) == 0 {
+		return []retryTier{{retryTopicSuffix, kc.RetryDelay}}
+	}
+
+	tiers := make([]retryTier, len(kc.RetryTiers))
+	for i, d := range kc.RetryTiers {
+		tiers[i] = retryTier{retryTopicSuffix + "." + shortDuration(d), d}
+	}
+	return tiers
+}
+
+// The tier of the given round, counting from 1. Without Config.RetryTiers
+// every round goes through the single tier.
+func (kc *Config) retryTier(round int) retryTier {
+	tiers := kc.retryTiers()
+	if round > len(tiers) {
+		round = len(tiers)
+	}
+	return tiers[round-1]
+}
+
+// How many times a failed message goes through the retry topics before
+// it is dead-lettered
+func (kc *Config) retryRounds() int {
+	if len(kc.RetryTiers) > 0 {
+		return len(kc.RetryTiers)
+	}
+	return kc.MaxRetryRounds
+}
+
+// A duration as written in a topic name, e.g. 10m rather than 10m0s
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// Publish a failed message to the retry topic of the given round, to be
+// handled again once the delay of its tier has passed
+func (kc *Client) scheduleRetry(msg *sarama.ConsumerMessage, round int) error {
+	tier := kc.config.retryTier(round)
+	prefix := kc.config.DLTHeaderPrefix
+	headers := make(map[string][]byte, len(msg.Headers)+2)
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers[string(h.Key)] = h.Value
+		}
+	}
+	// Replaces those of the previous round
+	headers[prefix+retryHeaderAfter] = []byte(time.Now().Add(tier.delay).UTC().Format(time.RFC3339))
+	headers[prefix+retryHeaderRound] = []byte(strconv.Itoa(round))
+
+	return kc.publishDurably(msg.Topic+tier.suffix, msg.Value, PublishOptions{
+		Key:        msg.Key,
+		RawHeaders: headers,
+	})
+}
+
+// The number of times the message went through a retry topic
+func (kc *Client) retryRound(msg *sarama.ConsumerMessage) int {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == kc.config.DLTHeaderPrefix+retryHeaderRound {
+			round, _ := strconv.Atoi(string(h.Value))
+			return round
+		}
+	}
+	return 0
+}
+
+func (kc *Client) retryAfter(msg *sarama.ConsumerMessage) time.Time {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == kc.config.DLTHeaderPrefix+retryHeaderAfter {
+			after, _ := time.Parse(time.RFC3339, string(h.Value))
+			return after
+		}
+	}
+	return time.Time{}
+}
+
+// Start a consumer for the retry topics of every tier, each in its own
+// consumer group, e.g. <group>-retry-10m for the <topic>.retry.10m topics,
+// so messages waiting on a long tier don't hold up the shorter ones. The
+// consumers are created once per client and closed by Shutdown. While an
+// earlier consume call is still running they are left to it; once its ctx
+// is done, the next call reads them with its own handler.
+func (kc *Client) startRetryConsumer(ctx context.Context, handler Handler) error {
+	kc.retryMu.Lock()
+	defer kc.retryMu.Unlock()
+
+	if kc.retryCtx != nil && kc.retryCtx.Err() == nil {
+		return nil
+	}
+	// The loops of the previous call return as soon as its ctx is done
+	kc.retryLoops.Wait()
+
+	tiers := kc.config.retryTiers()
+	for _, tier := range tiers[len(kc.retryConsumers):] {
+		var topics []string
+		for _, t := range kc.subscriptions() {
+			topics = append(topics, t+tier.suffix)
+		}
+
+		group := kc.config.group() + strings.Replace(tier.suffix, ".", "-", -1)
+		consumer, err := kc.config.createKafkaConsumer(kc.brokers, kc.tlsConfig, group, topics)
+		if err != nil {
+			return err
+		}
+		kc.retryConsumers = append(kc.retryConsumers, consumer)
+	}
+
+	kc.retryCtx = ctx
+	for i, consumer := range kc.retryConsumers {
+		kc.retryLoops.Add(1)
+		go func(consumer GroupConsumer, suffix string) {
+			defer kc.retryLoops.Done()
+			kc.consumeRetries(ctx, consumer, handler, suffix)
+		}(consumer, tiers[i].suffix)
+	}
+	return nil
+}
+
+// Handle the messages of the retry topics of a tier one at a time, each
+// once its retry-after time has come; until then the tier's consumption is
+// paused. Messages are handled as if they came from their original topic,
+// so a message failing again goes on to the next tier, or to the
+// dead-letter topic after the last one.
+func (kc *Client) consumeRetries(ctx context.Context, consumer GroupConsumer, handler Handler, suffix string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-kc.done:
+			return
+		case msg, ok := <-consumer.Messages():
+			if !ok {
+				return
+			}
+			if !kc.waitForRetry(ctx, consumer, kc.retryAfter(msg)) {
+				return
+			}
+
+			original := *msg
+			original.Topic = strings.TrimSuffix(msg.Topic, suffix)
+			if !kc.startInflight() {
+				return
+			}
+			kc.process(ctx, &original, handler, func(*sarama.ConsumerMessage) {
+				consumer.MarkOffset(msg, "")
+			})
+			kc.inflight.Done()
+		case err := <-consumer.Errors():
+			if err != nil {
+				kc.logger().Error("retry consumer error", errorFields(err))
+			}
+		case <-consumer.Notifications():
+		}
+	}
+}
+
+// Wait until the given time, keeping the consumer's errors and
+// notifications drained so rebalances aren't held up. Reports false when
+// ctx was cancelled first.
+func (kc *Client) waitForRetry(ctx context.Context, consumer GroupConsumer, until time.Time) bool {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case err := <-consumer.Errors():
+			if err != nil {
+				kc.logger().Error("retry consumer error", errorFields(err))
+			}
+		case <-consumer.Notifications():
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// A client whose retry tiers are consumed from the given consumers, as
+// if an earlier consume call had created them
+func newRetryTestClient(consumers ...GroupConsumer) *Client {
+	kc := newTestClient(newMockConsumer())
+	kc.config.RetryTopics = true
+	kc.config.DLTHeaderPrefix = "x-"
+	kc.retryConsumers = consumers
+	for range consumers {
+		kc.config.RetryTiers = append(kc.config.RetryTiers, time.Minute*time.Duration(len(kc.config.RetryTiers)+1))
+	}
+	return kc
+}
+
+// Handler recording which of two consume calls handled each message
+type handlerLog struct {
+	mu      sync.Mutex
+	handled []string
+}
+
+func (l *handlerLog) handler(name string) Handler {
+	return func(context.Context, Message) error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.handled = append(l.handled, name)
+		return nil
+	}
+}
+
+func (l *handlerLog) wait(t *testing.T, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		handled := append([]string(nil), l.handled...)
+		l.mu.Unlock()
+		if len(handled) >= n {
+			return handled
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("handled fewer than %d retries", n)
+	return nil
+}
+
+func TestRetryConsumersAreStartedOncePerClient(t *testing.T) {
+	retries := newMockConsumer()
+	kc := newRetryTestClient(retries)
+	log := &handlerLog{}
+
+	first, stopFirst := context.WithCancel(context.Background())
+	defer stopFirst()
+	// No brokers: creating another consumer would fail
+	if err := kc.startRetryConsumer(first, log.handler("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kc.startRetryConsumer(context.Background(), log.handler("second")); err != nil {
+		t.Fatalf("second call created retry consumers again: %v", err)
+	}
+
+	for i := int64(1); i <= 10; i++ {
+		retries.messages <- &sarama.ConsumerMessage{Topic: "orders.retry.1m", Offset: i, Value: []byte("{}")}
+	}
+	for _, name := range log.wait(t, 10) {
+		if name != "first" {
+			t.Fatal("retries read by a second set of loops")
+		}
+	}
+	if len(kc.retryConsumers) != 1 {
+		t.Fatalf("%d retry consumers, want 1", len(kc.retryConsumers))
+	}
+}
+
+func TestRetryConsumersMoveToTheNextConsumeCall(t *testing.T) {
+	short, long := newMockConsumer(), newMockConsumer()
+	kc := newRetryTestClient(short, long)
+	log := &handlerLog{}
+
+	first, stopFirst := context.WithCancel(context.Background())
+	if err := kc.startRetryConsumer(first, log.handler("first")); err != nil {
+		t.Fatal(err)
+	}
+	stopFirst()
+
+	second, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	if err := kc.startRetryConsumer(second, log.handler("second")); err != nil {
+		t.Fatal(err)
+	}
+	short.messages <- &sarama.ConsumerMessage{Topic: "orders.retry.1m", Offset: 1, Value: []byte("{}")}
+	long.messages <- &sarama.ConsumerMessage{Topic: "orders.retry.2m", Offset: 1, Value: []byte("{}")}
+	for _, name := range log.wait(t, 2) {
+		if name != "second" {
+			t.Fatal("retry handled by a consume call that already returned")
+		}
+	}
+}
+
+func TestRetryTiersAreNamedAfterTheirDelay(t *testing.T) {
+	var tiers DurationList
+	if err := tiers.Decode("1m, 10m,1h,90s"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{RetryTiers: tiers}
+
+	var suffixes []string
+	for _, tier := range cfg.retryTiers() {
+		suffixes = append(suffixes, tier.suffix)
+	}
+	if want := []string{".retry.1m", ".retry.10m", ".retry.1h", ".retry.1m30s"}; !reflect.DeepEqual(suffixes, want) {
+		t.Fatalf("tiers %q, want %q", suffixes, want)
+	}
+	if cfg.retryRounds() != 4 {
+		t.Fatalf("%d rounds, want one per tier", cfg.retryRounds())
+	}
+	if err := tiers.Decode("1m,soon"); err == nil {
+		t.Fatal("invalid delay accepted")
+	}
+}
+
+func TestWithoutTiersEveryRoundGoesThroughTheRetryTopic(t *testing.T) {
+	cfg := &Config{RetryDelay: 5 * time.Minute, MaxRetryRounds: 3}
+	for round := 1; round <= 3; round++ {
+		if tier := cfg.retryTier(round); tier.suffix != ".retry" || tier.delay != 5*time.Minute {
+			t.Fatalf("round %d goes through %+v", round, tier)
+		}
+	}
+}
+
+// The message failing round times already, as delivered from its tier
+func retriedMessage(round int, topic string) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Topic: topic, Offset: 5, Key: []byte("job-1"), Value: []byte("{}")}
+	if round > 0 {
+		msg.Headers = []*sarama.RecordHeader{{Key: []byte("x-" + retryHeaderRound), Value: []byte(strconv.Itoa(round))}}
+	}
+	return msg
+}
+
+func TestFailedMessagesMoveThroughTheTiers(t *testing.T) {
+	tests := []struct {
+		round int
+		topic string
+	}{
+		{0, "orders.retry.1m"},
+		{1, "orders.retry.10m"},
+		{2, "orders.dead"},
+	}
+	for _, test := range tests {
+		kc := newRetryTestClient()
+		kc.config.RetryTiers = DurationList{time.Minute, 10 * time.Minute}
+		kc.config.DeadLetterTopic = "orders.dead"
+		producer := &recordingProducer{}
+		kc.SetProducer(producer)
+
+		calls := 0
+		kc.Process(context.Background(), retriedMessage(test.round, "orders"), failingHandler(&calls))
+
+		msgs := producer.published()
+		if len(msgs) != 1 || msgs[0].topic != test.topic {
+			t.Fatalf("after round %d published %+v, want %s", test.round, msgs, test.topic)
+		}
+		if test.topic == "orders.dead" {
+			continue
+		}
+		headers := msgs[0].opts.RawHeaders
+		if got := string(headers["x-"+retryHeaderRound]); got != strconv.Itoa(test.round+1) {
+			t.Fatalf("round header %q after round %d", got, test.round)
+		}
+		after, err := time.Parse(time.RFC3339, string(headers["x-"+retryHeaderAfter]))
+		delay := kc.config.retryTier(test.round + 1).delay
+		if err != nil || after.Before(time.Now().Add(delay-2*time.Second)) || after.After(time.Now().Add(delay+time.Second)) {
+			t.Fatalf("retry-after %q for a delay of %s", headers["x-"+retryHeaderAfter], delay)
+		}
+	}
+}
+
+func TestRetriesWaitForTheirTierDelay(t *testing.T) {
+	retries := newMockConsumer()
+	kc := newRetryTestClient(retries)
+
+	var mu sync.Mutex
+	var handledAt time.Time
+	var topic string
+	handled := make(chan struct{})
+	handler := func(_ context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handledAt, topic = time.Now(), msg.Topic
+		close(handled)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := kc.startRetryConsumer(ctx, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := retriedMessage(1, "orders.retry.1m")
+	after := time.Now().Add(1100 * time.Millisecond).Truncate(time.Second)
+	msg.Headers = append(msg.Headers, &sarama.RecordHeader{
+		Key: []byte("x-" + retryHeaderAfter), Value: []byte(after.UTC().Format(time.RFC3339)),
+	})
+	retries.messages <- msg
+
+	select {
+	case <-handled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("retry never handled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if handledAt.Before(after) {
+		t.Fatalf("handled at %s, before its retry-after of %s", handledAt, after)
+	}
+	if topic != "orders" {
+		t.Fatalf("handled as %s, want the original topic", topic)
+	}
+	if marked := retries.markedOffsets(); !reflect.DeepEqual(marked, []int64{5}) {
+		t.Fatalf("marked %v on the retry consumer, want [5]", marked)
+	}
+}
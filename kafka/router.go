@@ -0,0 +1,51 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+)
+
+type headerRoute struct {
+	key, value string
+	handler    Handler
+}
+
+// HandleHeader : Routes messages whose headerKey header is headerValue,
+// e.g. "event-type" "print_requested", to handler, for producers that put
+// many event types on one topic. Rules are tried in the order they were
+// added and the first match wins. Call before Consume; see Route.
+func (kc *Client) HandleHeader(headerKey, headerValue string, handler Handler) {
+	kc.headerRoutes = append(kc.headerRoutes, headerRoute{headerKey, headerValue, handler})
+}
+
+// HandleTopic : Routes messages of a topic, given by its name without
+// prefix, that no header rule matched to handler. Call before Consume; see
+// Route.
+func (kc *Client) HandleTopic(topic string, handler Handler) {
+	if kc.topicRoutes == nil {
+		kc.topicRoutes = make(map[string]Handler)
+	}
+	kc.topicRoutes[topic] = handler
+}
+
+// Route : Handler dispatching messages by the rules of HandleHeader, then
+// those of HandleTopic, and to fallback when none matches, to be given to
+// Consume. Without a fallback, unmatched messages fail like any other.
+func (kc *Client) Route(fallback Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		for _, r := range kc.headerRoutes {
+			if v, ok := msg.Header(r.key); ok && v == r.value {
+				return r.handler(ctx, msg)
+			}
+		}
+
+		if h := kc.topicRoutes[msg.LogicalTopic()]; h != nil {
+			return h(ctx, msg)
+		}
+
+		if fallback == nil {
+			return fmt.Errorf("kafka: no handler for message of %s", msg.Topic)
+		}
+		return fallback(ctx, msg)
+	}
+}
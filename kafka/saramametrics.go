@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// Exports every metric sarama records in Config.MetricRegistry under
+// kafka_sarama_, e.g. request-latency-in-ms as
+// kafka_sarama_request_latency_in_ms. Per-broker and per-topic metrics,
+// such as request-rate-for-broker-1, go to kafka_sarama_broker_ and
+// kafka_sarama_topic_ with the broker id or topic as a label, so they don't
+// clash with the totals. Meters are exported as counters with their -rate
+// suffix replaced by _total, counters (in-flight requests) and gauges as
+// gauges and histograms as summaries, as sarama keeps a sample of the
+// values rather than buckets. The set of metrics varies with the brokers
+// and topics in use, so the collector is unchecked.
+type saramaCollector struct {
+	mu       sync.Mutex
+	registry metrics.Registry
+}
+
+var saramaMetrics = &saramaCollector{}
+
+func init() {
+	prometheus.MustRegister(saramaMetrics)
+}
+
+func (c *saramaCollector) setRegistry(registry metrics.Registry) {
+	c.mu.Lock()
+	c.registry = registry
+	c.mu.Unlock()
+}
+
+// Describe : Implements prometheus.Collector
+func (c *saramaCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect : Implements prometheus.Collector
+func (c *saramaCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	registry := c.registry
+	c.mu.Unlock()
+	if registry == nil {
+		return
+	}
+
+	registry.Each(func(name string, metric interface{}) {
+		name, labels, values := saramaMetricName(name)
+		switch m := metric.(type) {
+		case metrics.Meter:
+			name = strings.TrimSuffix(name, "_rate") + "_total"
+			desc := prometheus.NewDesc(name, "sarama meter, see the sarama documentation.", labels, nil)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(m.Count()), values...)
+		case metrics.Counter:
+			desc := prometheus.NewDesc(name, "sarama counter, see the sarama documentation.", labels, nil)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(m.Count()), values...)
+		case metrics.Gauge:
+			desc := prometheus.NewDesc(name, "sarama gauge, see the sarama documentation.", labels, nil)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(m.Value()), values...)
+		case metrics.Histogram:
+			s := m.Snapshot()
+			ps := s.Percentiles([]float64{0.5, 0.95, 0.99})
+			desc := prometheus.NewDesc(name, "sarama histogram, see the sarama documentation.", labels, nil)
+			ch <- prometheus.MustNewConstSummary(desc, uint64(s.Count()), s.Mean()*float64(s.Count()), map[float64]float64{
+				0.5:  ps[0],
+				0.95: ps[1],
+				0.99: ps[2],
+			}, values...)
+		}
+	})
+}
+
+// The Prometheus name of a sarama metric, and the broker or topic label
+// split off its name
+func saramaMetricName(name string) (string, []string, []string) {
+	var labels, values []string
+	scope := ""
+	for _, s := range []struct{ suffix, scope string }{
+		{"-for-broker-", "broker"},
+		{"-for-topic-", "topic"},
+	} {
+		if i := strings.Index(name, s.suffix); i >= 0 {
+			labels = []string{s.scope}
+			values = []string{name[i+len(s.suffix):]}
+			scope = s.scope + "_"
+			name = name[:i]
+			break
+		}
+	}
+	return "kafka_sarama_" + scope + strings.Replace(name, "-", "_", -1), labels, values
+}
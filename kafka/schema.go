@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ValidationError : A message value that doesn't match Config.SchemaFile
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "schema validation failed: " + strings.Join(e.Errors, "; ")
+}
+
+// Compile the JSON schema at path
+func loadSchema(path string) (*gojsonschema.Schema, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(abs)))
+	if err != nil {
+		return nil, fmt.Errorf("kafka: schema %s: %v", path, err)
+	}
+	return schema, nil
+}
+
+// Validate the value of a message from one of Config.SchemaTopics against
+// the schema. Reports false when it doesn't match and the message must not
+// be handled, after having sent it to the dead-letter topic.
+func (kc *Client) validateSchema(msg *sarama.ConsumerMessage, value []byte, markOffset func(*sarama.ConsumerMessage)) bool {
+	if kc.schema == nil {
+		return true
+	}
+	name, _ := kc.config.logicalTopic(msg.Topic)
+	if !kc.config.SchemaTopics.contains(name) {
+		return true
+	}
+
+	var verr *ValidationError
+	result, err := kc.schema.Validate(gojsonschema.NewBytesLoader(value))
+	switch {
+	case err != nil:
+		// Not JSON at all
+		verr = &ValidationError{Errors: []string{err.Error()}}
+	case !result.Valid():
+		verr = &ValidationError{}
+		for _, e := range result.Errors() {
+			verr.Errors = append(verr.Errors, e.String())
+		}
+	default:
+		return true
+	}
+
+	kc.logger().Error("invalid message, dead-lettering", messageFields(msg, verr))
+	if err := kc.deadLetter(msg, verr, 0); err != nil {
+		kc.logger().Error("failed to dead-letter message, blocking partition", messageFields(msg, err))
+		kc.block(msg.Topic, msg.Partition)
+		return false
+	}
+	markOffset(msg)
+	return false
+}
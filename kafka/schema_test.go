@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// A client validating print_jobs against the test schema, dead-lettering
+// to "dead"
+func newSchemaTestClient(t *testing.T) (*Client, *mockConsumer, *recordingProducer) {
+	schema, err := loadSchema("testdata/print_job.schema.json")
+	if err != nil {
+		t.Fatalf("loadSchema returned %v", err)
+	}
+
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.schema = schema
+	kc.config.SchemaTopics = CommaList{"print_jobs"}
+	kc.config.DeadLetterTopic = "dead"
+	kc.config.DLTHeaderPrefix = "x-"
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+	return kc, consumer, producer
+}
+
+func TestValidPayloadIsHandled(t *testing.T) {
+	kc, consumer, producer := newSchemaTestClient(t)
+
+	handled := 0
+	msg := &sarama.ConsumerMessage{Topic: "print_jobs", Offset: 1, Value: []byte(`{"order_id":"o-1","printer":"kitchen","copies":2}`)}
+	kc.Process(context.Background(), msg, func(context.Context, Message) error {
+		handled++
+		return nil
+	})
+
+	if handled != 1 {
+		t.Fatalf("handler called %d times, want 1", handled)
+	}
+	if msgs := producer.published(); len(msgs) != 0 {
+		t.Fatalf("dead-lettered %+v", msgs)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1}) {
+		t.Fatalf("marked %v, want [1]", marked)
+	}
+}
+
+func TestInvalidPayloadIsDeadLettered(t *testing.T) {
+	for name, value := range map[string]string{
+		"missing field": `{"order_id":"o-1"}`,
+		"wrong type":    `{"order_id":"o-1","printer":"kitchen","copies":0}`,
+		"not JSON":      `order o-1`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			kc, consumer, producer := newSchemaTestClient(t)
+
+			msg := &sarama.ConsumerMessage{Topic: "print_jobs", Offset: 1, Value: []byte(value)}
+			kc.Process(context.Background(), msg, func(context.Context, Message) error {
+				t.Fatal("an invalid message was handed to the handler")
+				return nil
+			})
+
+			msgs := producer.published()
+			if len(msgs) != 1 || msgs[0].topic != "dead" {
+				t.Fatalf("published %+v, want a dead letter", msgs)
+			}
+			if errs := string(msgs[0].opts.RawHeaders["x-validation-errors"]); errs == "" {
+				t.Fatal("dead letter has no validation errors header")
+			}
+			if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1}) {
+				t.Fatalf("marked %v, want [1]", marked)
+			}
+		})
+	}
+}
+
+func TestTopicsOutsideSchemaTopicsAreNotValidated(t *testing.T) {
+	kc, _, producer := newSchemaTestClient(t)
+
+	handled := 0
+	kc.Process(context.Background(), &sarama.ConsumerMessage{Topic: "order_events", Value: []byte(`{}`)}, func(context.Context, Message) error {
+		handled++
+		return nil
+	})
+	if handled != 1 || len(producer.published()) != 0 {
+		t.Fatalf("handled %d, dead-lettered %d", handled, len(producer.published()))
+	}
+}
+
+func TestValidationErrorListsTheErrors(t *testing.T) {
+	err := &ValidationError{Errors: []string{"printer is required", "copies must be >= 1"}}
+	if !strings.Contains(err.Error(), "printer is required; copies must be >= 1") {
+		t.Fatalf("error is %q", err.Error())
+	}
+}
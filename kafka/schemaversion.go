@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Header carrying the version of the envelope a message value follows.
+// Messages without it are version 1.
+const schemaVersionHeader = "schema-version"
+
+var schemaVersionMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "schema_version_mismatches_total",
+	Help:      "Messages with a schema version newer than KAFKA_MAX_SCHEMA_VERSION, by topic and policy applied.",
+}, []string{"topic", "policy"})
+
+func init() {
+	prometheus.MustRegister(schemaVersionMismatches)
+}
+
+// The schema version of a message, 1 when it has no header
+func schemaVersion(msg *sarama.ConsumerMessage) (int, error) {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == schemaVersionHeader {
+			v, err := strconv.Atoi(string(h.Value))
+			if err != nil || v < 1 {
+				return 0, fmt.Errorf("kafka: invalid %s header %q", schemaVersionHeader, h.Value)
+			}
+			return v, nil
+		}
+	}
+	return 1, nil
+}
+
+// Check the message's schema version is one this consumer understands,
+// i.e. at most Config.MaxSchemaVersion, so a newer producer rolled out
+// first doesn't get its messages decoded into partial structs. Reports
+// false when the message must not be handled, after having applied
+// Config.OnSchemaVersionMismatch, one of the decode error policies.
+func (kc *Client) checkSchemaVersion(msg *sarama.ConsumerMessage, markOffset func(*sarama.ConsumerMessage)) bool {
+	if kc.config.MaxSchemaVersion == 0 {
+		return true
+	}
+
+	v, err := schemaVersion(msg)
+	if err == nil && v <= kc.config.MaxSchemaVersion {
+		return true
+	}
+	if err == nil {
+		err = fmt.Errorf("kafka: schema version %d is newer than the %d supported", v, kc.config.MaxSchemaVersion)
+	}
+
+	policy := kc.config.OnSchemaVersionMismatch
+	schemaVersionMismatches.WithLabelValues(msg.Topic, policy).Inc()
+
+	switch policy {
+	case DecodeErrorDLT:
+		kc.logger().Error("unsupported schema version, dead-lettering", messageFields(msg, err))
+		if dltErr := kc.deadLetter(msg, err, 0); dltErr != nil {
+			kc.logger().Error("failed to dead-letter message, blocking partition", messageFields(msg, dltErr))
+			kc.block(msg.Topic, msg.Partition)
+			return false
+		}
+		markOffset(msg)
+	case DecodeErrorSkip:
+		kc.logger().Error("unsupported schema version, skipping", messageFields(msg, err))
+		markOffset(msg)
+	default:
+		// Left for a consumer that understands it, once deployed
+		kc.logger().Error("unsupported schema version, blocking partition", messageFields(msg, err))
+		kc.block(msg.Topic, msg.Partition)
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// A message of orders at offset 1, with a schema-version header when
+// version isn't empty
+func versionedMessage(version string) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 0, Offset: 1, Value: []byte("{}")}
+	if version != "" {
+		msg.Headers = []*sarama.RecordHeader{{Key: []byte(schemaVersionHeader), Value: []byte(version)}}
+	}
+	return msg
+}
+
+// Process msg with a client supporting schema versions up to 1, applying
+// policy, and report whether the handler was called
+func processVersioned(t *testing.T, policy string, msg *sarama.ConsumerMessage) (bool, *mockConsumer, *recordingProducer) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.MaxSchemaVersion = 1
+	kc.config.OnSchemaVersionMismatch = policy
+	kc.config.DeadLetterTopic = "dead"
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	handled := false
+	kc.Process(context.Background(), msg, func(context.Context, Message) error {
+		handled = true
+		return nil
+	})
+	return handled, consumer, producer
+}
+
+func TestNewerSchemaVersionFailBlocksThePartition(t *testing.T) {
+	handled, consumer, producer := processVersioned(t, DecodeErrorFail, versionedMessage("2"))
+
+	if handled {
+		t.Fatal("a message of a newer schema version was handled")
+	}
+	if marked := consumer.markedOffsets(); len(marked) != 0 {
+		t.Fatalf("marked %v, want the message left for a newer consumer", marked)
+	}
+	if msgs := producer.published(); len(msgs) != 0 {
+		t.Fatalf("published %+v", msgs)
+	}
+}
+
+func TestNewerSchemaVersionDLTDeadLetters(t *testing.T) {
+	handled, consumer, producer := processVersioned(t, DecodeErrorDLT, versionedMessage("2"))
+
+	if handled {
+		t.Fatal("a message of a newer schema version was handled")
+	}
+	if msgs := producer.published(); len(msgs) != 1 || msgs[0].topic != "dead" {
+		t.Fatalf("published %+v, want a dead letter", msgs)
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1}) {
+		t.Fatalf("marked %v, want [1]", marked)
+	}
+}
+
+func TestNewerSchemaVersionSkipCommits(t *testing.T) {
+	handled, consumer, producer := processVersioned(t, DecodeErrorSkip, versionedMessage("2"))
+
+	if handled {
+		t.Fatal("a message of a newer schema version was handled")
+	}
+	if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1}) {
+		t.Fatalf("marked %v, want [1]", marked)
+	}
+	if msgs := producer.published(); len(msgs) != 0 {
+		t.Fatalf("published %+v", msgs)
+	}
+}
+
+func TestSupportedSchemaVersionsAreHandled(t *testing.T) {
+	// No header is version 1
+	for _, version := range []string{"", "1"} {
+		handled, consumer, _ := processVersioned(t, DecodeErrorFail, versionedMessage(version))
+		if !handled {
+			t.Fatalf("version %q wasn't handled", version)
+		}
+		if marked := consumer.markedOffsets(); !reflect.DeepEqual(marked, []int64{1}) {
+			t.Fatalf("version %q: marked %v, want [1]", version, marked)
+		}
+	}
+}
+
+func TestInvalidSchemaVersionIsAMismatch(t *testing.T) {
+	for _, version := range []string{"two", "0"} {
+		if handled, _, _ := processVersioned(t, DecodeErrorSkip, versionedMessage(version)); handled {
+			t.Fatalf("version %q was handled", version)
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	if v, err := schemaVersion(versionedMessage("")); err != nil || v != 1 {
+		t.Fatalf("no header gave %d, %v, want 1", v, err)
+	}
+	if v, err := schemaVersion(versionedMessage("3")); err != nil || v != 3 {
+		t.Fatalf("header 3 gave %d, %v", v, err)
+	}
+}
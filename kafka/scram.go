@@ -0,0 +1,156 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SASL mechanisms of Config.SASLMechanism
+const (
+	SASLPlain       = sarama.SASLTypePlaintext
+	SASLScramSHA256 = sarama.SASLTypeSCRAMSHA256
+	SASLScramSHA512 = sarama.SASLTypeSCRAMSHA512
+)
+
+// Client side of a SCRAM exchange (RFC 5802) for sarama, which leaves the
+// implementation to the application. Names and passwords are used as they
+// are, without SASLprep, which only matters for non-ASCII ones.
+type scramClient struct {
+	hash func() hash.Hash
+
+	user, password, authzID string
+	gs2Header               string
+	nonce                   string
+	clientFirstBare         string
+	authMessage             string
+	saltedPassword          []byte
+	step                    int
+	done                    bool
+}
+
+func newSCRAMClient(mechanism string) func() sarama.SCRAMClient {
+	h := sha256.New
+	if mechanism == SASLScramSHA512 {
+		h = sha512.New
+	}
+	return func() sarama.SCRAMClient { return &scramClient{hash: h} }
+}
+
+// Begin : Implements sarama.SCRAMClient
+func (c *scramClient) Begin(user, password, authzID string) error {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	*c = scramClient{hash: c.hash, user: user, password: password, authzID: authzID}
+	c.nonce = base64.StdEncoding.EncodeToString(nonce)
+	c.gs2Header = "n,,"
+	if authzID != "" {
+		c.gs2Header = "n,a=" + scramEscape(authzID) + ","
+	}
+	return nil
+}
+
+// Step : Implements sarama.SCRAMClient
+func (c *scramClient) Step(challenge string) (string, error) {
+	c.step++
+	switch c.step {
+	case 1:
+		c.clientFirstBare = "n=" + scramEscape(c.user) + ",r=" + c.nonce
+		return c.gs2Header + c.clientFirstBare, nil
+	case 2:
+		return c.clientFinal(challenge)
+	case 3:
+		c.done = true
+		return "", c.verifyServer(challenge)
+	default:
+		return "", errors.New("scram: exchange already done")
+	}
+}
+
+// Done : Implements sarama.SCRAMClient
+func (c *scramClient) Done() bool {
+	return c.done
+}
+
+// Answer the server-first message with the client proof
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce, salt, iterations := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("scram: server nonce doesn't extend the client nonce")
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("scram: invalid salt: %v", err)
+	}
+	iter, err := strconv.Atoi(iterations)
+	if err != nil || iter < 1 {
+		return "", fmt.Errorf("scram: invalid iteration count %q", iterations)
+	}
+
+	c.saltedPassword = pbkdf2.Key([]byte(c.password), saltBytes, iter, c.hash().Size(), c.hash)
+	clientFinalBare := "c=" + base64.StdEncoding.EncodeToString([]byte(c.gs2Header)) + ",r=" + nonce
+	c.authMessage = c.clientFirstBare + "," + serverFirst + "," + clientFinalBare
+
+	clientKey := c.hmac(c.saltedPassword, "Client Key")
+	h := c.hash()
+	h.Write(clientKey)
+	signature := c.hmac(h.Sum(nil), c.authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	return clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// Check the server-final message proves the server knows the password too
+func (c *scramClient) verifyServer(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("scram: server error: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil {
+		return fmt.Errorf("scram: invalid server signature: %v", err)
+	}
+
+	expected := c.hmac(c.hmac(c.saltedPassword, "Server Key"), c.authMessage)
+	if !hmac.Equal(signature, expected) {
+		return errors.New("scram: server signature mismatch")
+	}
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, message string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// The attributes of a SCRAM message, e.g. "r=...,s=...,i=4096"
+func scramAttributes(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if len(attr) >= 2 && attr[1] == '=' {
+			attrs[attr[:1]] = attr[2:]
+		}
+	}
+	return attrs
+}
+
+func scramEscape(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// The SCRAM-SHA-256 exchange of RFC 7677, section 3
+const (
+	rfc7677ClientNonce = "rOprNGfwEbeRWgbNEkqO"
+	rfc7677ServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	rfc7677ClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	rfc7677ServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+// A SCRAM-SHA-256 client for user/pencil that sent the RFC's client-first
+// message
+func newRFC7677Client(t *testing.T) *scramClient {
+	c := newSCRAMClient(SASLScramSHA256)().(*scramClient)
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.nonce = rfc7677ClientNonce
+	first, err := c.Step("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != "n,,n=user,r="+rfc7677ClientNonce {
+		t.Fatalf("client-first message is %q", first)
+	}
+	return c
+}
+
+func TestSCRAMSHA256MatchesRFC7677(t *testing.T) {
+	c := newRFC7677Client(t)
+
+	final, err := c.Step(rfc7677ServerFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final != rfc7677ClientFinal {
+		t.Fatalf("client-final message is %q, want %q", final, rfc7677ClientFinal)
+	}
+	if c.Done() {
+		t.Fatal("done before the server proved itself")
+	}
+	if _, err := c.Step(rfc7677ServerFinal); err != nil {
+		t.Fatalf("valid server signature rejected: %v", err)
+	}
+	if !c.Done() {
+		t.Fatal("not done after the server-final message")
+	}
+	if _, err := c.Step(""); err == nil {
+		t.Fatal("stepped past the end of the exchange")
+	}
+}
+
+func TestSCRAMRejectsAnUnprovenServer(t *testing.T) {
+	tests := map[string]string{
+		"wrong signature": "v=" + strings.Repeat("A", 43) + "=",
+		"server error":    "e=invalid-proof",
+		"no signature":    "v=!",
+	}
+	for name, serverFinal := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newRFC7677Client(t)
+			if _, err := c.Step(rfc7677ServerFirst); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Step(serverFinal); err == nil {
+				t.Fatalf("server-final %q accepted", serverFinal)
+			}
+		})
+	}
+}
+
+func TestSCRAMRejectsAnInvalidServerFirstMessage(t *testing.T) {
+	tests := map[string]string{
+		"nonce not extended": "r=" + rfc7677ClientNonce + ",s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"other nonce":        "r=somethingelse,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"invalid salt":       "r=" + rfc7677ClientNonce + "x,s=!,i=4096",
+		"no iterations":      "r=" + rfc7677ClientNonce + "x,s=W22ZaJ0SNY7soEsUEjb6gQ==",
+		"zero iterations":    "r=" + rfc7677ClientNonce + "x,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+	}
+	for name, serverFirst := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newRFC7677Client(t).Step(serverFirst); err == nil {
+				t.Fatalf("server-first %q accepted", serverFirst)
+			}
+		})
+	}
+}
+
+func TestSCRAMEscapesNamesAndSendsTheAuthzID(t *testing.T) {
+	c := newSCRAMClient(SASLScramSHA512)().(*scramClient)
+	if c.hash().Size() != 64 {
+		t.Fatalf("SCRAM-SHA-512 hashes to %d bytes", c.hash().Size())
+	}
+	if err := c.Begin("a=b,c", "secret", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := c.Step("")
+	if want := "n,a=admin,n=a=3Db=2Cc,r=" + c.nonce; first != want {
+		t.Fatalf("client-first message is %q, want %q", first, want)
+	}
+}
+
+func TestSCRAMClientsUseFreshNonces(t *testing.T) {
+	newClient := newSCRAMClient(SASLScramSHA256)
+	a, b := newClient().(*scramClient), newClient().(*scramClient)
+	a.Begin("user", "pencil", "")
+	b.Begin("user", "pencil", "")
+	if a.nonce == "" || a.nonce == b.nonce {
+		t.Fatalf("nonces %q and %q", a.nonce, b.nonce)
+	}
+}
+
+func TestApplySASLConfiguresSCRAM(t *testing.T) {
+	cfg := &Config{SASLMechanism: SASLScramSHA512, Username: "user", Password: "pencil"}
+	config := sarama.NewConfig()
+	cfg.applySASL(config)
+
+	if !config.Net.SASL.Enable || config.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 || config.Net.SASL.User != "user" {
+		t.Fatalf("SASL config is %+v", config.Net.SASL)
+	}
+	if config.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Fatal("no SCRAM client for sarama")
+	}
+	if c := config.Net.SASL.SCRAMClientGeneratorFunc().(*scramClient); c.hash().Size() != 64 {
+		t.Fatal("SCRAM-SHA-512 client doesn't hash with SHA-512")
+	}
+}
@@ -0,0 +1,146 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// ErrSelfTestDisabled is returned by SelfTest unless Config.EnableSelfTest
+// is set, as the self test writes to the cluster
+var ErrSelfTestDisabled = errors.New("kafka: self test is disabled")
+
+// SelfTest : Publishes a uniquely keyed sentinel message to the health
+// topic and waits for it to come back through a short-lived consumer. This
+// checks the whole round trip (auth, produce, consume) rather than just
+// reachability. Gives up after Config.SelfTestTimeout or when ctx is done.
+// Like Tail, it connects producer and consumer of its own and joins no
+// consumer group, so the client doesn't need to be connected.
+func (kc *Client) SelfTest(ctx context.Context) error {
+	if kc.config == nil {
+		kc.config = LoadConfig()
+	}
+	if !kc.config.EnableSelfTest {
+		return ErrSelfTestDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, kc.config.SelfTestTimeout)
+	defer cancel()
+
+	hostname, _ := os.Hostname()
+	key := fmt.Sprintf("self-test-%s-%d", hostname, time.Now().UnixNano())
+	topic := kc.config.topic(kc.config.HealthTopic)
+
+	producer, brokers, tlsConfig, err := kc.standaloneProducer()
+	if err != nil {
+		return fmt.Errorf("kafka: self test producer: %v", err)
+	}
+	defer producer.Close()
+
+	partition, offset, err := producer.SendMessage(&sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.StringEncoder(`{"self_test":true}`),
+	})
+	if err != nil {
+		return fmt.Errorf("kafka: self test produce: %v", err)
+	}
+
+	// Read the partition directly from the produced offset, a consumer
+	// group would trigger a rebalance for nothing
+	config := sarama.NewConfig()
+	config.Net.TLS.Config = tlsConfig
+	config.Net.TLS.Enable = true
+	kc.config.applySASL(config)
+	if config.Version, err = kc.config.kafkaVersion(config.Version); err != nil {
+		return err
+	}
+
+	consumer, err := sarama.NewConsumer(brokers, config)
+	if err != nil {
+		return fmt.Errorf("kafka: self test consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return fmt.Errorf("kafka: self test consumer: %v", err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg != nil && string(msg.Key) == key {
+				return nil
+			}
+		case err := <-pc.Errors():
+			if err != nil {
+				return fmt.Errorf("kafka: self test consume: %v", err)
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("kafka: self test message not received: %v", ctx.Err())
+		}
+	}
+}
+
+// ProduceOnce : Produces a single message to a topic given by its name
+// without prefix and waits for the broker to acknowledge it, as
+// ProduceSync does, but through a sync producer of its own that is closed
+// before returning. For one-off smoke tests, which shouldn't Connect as
+// that joins the service's consumer group.
+func (kc *Client) ProduceOnce(topic string, value []byte) (int32, int64, error) {
+	if kc.config == nil {
+		kc.config = LoadConfig()
+	}
+
+	producer, _, _, err := kc.standaloneProducer()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer producer.Close()
+
+	return producer.SendMessage(&sarama.ProducerMessage{
+		Topic: kc.config.topic(topic),
+		Value: sarama.ByteEncoder(value),
+	})
+}
+
+// The sync producer of a one-off command, with the brokers and TLS config
+// it was connected with, for a client that isn't connected
+func (kc *Client) standaloneProducer() (sarama.SyncProducer, []string, *tls.Config, error) {
+	kc.producerMu.RLock()
+	closed := kc.producerClosed
+	kc.producerMu.RUnlock()
+	if closed {
+		return nil, nil, nil, ErrProducerClosed
+	}
+
+	cfg := kc.config
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	if cfg.MetricRegistry == nil {
+		cfg.MetricRegistry = metrics.NewRegistry()
+	}
+	tlsConfig, err := cfg.createTLSConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	brokers, err := cfg.BrokerAddresses()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	producer, err := cfg.createKafkaSyncProducer(brokers, tlsConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return producer, brokers, tlsConfig, nil
+}
@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ConsumeOption : Optional setting of Consume
+type ConsumeOption func(*consumeOptions)
+
+type consumeOptions struct {
+	// Workers of WithConcurrency, 0 when not given
+	concurrency int
+	invalid     error
+}
+
+// WithConcurrency : Handles the messages of every partition on one pool of
+// n workers, instead of Config.MaxConcurrentPerPartition workers for each
+// partition, whose number grows with the partitions assigned, or the
+// key-sharded workers of Config.OrderedByKey. Messages of a partition may
+// be handled out of order, but their offsets are still marked in order.
+// Once every worker is busy and Config.ChannelBufferSize messages are
+// queued, the consume loop waits, so no more messages are fetched than the
+// pool can take.
+func WithConcurrency(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		if n < 1 {
+			o.invalid = fmt.Errorf("kafka: concurrency must be at least 1, got %d", n)
+			return
+		}
+		o.concurrency = n
+	}
+}
+
+func newConsumeOptions(opts []ConsumeOption) (consumeOptions, error) {
+	var o consumeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o, o.invalid
+}
+
+// A queued message of a sharedPool, with the commit order of its partition
+type sharedJob struct {
+	job
+	order *commitOrder
+}
+
+// sharedPool handles the messages of every partition on a fixed set of
+// workers reading from one queue, see WithConcurrency
+type sharedPool struct {
+	queue chan sharedJob
+
+	mu     sync.Mutex
+	orders map[topicPartition]*commitOrder
+}
+
+func (kc *Client) newSharedPool(workers int, buffer int) *sharedPool {
+	p := &sharedPool{
+		queue:  make(chan sharedJob, buffer),
+		orders: make(map[topicPartition]*commitOrder),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range p.queue {
+				kc.process(j.ctx, j.msg, j.handler, j.order.done)
+				kc.inflight.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// Queue the message for the next free worker. Blocks while the queue is
+// full, which holds back the consume loop, unless the job's ctx is
+// cancelled; the message is then dropped, left uncommitted along with
+// everything after it on its partition.
+func (kc *Client) submitShared(p *sharedPool, j job) {
+	tp := topicPartition{j.msg.Topic, j.msg.Partition}
+
+	p.mu.Lock()
+	order, ok := p.orders[tp]
+	if !ok {
+		order = kc.newCommitOrder(tp)
+		p.orders[tp] = order
+	}
+	p.mu.Unlock()
+
+	if !kc.startInflight() {
+		return
+	}
+	order.add(j.msg.Offset)
+	select {
+	case p.queue <- sharedJob{j, order}:
+	case <-j.ctx.Done():
+		kc.inflight.Done()
+	}
+}
+
+// Stop accepting messages. Workers exit once the queue is drained.
+func (p *sharedPool) close() {
+	close(p.queue)
+}
@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrProducerClosed is returned when publishing after Shutdown
+var ErrProducerClosed = errors.New("kafka: producer is closed")
+
+// Dispatch : Processes the message in its own goroutine, tracking it so
+// Shutdown can wait for it to finish. Once Shutdown started, the message
+// is left uncommitted for redelivery instead.
+func (kc *Client) Dispatch(ctx context.Context, msg *sarama.ConsumerMessage, handler Handler) {
+	if !kc.startInflight() {
+		return
+	}
+	go func() {
+		defer kc.inflight.Done()
+		kc.Process(ctx, msg, handler)
+	}()
+}
+
+// Shutdown : Stops the client in an order that doesn't lose work. Consume
+// stops handing out messages first, then in-flight handlers (which may
+// still publish) are given until ctx is done or Config.ShutdownTimeout
+// elapses to finish. Only then are the producers flushed and closed, and
+// the offsets of the handled messages committed as the consumer leaves the
+// group. Only the first call does anything, later ones return nil.
+func (kc *Client) Shutdown(ctx context.Context) error {
+	var err error
+	kc.stopOnce.Do(func() { err = kc.shutdown(ctx) })
+	return err
+}
+
+// OnShutdown : Registers a function run at the end of Shutdown, once the
+// handlers are done and the producers are flushed, e.g. the Shutdown of a
+// tracer provider, so the spans of the last messages handled before the
+// process exits aren't lost. It gets whatever is left of the shutdown
+// timeout. Hooks run in the order they were registered, even when an
+// earlier step failed, and not at all for a client that never connected.
+// Call before Connect.
+func (kc *Client) OnShutdown(fn func(ctx context.Context) error) {
+	kc.shutdownHooks = append(kc.shutdownHooks, fn)
+}
+
+// Close : Shuts the client down as with Shutdown, bounded by
+// Config.ShutdownTimeout only. Safe to call more than once, e.g. from a
+// defer and a signal handler.
+func (kc *Client) Close() error {
+	return kc.Shutdown(context.Background())
+}
+
+// Count a handler among the ones Shutdown waits for. False once Shutdown
+// started waiting, as adding to the WaitGroup then would race with Wait.
+func (kc *Client) startInflight() bool {
+	kc.drainMu.RLock()
+	defer kc.drainMu.RUnlock()
+	if kc.draining {
+		return false
+	}
+	kc.inflight.Add(1)
+	return true
+}
+
+func (kc *Client) shutdown(ctx context.Context) error {
+	// Never connected, nothing to close. Connect is refused from now on,
+	// as after any other Shutdown.
+	if kc.done == nil {
+		kc.producerMu.Lock()
+		kc.producerClosed = true
+		kc.producerMu.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, kc.config.ShutdownTimeout)
+	defer cancel()
+
+	close(kc.done)
+
+	kc.connectMu.Lock()
+	kc.connected = false
+	kc.connectMu.Unlock()
+
+	var errs []string
+
+	// Consume dispatches nothing more once done is closed. The consumer
+	// stays in the group until the handlers are done, so the offsets they
+	// mark are still committed. Whatever is being dispatched meanwhile is
+	// refused, so the wait can't be extended or raced.
+	kc.drainMu.Lock()
+	kc.draining = true
+	kc.drainMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		kc.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, "timed out waiting for in-flight handlers")
+	}
+
+	// Publishes blocked on a full async producer return ErrProducerClosed,
+	// so they can't hold producerMu past the timeout
+	close(kc.closing)
+
+	kc.producerMu.Lock()
+	kc.producerClosed = true
+
+	// Close flushes any buffered messages before returning
+	if err := kc.producer.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	// A producer set with SetProducer doesn't own the sarama producers
+	if kc.customProducer {
+		if err := kc.Producer.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("producer: %v", err))
+		}
+		if err := kc.SyncProducer.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("sync producer: %v", err))
+		}
+	}
+	kc.producerMu.Unlock()
+
+	if err := kc.LeaveGroup(); err != nil {
+		errs = append(errs, fmt.Sprintf("consumer: %v", err))
+	}
+	kc.retryMu.Lock()
+	for _, consumer := range kc.retryConsumers {
+		if err := consumer.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("retry consumer: %v", err))
+		}
+	}
+	kc.retryMu.Unlock()
+	if kc.archiver != nil {
+		if err := kc.archiver.close(); err != nil {
+			errs = append(errs, fmt.Sprintf("archiver: %v", err))
+		}
+	}
+
+	if err := kc.closeMetadata(); err != nil {
+		errs = append(errs, fmt.Sprintf("metadata client: %v", err))
+	}
+
+	// Last, so the spans and metrics of the final messages are flushed
+	for _, fn := range kc.shutdownHooks {
+		if err := fn(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("shutdown hook: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("kafka: shutdown: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
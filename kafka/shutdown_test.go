@@ -0,0 +1,140 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// Async producer that never takes a message, as when brokers are down and
+// its buffer is full
+type stuckAsyncProducer struct {
+	input chan *sarama.ProducerMessage
+}
+
+func (p *stuckAsyncProducer) AsyncClose()                               {}
+func (p *stuckAsyncProducer) Close() error                              { return nil }
+func (p *stuckAsyncProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *stuckAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return nil }
+func (p *stuckAsyncProducer) Errors() <-chan *sarama.ProducerError      { return nil }
+
+// A client as Connect leaves it, publishing to a stuck async producer
+func newConnectedTestClient(t *testing.T) (*Client, *stuckAsyncProducer) {
+	kc := newTestClient(newMockConsumer())
+	kc.config.ShutdownTimeout = time.Second
+	kc.done = make(chan struct{})
+	kc.closing = make(chan struct{})
+
+	async := &stuckAsyncProducer{input: make(chan *sarama.ProducerMessage)}
+	kc.producer = &SaramaProducer{
+		Async:   async,
+		Sync:    mocks.NewSyncProducer(t, nil),
+		Version: sarama.MinVersion,
+		closing: kc.closing,
+	}
+	return kc, async
+}
+
+func TestShutdownUnblocksAStuckPublish(t *testing.T) {
+	kc, _ := newConnectedTestClient(t)
+
+	published := make(chan error, 1)
+	go func() { published <- kc.PublishWithOptions("orders", []byte("{}"), PublishOptions{}) }()
+	// Let the publish block on the producer, holding producerMu
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- kc.Close() }()
+
+	select {
+	case err := <-published:
+		if err != ErrProducerClosed {
+			t.Fatalf("blocked publish returned %v, want ErrProducerClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish still blocked after Shutdown")
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("Close returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown blocked on the stuck publish")
+	}
+}
+
+func TestNoPublishAfterTheProducerIsClosed(t *testing.T) {
+	kc, async := newConnectedTestClient(t)
+	if err := kc.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+
+	if err := kc.PublishWithOptions("orders", []byte("{}"), PublishOptions{}); err != ErrProducerClosed {
+		t.Fatalf("PublishWithOptions returned %v, want ErrProducerClosed", err)
+	}
+	if err := kc.TryPublish("orders", []byte("{}"), PublishOptions{}); err != ErrProducerClosed {
+		t.Fatalf("TryPublish returned %v, want ErrProducerClosed", err)
+	}
+	select {
+	case msg := <-async.input:
+		t.Fatalf("%v was published after Close", msg)
+	default:
+	}
+}
+
+func TestDispatchAfterShutdownLeavesTheMessage(t *testing.T) {
+	consumer := newMockConsumer()
+	kc, _ := newConnectedTestClient(t)
+	kc.Consumer = consumer
+	if err := kc.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+
+	handled := make(chan struct{}, 1)
+	kc.Dispatch(context.Background(), &sarama.ConsumerMessage{Topic: "orders"}, func(context.Context, Message) error {
+		handled <- struct{}{}
+		return nil
+	})
+	kc.inflight.Wait()
+
+	select {
+	case <-handled:
+		t.Fatal("a message dispatched after Shutdown was handled")
+	default:
+	}
+	if marked := consumer.markedOffsets(); len(marked) != 0 {
+		t.Fatalf("marked %v after Shutdown", marked)
+	}
+}
+
+func TestCloseTwiceReturnsNil(t *testing.T) {
+	unconnected := newTestClient(newMockConsumer())
+	connected, _ := newConnectedTestClient(t)
+
+	for name, kc := range map[string]*Client{"unconnected": unconnected, "connected": connected} {
+		for i := 0; i < 2; i++ {
+			if err := kc.Close(); err != nil {
+				t.Fatalf("%s client: Close %d returned %v", name, i+1, err)
+			}
+		}
+	}
+}
+
+func TestConcurrentClosesReturnNil(t *testing.T) {
+	kc, _ := newConnectedTestClient(t)
+
+	// As from a defer and a signal handler at once
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- kc.Close() }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Close returned %v", err)
+		}
+	}
+}
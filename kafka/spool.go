@@ -0,0 +1,314 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrSpoolFull is returned when a message can't be sent and the spool
+// has reached its maximum size
+var ErrSpoolFull = errors.New("kafka: spool is full")
+
+var spoolDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "kafka",
+	Name:      "spool_depth",
+	Help:      "Messages spooled to disk waiting to be replayed.",
+})
+
+var spoolDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "kafka",
+	Name:      "spool_dropped_total",
+	Help:      "Spooled messages dropped on replay, as corrupt or failing with an error that isn't retriable.",
+})
+
+func init() {
+	prometheus.MustRegister(spoolDepth, spoolDropped)
+}
+
+const spoolFile = "spool.ndjson"
+
+// A spooled message, one per line of the spool file
+type spoolRecord struct {
+	Topic       string            `json:"topic"`
+	Key         []byte            `json:"key,omitempty"`
+	Value       []byte            `json:"value"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	RawHeaders  map[string][]byte `json:"raw_headers,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+// SpoolingProducer : Producer that appends messages it can't send to a
+// local file and replays them, in order, once the brokers are reachable
+// again. Publishes wait for the broker's acknowledgement so failures can be
+// spooled, and while the spool isn't empty new messages are spooled behind
+// it to keep their order. Only failures that IsRetriable are spooled;
+// others, such as a message too large for the broker, would fail on every
+// replay and are returned to the caller instead.
+type SpoolingProducer struct {
+	producer *SaramaProducer
+	maxBytes int64
+	logger   Logger
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	depth    int
+	replayed int64 // Bytes of the file already replayed
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// NewSpoolingProducer : Spools to dir, holding at most maxBytes. Messages
+// left in the spool by a previous run are replayed before returning, and
+// the rest is retried every interval.
+func NewSpoolingProducer(p *SaramaProducer, dir string, maxBytes int64, interval time.Duration) (*SpoolingProducer, error) {
+	return newSpoolingProducer(p, dir, maxBytes, interval, defaultLogger)
+}
+
+func newSpoolingProducer(p *SaramaProducer, dir string, maxBytes int64, interval time.Duration, logger Logger) (*SpoolingProducer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, spoolFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &SpoolingProducer{
+		producer: p,
+		maxBytes: maxBytes,
+		logger:   logger,
+		file:     file,
+		stop:     make(chan struct{}),
+	}
+	if err := sp.count(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if sp.depth > 0 {
+		sp.logger.Info("replaying spooled messages", Fields{"depth": sp.depth})
+		if err := sp.replay(); err != nil {
+			sp.logger.Error("failed to replay spool", Fields{"depth": sp.depth, "error": err})
+		}
+	}
+
+	sp.stopped.Add(1)
+	go sp.run(interval)
+	return sp, nil
+}
+
+// Publish : Implements Producer
+func (sp *SpoolingProducer) Publish(topic string, value []byte, opts PublishOptions) error {
+	msg, err := producerMessage(sp.producer.Version, topic, value, opts)
+	if err != nil {
+		return err
+	}
+
+	// Sent without holding mu, so publishers aren't serialised while the
+	// brokers are up
+	sp.mu.Lock()
+	spooling := sp.depth > 0
+	sp.mu.Unlock()
+	if !spooling {
+		_, _, err := sp.producer.Sync.SendMessage(msg)
+		if err == nil {
+			return nil
+		}
+		if !IsRetriable(err) {
+			return err
+		}
+		sp.logger.Warn("failed to publish, spooling", Fields{"topic": topic, "error": err})
+	}
+
+	rec := spoolRecord{
+		Topic:       topic,
+		Key:         opts.Key,
+		Value:       value,
+		Headers:     opts.Headers,
+		RawHeaders:  opts.RawHeaders,
+		ContentType: opts.ContentType,
+	}
+	// Keep the original event time where the broker can carry it
+	if sp.producer.Version.IsAtLeast(sarama.V0_10_0_0) {
+		rec.Timestamp = msg.Timestamp
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.append(rec)
+}
+
+// ProduceSync : Implements Producer. Callers waiting for an offset get
+// the error instead of having the message spooled.
+func (sp *SpoolingProducer) ProduceSync(topic string, value []byte) (int32, int64, error) {
+	return sp.producer.ProduceSync(topic, value)
+}
+
+// PublishSync : Like ProduceSync, callers waiting for an offset get the
+// error instead of having the message spooled
+func (sp *SpoolingProducer) PublishSync(topic string, value []byte, opts PublishOptions) (int32, int64, error) {
+	return sp.producer.PublishSync(topic, value, opts)
+}
+
+// Close : Implements Producer. Spooled messages stay on disk for the next
+// run.
+func (sp *SpoolingProducer) Close() error {
+	close(sp.stop)
+	sp.stopped.Wait()
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	err := sp.producer.Close()
+	if fileErr := sp.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+func (sp *SpoolingProducer) run(interval time.Duration) {
+	defer sp.stopped.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sp.mu.Lock()
+			if sp.depth > 0 {
+				if err := sp.replay(); err != nil {
+					sp.logger.Error("failed to replay spool", Fields{"depth": sp.depth, "error": err})
+				}
+			}
+			sp.mu.Unlock()
+		case <-sp.stop:
+			return
+		}
+	}
+}
+
+// Must be called with mu held
+func (sp *SpoolingProducer) append(rec spoolRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if sp.size+int64(len(line)) > sp.maxBytes {
+		return ErrSpoolFull
+	}
+	if _, err := sp.file.Write(line); err != nil {
+		return fmt.Errorf("kafka: spool: %v", err)
+	}
+
+	sp.size += int64(len(line))
+	sp.depth++
+	spoolDepth.Set(float64(sp.depth))
+	return nil
+}
+
+// Send the spooled messages in order, stopping at the first failure that
+// IsRetriable. Records failing otherwise are dropped, as they would block
+// the spool for good. Must be called with mu held.
+func (sp *SpoolingProducer) replay() error {
+	if _, err := sp.file.Seek(sp.replayed, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(sp.file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec spoolRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			sp.logger.Warn("dropping corrupt spool record", errorFields(err))
+			spoolDropped.Inc()
+		} else if retriable, err := sp.send(rec); err != nil {
+			if retriable {
+				return err
+			}
+			sp.logger.Error("dropping spooled message that can't be published", Fields{"topic": rec.Topic, "error": err})
+			spoolDropped.Inc()
+		}
+
+		sp.replayed += int64(len(line))
+		sp.depth--
+		spoolDepth.Set(float64(sp.depth))
+	}
+
+	// Everything was sent, start over with an empty file
+	if err := sp.file.Truncate(0); err != nil {
+		return err
+	}
+	sp.size, sp.replayed, sp.depth = 0, 0, 0
+	spoolDepth.Set(0)
+	return nil
+}
+
+// Send a spooled message, reporting whether a failure may clear up
+func (sp *SpoolingProducer) send(rec spoolRecord) (bool, error) {
+	msg, err := producerMessage(sp.producer.Version, rec.Topic, rec.Value, PublishOptions{
+		Timestamp:   rec.Timestamp,
+		Key:         rec.Key,
+		Headers:     rec.Headers,
+		RawHeaders:  rec.RawHeaders,
+		ContentType: rec.ContentType,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	_, _, err = sp.producer.Sync.SendMessage(msg)
+	return err != nil && IsRetriable(err), err
+}
+
+// Count the records of an existing spool file
+func (sp *SpoolingProducer) count() error {
+	if _, err := sp.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(sp.file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line is left over from a crash mid-write and
+			// would corrupt the next record appended
+			if len(line) > 0 {
+				sp.logger.Warn("dropping partial spool record", Fields{"bytes": len(line)})
+				if err := sp.file.Truncate(sp.size); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		sp.size += int64(len(line))
+		sp.depth++
+	}
+	spoolDepth.Set(float64(sp.depth))
+	return nil
+}
@@ -0,0 +1,265 @@
+package kafka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// A spool in dir sending through a mock sync producer. Nothing is replayed
+// on a timer, the tests call replay themselves.
+func newTestSpool(t *testing.T, dir string, maxBytes int64) (*SpoolingProducer, *mocks.SyncProducer) {
+	sync := mocks.NewSyncProducer(t, nil)
+	return newTestSpoolWith(t, dir, maxBytes, sync), sync
+}
+
+func newTestSpoolWith(t *testing.T, dir string, maxBytes int64, sync *mocks.SyncProducer) *SpoolingProducer {
+	p := &SaramaProducer{
+		Async:   &stuckAsyncProducer{},
+		Sync:    sync,
+		Version: sarama.V2_1_0_0,
+	}
+	sp, err := newSpoolingProducer(p, dir, maxBytes, time.Hour, discardLogger{})
+	if err != nil {
+		t.Fatalf("newSpoolingProducer returned %v", err)
+	}
+	return sp
+}
+
+// Expect a message with value want to be sent, failing with err if set
+func expectSend(sync *mocks.SyncProducer, want string, err error) {
+	check := func(value []byte) error {
+		if string(value) != want {
+			return fmt.Errorf("sent %s, want %s", value, want)
+		}
+		return nil
+	}
+	if err != nil {
+		sync.ExpectSendMessageWithCheckerFunctionAndFail(check, err)
+		return
+	}
+	sync.ExpectSendMessageWithCheckerFunctionAndSucceed(check)
+}
+
+func spoolDepthOf(sp *SpoolingProducer) int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.depth
+}
+
+func replaySpool(t *testing.T, sp *SpoolingProducer) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.replay()
+}
+
+func TestRetriableFailuresAreSpooledAndReplayedInOrder(t *testing.T) {
+	sp, sync := newTestSpool(t, t.TempDir(), 1<<20)
+	defer sp.Close()
+
+	expectSend(sync, "1", sarama.ErrLeaderNotAvailable)
+	if err := sp.Publish("orders", []byte("1"), PublishOptions{}); err != nil {
+		t.Fatalf("Publish returned %v, want the message spooled", err)
+	}
+	// Spooled behind the first without being sent, to keep the order
+	if err := sp.Publish("orders", []byte("2"), PublishOptions{}); err != nil {
+		t.Fatalf("Publish returned %v", err)
+	}
+	if depth := spoolDepthOf(sp); depth != 2 {
+		t.Fatalf("spool depth %d, want 2", depth)
+	}
+
+	expectSend(sync, "1", nil)
+	expectSend(sync, "2", nil)
+	if err := replaySpool(t, sp); err != nil {
+		t.Fatalf("replay returned %v", err)
+	}
+	if depth := spoolDepthOf(sp); depth != 0 || sp.size != 0 {
+		t.Fatalf("spool depth %d, size %d after replay, want an empty spool", depth, sp.size)
+	}
+
+	// Sent straight away again
+	expectSend(sync, "3", nil)
+	if err := sp.Publish("orders", []byte("3"), PublishOptions{}); err != nil {
+		t.Fatalf("Publish returned %v", err)
+	}
+}
+
+func TestFatalFailuresAreReturnedRatherThanSpooled(t *testing.T) {
+	sp, sync := newTestSpool(t, t.TempDir(), 1<<20)
+	defer sp.Close()
+
+	expectSend(sync, "too large", sarama.ErrMessageSizeTooLarge)
+	if err := sp.Publish("orders", []byte("too large"), PublishOptions{}); err != sarama.ErrMessageSizeTooLarge {
+		t.Fatalf("Publish returned %v, want ErrMessageSizeTooLarge", err)
+	}
+	if depth := spoolDepthOf(sp); depth != 0 {
+		t.Fatalf("spool depth %d, want nothing spooled", depth)
+	}
+}
+
+func TestReplayStopsAtARetriableFailure(t *testing.T) {
+	sp, sync := newTestSpool(t, t.TempDir(), 1<<20)
+	defer sp.Close()
+
+	expectSend(sync, "1", sarama.ErrLeaderNotAvailable)
+	sp.Publish("orders", []byte("1"), PublishOptions{})
+	sp.Publish("orders", []byte("2"), PublishOptions{})
+
+	expectSend(sync, "1", sarama.ErrLeaderNotAvailable)
+	if err := replaySpool(t, sp); err != sarama.ErrLeaderNotAvailable {
+		t.Fatalf("replay returned %v, want ErrLeaderNotAvailable", err)
+	}
+	if depth := spoolDepthOf(sp); depth != 2 {
+		t.Fatalf("spool depth %d, want both messages kept", depth)
+	}
+
+	expectSend(sync, "1", nil)
+	expectSend(sync, "2", nil)
+	if err := replaySpool(t, sp); err != nil {
+		t.Fatalf("replay returned %v", err)
+	}
+}
+
+func TestReplayDropsRecordsFailingFatally(t *testing.T) {
+	sp, sync := newTestSpool(t, t.TempDir(), 1<<20)
+	defer sp.Close()
+
+	expectSend(sync, "1", sarama.ErrLeaderNotAvailable)
+	for _, v := range []string{"1", "2", "3"} {
+		sp.Publish("orders", []byte(v), PublishOptions{})
+	}
+
+	dropped := counterValue(t, "kafka_spool_dropped_total", "")
+	expectSend(sync, "1", nil)
+	expectSend(sync, "2", sarama.ErrMessageSizeTooLarge)
+	expectSend(sync, "3", nil)
+	if err := replaySpool(t, sp); err != nil {
+		t.Fatalf("replay returned %v, want the fatal record dropped", err)
+	}
+	if depth := spoolDepthOf(sp); depth != 0 {
+		t.Fatalf("spool depth %d, want an empty spool", depth)
+	}
+	if got := counterValue(t, "kafka_spool_dropped_total", ""); got != dropped+1 {
+		t.Fatalf("kafka_spool_dropped_total went from %v to %v, want one more", dropped, got)
+	}
+}
+
+func TestSpoolIsReplayedAfterARestart(t *testing.T) {
+	dir := t.TempDir()
+	sp, sync := newTestSpool(t, dir, 1<<20)
+	expectSend(sync, "1", sarama.ErrBrokerNotAvailable)
+	sp.Publish("orders", []byte("1"), PublishOptions{Key: []byte("k")})
+	sp.Publish("orders", []byte("2"), PublishOptions{})
+	if err := sp.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+
+	// Replayed before the new producer is returned
+	next := mocks.NewSyncProducer(t, nil)
+	expectSend(next, "1", nil)
+	expectSend(next, "2", nil)
+	restarted := newTestSpoolWith(t, dir, 1<<20, next)
+	defer restarted.Close()
+
+	if depth := spoolDepthOf(restarted); depth != 0 {
+		t.Fatalf("spool depth %d after the restart, want everything replayed", depth)
+	}
+}
+
+func TestPartialSpoolRecordIsTruncated(t *testing.T) {
+	dir := t.TempDir()
+	// A crash in the middle of writing the second record
+	content := `{"topic":"orders","value":"MQ==","timestamp":"2020-01-01T00:00:00Z"}` + "\n" + `{"topic":"ord`
+	if err := ioutil.WriteFile(filepath.Join(dir, spoolFile), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sync := mocks.NewSyncProducer(t, nil)
+	expectSend(sync, "1", sarama.ErrBrokerNotAvailable)
+	sp := newTestSpoolWith(t, dir, 1<<20, sync)
+	defer sp.Close()
+
+	if depth := spoolDepthOf(sp); depth != 1 {
+		t.Fatalf("spool depth %d, want the complete record only", depth)
+	}
+	// Appended after the complete record, not glued to the partial one
+	if err := sp.Publish("orders", []byte("2"), PublishOptions{}); err != nil {
+		t.Fatalf("Publish returned %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, spoolFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 || strings.Contains(string(data), `"ord{`) {
+		t.Fatalf("spool file is %q, want two complete records", data)
+	}
+
+	expectSend(sync, "1", nil)
+	expectSend(sync, "2", nil)
+	if err := replaySpool(t, sp); err != nil {
+		t.Fatalf("replay returned %v", err)
+	}
+}
+
+func TestFullSpoolRefusesMessages(t *testing.T) {
+	sp, sync := newTestSpool(t, t.TempDir(), 100)
+	defer sp.Close()
+
+	expectSend(sync, "1", sarama.ErrBrokerNotAvailable)
+	if err := sp.Publish("orders", []byte("1"), PublishOptions{}); err != nil {
+		t.Fatalf("Publish returned %v", err)
+	}
+	if err := sp.Publish("orders", []byte(strings.Repeat("x", 100)), PublishOptions{}); err != ErrSpoolFull {
+		t.Fatalf("Publish returned %v, want ErrSpoolFull", err)
+	}
+}
+
+// Sync producer holding every message until release is closed
+type blockingSyncProducer struct {
+	sarama.SyncProducer
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingSyncProducer) SendMessage(*sarama.ProducerMessage) (int32, int64, error) {
+	p.sending <- struct{}{}
+	<-p.release
+	return 0, 0, nil
+}
+
+func (p *blockingSyncProducer) Close() error { return nil }
+
+func TestSpoolDoesntSerialisePublishers(t *testing.T) {
+	blocking := &blockingSyncProducer{sending: make(chan struct{}, 2), release: make(chan struct{})}
+	sp, err := newSpoolingProducer(&SaramaProducer{Async: &stuckAsyncProducer{}, Sync: blocking, Version: sarama.V2_1_0_0}, t.TempDir(), 1<<20, time.Hour, discardLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- sp.Publish("orders", []byte("{}"), PublishOptions{}) }()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-blocking.sending:
+		case <-time.After(time.Second):
+			t.Fatal("the second publish waited for the first to be acknowledged")
+		}
+	}
+	close(blocking.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Publish returned %v", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// The sarama metrics included in Stats. Per-broker and per-topic variants
+// (e.g. "request-rate-for-broker-0") are left out to keep the snapshot small.
+var statsMetrics = []string{
+	"incoming-byte-rate",
+	"outgoing-byte-rate",
+	"request-rate",
+	"response-rate",
+	"request-size",
+	"response-size",
+	"request-latency-in-ms",
+	"batch-size",
+	"record-send-rate",
+	"records-per-request",
+	"compression-ratio",
+}
+
+// Stats : Snapshot of the broker-level metrics sarama records for the
+// producers and the consumer, keyed by metric name. Empty until the client
+// is connected.
+func (kc *Client) Stats() map[string]interface{} {
+	stats := make(map[string]interface{})
+	if kc.config == nil || kc.config.MetricRegistry == nil {
+		return stats
+	}
+	for _, name := range statsMetrics {
+		metric := kc.config.MetricRegistry.Get(name)
+		if metric == nil {
+			continue
+		}
+
+		switch m := metric.(type) {
+		case metrics.Meter:
+			s := m.Snapshot()
+			stats[name] = map[string]interface{}{
+				"count":     s.Count(),
+				"1m.rate":   s.Rate1(),
+				"5m.rate":   s.Rate5(),
+				"15m.rate":  s.Rate15(),
+				"mean.rate": s.RateMean(),
+			}
+		case metrics.Histogram:
+			s := m.Snapshot()
+			ps := s.Percentiles([]float64{0.5, 0.95, 0.99})
+			stats[name] = map[string]interface{}{
+				"count": s.Count(),
+				"min":   s.Min(),
+				"max":   s.Max(),
+				"mean":  s.Mean(),
+				"p50":   ps[0],
+				"p95":   ps[1],
+				"p99":   ps[2],
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"testing"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestStatsOfAClientThatIsntConnectedAreEmpty(t *testing.T) {
+	for name, kc := range map[string]*Client{
+		"zero client":   {},
+		"no registry":   newTestClient(newMockConsumer()),
+		"with registry": {config: &Config{MetricRegistry: metrics.NewRegistry()}},
+	} {
+		if stats := kc.Stats(); stats == nil || len(stats) != 0 {
+			t.Fatalf("%s: stats are %v, want an empty map", name, stats)
+		}
+	}
+}
+
+func TestStatsSnapshotTheSaramaMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterMeter("request-rate", registry).Mark(3)
+	metrics.GetOrRegisterHistogram("batch-size", registry, metrics.NewUniformSample(16)).Update(512)
+	// Per-broker metrics are left out
+	metrics.GetOrRegisterMeter("request-rate-for-broker-0", registry).Mark(3)
+	kc := &Client{config: &Config{MetricRegistry: registry}}
+
+	stats := kc.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("stats are %v, want request-rate and batch-size", stats)
+	}
+	if count := stats["request-rate"].(map[string]interface{})["count"]; count != int64(3) {
+		t.Fatalf("request-rate count is %v", count)
+	}
+	if max := stats["batch-size"].(map[string]interface{})["max"]; max != int64(512) {
+		t.Fatalf("batch-size max is %v", max)
+	}
+}
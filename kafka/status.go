@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type partitionStatus struct {
+	Partition int32 `json:"partition"`
+	Committed int64 `json:"committed"`
+	Lag       int64 `json:"lag"`
+}
+
+type consumerStatus struct {
+	Group      string                       `json:"group"`
+	InstanceID string                       `json:"instance_id"`
+	Partitions map[string][]partitionStatus `json:"partitions"`
+	Inflight   int64                        `json:"inflight"`
+	ReportedAt time.Time                    `json:"reported_at"`
+}
+
+// Publish the progress of this consumer to Config.StatusTopic every
+// Config.StatusInterval, keyed by the instance id so a compacted topic
+// keeps the latest status of every instance
+func (kc *Client) reportStatus() {
+	instanceID, err := os.Hostname()
+	if err != nil {
+		kc.logger().Error("cannot determine hostname for status reports", errorFields(err))
+		return
+	}
+
+	ticker := time.NewTicker(kc.config.StatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kc.done:
+			return
+		case <-ticker.C:
+			status, err := kc.status(instanceID)
+			if err != nil {
+				kc.logger().Error("cannot collect consumer status", errorFields(err))
+				continue
+			}
+
+			value, err := json.Marshal(status)
+			if err != nil {
+				kc.logger().Error("cannot encode consumer status", errorFields(err))
+				continue
+			}
+			err = kc.PublishWithOptions(kc.config.StatusTopic, value, PublishOptions{Key: []byte(instanceID)})
+			if err != nil {
+				kc.logger().Error("cannot publish consumer status", errorFields(err))
+			}
+		}
+	}
+}
+
+func (kc *Client) status(instanceID string) (*consumerStatus, error) {
+	committed, err := kc.CommittedOffsets()
+	if err != nil {
+		return nil, err
+	}
+
+	kc.consumerMu.RLock()
+	assigned := kc.Consumer.Subscriptions()
+	highWaterMarks := kc.Consumer.HighWaterMarks()
+	kc.consumerMu.RUnlock()
+
+	status := &consumerStatus{
+		Group:      kc.config.group(),
+		InstanceID: instanceID,
+		Partitions: make(map[string][]partitionStatus, len(assigned)),
+		Inflight:   atomic.LoadInt64(&kc.inflightCount),
+		ReportedAt: time.Now(),
+	}
+	for topic, partitions := range assigned {
+		for _, p := range partitions {
+			ps := partitionStatus{Partition: p, Committed: committed[topic][p]}
+			if hwm, ok := highWaterMarks[topic][p]; ok {
+				ps.Lag = hwm
+				if ps.Committed > 0 {
+					ps.Lag -= ps.Committed
+				}
+			}
+			status.Partitions[topic] = append(status.Partitions[topic], ps)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,118 @@
+package kafka
+
+import (
+	"errors"
+)
+
+// Subscribe : Adds topics, given by their name without prefix, to the
+// consumer. sarama-cluster can't change the topics of a running consumer,
+// so it is replaced by one for the new topic set, which rejoins the group.
+// Offsets marked so far are committed first; messages whose handlers are
+// still running during the swap may be delivered again.
+func (kc *Client) Subscribe(topics ...string) error {
+	return kc.resubscribe(func(current []string) []string {
+		for _, t := range topics {
+			if full := kc.config.topic(t); !containsTopic(current, full) {
+				current = append(current, full)
+			}
+		}
+		return current
+	})
+}
+
+// Unsubscribe : Removes topics from the consumer, as with Subscribe. At
+// least one topic must be left.
+func (kc *Client) Unsubscribe(topics ...string) error {
+	return kc.resubscribe(func(current []string) []string {
+		var remove []string
+		for _, t := range topics {
+			remove = append(remove, kc.config.topic(t))
+		}
+
+		var kept []string
+		for _, t := range current {
+			if !containsTopic(remove, t) {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	})
+}
+
+func (kc *Client) resubscribe(update func(current []string) []string) error {
+	kc.consumerMu.Lock()
+	defer kc.consumerMu.Unlock()
+
+	topics := update(append([]string(nil), kc.subscribed...))
+	if len(topics) == 0 {
+		return errors.New("kafka: unsubscribing would leave no topics")
+	}
+	if sameTopics(topics, kc.subscribed) {
+		return nil
+	}
+
+	if err := kc.swapConsumer(topics); err != nil {
+		// Consume recreates the consumer for the old topics, or returns
+		// ErrConsumerClosed
+		return err
+	}
+
+	kc.logger().Info("subscription changed", Fields{"from": kc.subscribed, "to": topics})
+	kc.subscribed = topics
+	return nil
+}
+
+// Replace the consumer with a new one for topics, committing what was
+// marked first. Consume picks up the new consumer when the messages channel
+// of the old one closes. Must be called with consumerMu held.
+func (kc *Client) swapConsumer(topics []string) error {
+	if err := kc.Consumer.CommitOffsets(); err != nil {
+		kc.logger().Error("failed to commit offsets before replacing consumer", errorFields(err))
+	}
+	if err := kc.Consumer.Close(); err != nil {
+		kc.logger().Error("failed to close consumer before replacing it", errorFields(err))
+	}
+
+	consumer, err := kc.config.createKafkaConsumer(kc.brokers, kc.tlsConfig, kc.config.group(), topics)
+	if err != nil {
+		return err
+	}
+	kc.Consumer = consumer
+	return nil
+}
+
+// The topics currently consumed, with their prefix
+func (kc *Client) subscriptions() []string {
+	kc.consumerMu.RLock()
+	defer kc.consumerMu.RUnlock()
+
+	return append([]string(nil), kc.subscribed...)
+}
+
+func (kc *Client) currentConsumer() GroupConsumer {
+	kc.consumerMu.RLock()
+	defer kc.consumerMu.RUnlock()
+
+	return kc.Consumer
+}
+
+func containsTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, t := range a {
+		if !containsTopic(b, t) {
+			return false
+		}
+	}
+	return true
+}
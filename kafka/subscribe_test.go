@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// A client subscribed to dev.orders whose consumers, once swapped, join
+// the group through broker
+func newSubscribeTestClient(t *testing.T, broker *sarama.MockBroker) (*Client, *mockConsumer) {
+	consumer := newMockConsumer()
+	kc := newTestClient(consumer)
+	kc.config.Prefix = "dev."
+	kc.config.ConsumerGroup = "print"
+	kc.config.NativeConsumerGroup = true
+	kc.config.SessionTimeout = 10 * time.Second
+	kc.config.RebalanceTimeout = 5 * time.Second
+	kc.config.RebalanceRetryBackoff = 10 * time.Millisecond
+	kc.config.MetricRegistry = metrics.NewRegistry()
+	kc.subscribed = []string{"dev.orders"}
+	if broker != nil {
+		kc.brokers = []string{broker.Addr()}
+	}
+	kc.tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	return kc, consumer
+}
+
+func TestSubscribeReplacesTheConsumer(t *testing.T) {
+	broker := newTLSMockBroker(t)
+	defer broker.Close()
+	// The group never gets a coordinator, so the new consumer keeps
+	// retrying to join until it is closed
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("dev.orders", 0, broker.BrokerID()).
+			SetLeader("dev.payments", 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetError(sarama.CoordinatorGroup, "print", sarama.ErrConsumerCoordinatorNotAvailable),
+	})
+	kc, old := newSubscribeTestClient(t, broker)
+
+	if err := kc.Subscribe("payments", "orders"); err != nil {
+		t.Fatalf("Subscribe returned %v", err)
+	}
+	defer kc.currentConsumer().Close()
+
+	if !old.closed {
+		t.Fatal("old consumer left open")
+	}
+	if kc.currentConsumer() == GroupConsumer(old) {
+		t.Fatal("consumer not replaced")
+	}
+	if got := kc.subscriptions(); !reflect.DeepEqual(got, []string{"dev.orders", "dev.payments"}) {
+		t.Fatalf("subscribed to %q", got)
+	}
+}
+
+func TestSubscribingToCurrentTopicsKeepsTheConsumer(t *testing.T) {
+	kc, consumer := newSubscribeTestClient(t, nil)
+
+	if err := kc.Subscribe("orders"); err != nil {
+		t.Fatalf("Subscribe returned %v", err)
+	}
+	if err := kc.Unsubscribe("payments"); err != nil {
+		t.Fatalf("Unsubscribe returned %v", err)
+	}
+	if consumer.closed || kc.currentConsumer() != GroupConsumer(consumer) {
+		t.Fatal("consumer replaced though the topics didn't change")
+	}
+}
+
+func TestUnsubscribeKeepsAtLeastOneTopic(t *testing.T) {
+	kc, consumer := newSubscribeTestClient(t, nil)
+
+	if err := kc.Unsubscribe("orders"); err == nil {
+		t.Fatal("unsubscribed from the last topic")
+	}
+	if consumer.closed || !reflect.DeepEqual(kc.subscriptions(), []string{"dev.orders"}) {
+		t.Fatal("subscription changed by a rejected Unsubscribe")
+	}
+}
+
+func TestFailedSubscribeKeepsTheTopics(t *testing.T) {
+	// No brokers, so the new consumer can't be created
+	kc, consumer := newSubscribeTestClient(t, nil)
+
+	if err := kc.Subscribe("payments"); err == nil {
+		t.Fatal("Subscribe succeeded without brokers")
+	}
+	// Consume recreates a consumer for these once it sees the old one closed
+	if !consumer.closed {
+		t.Fatal("old consumer left open")
+	}
+	if got := kc.subscriptions(); !reflect.DeepEqual(got, []string{"dev.orders"}) {
+		t.Fatalf("subscribed to %q after a failed Subscribe", got)
+	}
+}
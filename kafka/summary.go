@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// Summary : The effective configuration, for logs and the debug endpoint.
+// Cert material is only reported as present or absent, and the SASL
+// password as set or unset.
+func (kc *Config) Summary() map[string]interface{} {
+	workers := 0
+	if kc.OrderedByKey {
+		workers = kc.Workers
+	}
+
+	// Empty when the URL is invalid, which Validate reports
+	brokers, _ := kc.BrokerAddresses()
+	// The default when the version is invalid, which Validate reports too
+	version, _ := kc.kafkaVersion(sarama.MinVersion)
+	// As the async producer is created, sarama's default if that fails
+	compression := sarama.NewConfig().Producer.Compression
+	if producer, err := kc.producerConfig(nil); err == nil {
+		compression = producer.Producer.Compression
+	}
+	password := "unset"
+	if kc.Password != "" {
+		password = "set"
+	}
+
+	return map[string]interface{}{
+		"brokers":          brokers,
+		"topics":           kc.topics(),
+		"group":            kc.group(),
+		"version":          version.String(),
+		"tls":              true,
+		"tls_min_version":  kc.TLSMinVersion,
+		"trusted_cert":     present(kc.TrustedCert, kc.TrustedCertFile),
+		"client_cert":      present(kc.ClientCert, kc.ClientCertFile),
+		"client_cert_key":  present(kc.ClientCertKey, kc.ClientCertKeyFile),
+		"client_p12":       present("", kc.ClientP12File),
+		"cert_auto_reload": kc.CertAutoReload,
+		"sasl":             kc.SASLMechanism,
+		"sasl_user":        kc.Username,
+		"sasl_password":    password,
+		"offset_reset":     kc.OffsetReset,
+		"isolation_level":  kc.IsolationLevel,
+		"strategy":         kc.PartitionStrategy,
+		"native_group":     kc.NativeConsumerGroup,
+		"ordered_by_key":   kc.OrderedByKey,
+		"workers":          workers,
+		"compression":      compression.String(),
+		"idempotent":       kc.ProducerIdempotent,
+		"on_error":         kc.OnPermanentError,
+		"max_retries":      kc.MaxRetries,
+		"dead_letter":      kc.DeadLetterTopic,
+		"per_topic_dlq":    kc.DeadLetterPerTopic,
+		"dedup":            kc.DedupCacheSize > 0,
+		"spool":            kc.EnableSpool,
+		"debug":            kc.Debug,
+		"encrypted_topics": kc.EncryptedTopics,
+	}
+}
+
+// Log the summary as a single entry, with a field per setting
+func (kc *Config) logSummary() {
+	kc.logger().Info("kafka config", Fields(kc.Summary()))
+}
+
+func present(inline, file string) string {
+	switch {
+	case file != "":
+		return "file"
+	case inline != "":
+		return "present"
+	default:
+		return "absent"
+	}
+}
@@ -0,0 +1,43 @@
+package kafka
+
+import "testing"
+
+func TestSummaryRedactsTheSASLPassword(t *testing.T) {
+	cfg := &Config{
+		URL:           "kafka+ssl://broker:9096",
+		SASLMechanism: "SCRAM-SHA-512",
+		Username:      "print-service",
+		Password:      "hunter2",
+		FlushMessages: 1,
+	}
+
+	summary := cfg.Summary()
+	if summary["sasl"] != "SCRAM-SHA-512" || summary["sasl_user"] != "print-service" {
+		t.Fatalf("summary has sasl %v and user %v", summary["sasl"], summary["sasl_user"])
+	}
+	if summary["sasl_password"] != "set" {
+		t.Fatalf("sasl_password is %v, want set", summary["sasl_password"])
+	}
+	for k, v := range summary {
+		if v == "hunter2" {
+			t.Fatalf("%s reveals the password", k)
+		}
+	}
+
+	cfg.Password = ""
+	if got := cfg.Summary()["sasl_password"]; got != "unset" {
+		t.Fatalf("sasl_password is %v, want unset", got)
+	}
+}
+
+func TestSummaryReportsTheProducerCompression(t *testing.T) {
+	cfg := &Config{URL: "kafka+ssl://broker:9096", FlushMessages: 1}
+
+	want, err := cfg.producerConfig(nil)
+	if err != nil {
+		t.Fatalf("producerConfig returned %v", err)
+	}
+	if got := cfg.Summary()["compression"]; got != want.Producer.Compression.String() {
+		t.Fatalf("compression is %v, want %v", got, want.Producer.Compression)
+	}
+}
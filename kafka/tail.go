@@ -0,0 +1,213 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Output formats of Tail
+const (
+	// TailFormatPretty prints every message over several lines, with its
+	// JSON value indented
+	TailFormatPretty = "pretty"
+	// TailFormatJSON prints every message as one line of JSON, for piping
+	// into jq
+	TailFormatJSON = "json"
+)
+
+// A message as printed by Tail
+type tailRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Value     json.RawMessage   `json:"value"`
+	// Why the value is shown as received
+	Error string `json:"error,omitempty"`
+}
+
+// Tail : Prints the messages published to topics, given by their name
+// without prefix, from now on until ctx is cancelled, with their key,
+// headers, partition and offset, for operators who would otherwise need
+// their own tooling and certs. Values are decrypted and decoded as they
+// would be for the handler, and redacted as in the receipt log. Every
+// partition is read directly from its newest offset, so no consumer group
+// is joined and nothing is committed; the client doesn't need to be
+// connected, and shouldn't be, as Connect joins the service's group.
+func (kc *Client) Tail(ctx context.Context, w io.Writer, topics []string, format string) error {
+	if format != TailFormatPretty && format != TailFormatJSON {
+		return fmt.Errorf("kafka: tail format must be %s or %s, got %q", TailFormatPretty, TailFormatJSON, format)
+	}
+	if kc.config == nil {
+		kc.config = LoadConfig()
+	}
+	cfg := kc.config
+
+	tlsConfig, err := cfg.createTLSConfig()
+	if err != nil {
+		return err
+	}
+	brokers, err := cfg.BrokerAddresses()
+	if err != nil {
+		return err
+	}
+	if cfg.EncryptionKey != "" {
+		if kc.aead, err = newAEAD(cfg.EncryptionKey); err != nil {
+			return err
+		}
+	}
+	if kc.redactor == nil && len(cfg.RedactFields) > 0 {
+		kc.redactor = JSONFieldRedactor(cfg.RedactFields)
+	}
+
+	config := sarama.NewConfig()
+	config.Net.TLS.Config = tlsConfig
+	config.Net.TLS.Enable = true
+	cfg.applySASL(config)
+	if config.Version, err = cfg.kafkaVersion(config.Version); err != nil {
+		return err
+	}
+	config.Consumer.IsolationLevel = cfg.isolationLevel()
+
+	consumer, err := sarama.NewConsumer(brokers, config)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	// Stops the partition readers when Tail returns
+	stop := make(chan struct{})
+	defer close(stop)
+
+	messages := make(chan *sarama.ConsumerMessage)
+	for _, name := range topics {
+		topic := cfg.topic(name)
+		partitions, err := consumer.Partitions(topic)
+		if err != nil {
+			return fmt.Errorf("kafka: tail %s: %v", topic, err)
+		}
+		for _, partition := range partitions {
+			pc, err := consumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return fmt.Errorf("kafka: tail %s/%d: %v", topic, partition, err)
+			}
+			defer pc.AsyncClose()
+
+			go func() {
+				for msg := range pc.Messages() {
+					select {
+					case messages <- msg:
+					case <-stop:
+						return
+					}
+				}
+			}()
+		}
+	}
+	fmt.Fprintf(w, "Tailing %s, press Ctrl+C to stop\n", strings.Join(topics, ", "))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			if err := writeTailRecord(w, kc.tailRecord(msg), format); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Decrypt, decode and redact a message for Tail, keeping the raw value
+// when that fails
+func (kc *Client) tailRecord(msg *sarama.ConsumerMessage) tailRecord {
+	rec := tailRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       string(msg.Key),
+	}
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		if rec.Headers == nil {
+			rec.Headers = make(map[string]string, len(msg.Headers))
+		}
+		rec.Headers[string(h.Key)] = string(h.Value)
+	}
+
+	value := msg.Value
+	if kc.encrypted(msg.Topic) && value != nil {
+		if plain, err := kc.open(msg); err != nil {
+			rec.Error = fmt.Sprintf("decrypting: %v", err)
+		} else {
+			value = plain
+		}
+	}
+	name, _ := kc.config.logicalTopic(msg.Topic)
+	if d := kc.decoders[name]; d != nil && rec.Error == "" {
+		if decoded, err := d(value); err != nil {
+			rec.Error = fmt.Sprintf("decoding: %v", err)
+		} else {
+			value = decoded
+		}
+	}
+
+	switch {
+	case value == nil:
+		rec.Value = json.RawMessage("null")
+	case json.Valid(value):
+		rec.Value = kc.redact(value)
+	default:
+		rec.Value, _ = json.Marshal(string(value))
+	}
+	return rec
+}
+
+func writeTailRecord(w io.Writer, rec tailRecord, format string) error {
+	if format == TailFormatJSON {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", line)
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s/%d/%d", rec.Topic, rec.Partition, rec.Offset)
+	if !rec.Timestamp.IsZero() {
+		fmt.Fprintf(&b, " at %s", rec.Timestamp.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&b, "\nKey: %s\n", rec.Key)
+	keys := make([]string, 0, len(rec.Headers))
+	for k := range rec.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "Header %s: %s\n", k, rec.Headers[k])
+	}
+	if rec.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", rec.Error)
+	}
+	value, err := json.MarshalIndent(rec.Value, "", "  ")
+	if err != nil {
+		value = rec.Value
+	}
+	fmt.Fprintf(&b, "%s\n", value)
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,26 @@
+package kafka
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadP12WithCAChain(t *testing.T) {
+	cert, err := loadP12("testdata/client-chain.p12", "secret")
+	if err != nil {
+		t.Fatalf("loadP12 returned %v", err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("got %d certificates, want the client cert and its CA", len(cert.Certificate))
+	}
+	if cn := cert.Leaf.Subject.CommonName; cn != "print-service" {
+		t.Fatalf("leaf is %q, want the client cert print-service", cn)
+	}
+}
+
+func TestLoadP12WrongPassword(t *testing.T) {
+	_, err := loadP12("testdata/client-chain.p12", "wrong")
+	if err == nil || !strings.Contains(err.Error(), "KAFKA_CLIENT_P12_PASSWORD") {
+		t.Fatalf("loadP12 returned %v, want a wrong password error", err)
+	}
+}
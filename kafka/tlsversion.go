@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// The tls version for Config.TLSMinVersion
+func (kc *Config) tlsMinVersion() (uint16, error) {
+	v, ok := tlsVersions[kc.TLSMinVersion]
+	if !ok {
+		return 0, fmt.Errorf("KAFKA_TLS_MIN_VERSION must be 1.0, 1.1, 1.2 or 1.3, got %q", kc.TLSMinVersion)
+	}
+	return v, nil
+}
+
+// The ids of Config.TLSCipherSuites, nil when unset so Go picks them.
+// Suites Go considers insecure are rejected along with unknown names.
+func (kc *Config) tlsCipherSuites() ([]uint16, error) {
+	if len(kc.TLSCipherSuites) == 0 {
+		return nil, nil
+	}
+
+	secure := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		secure[s.Name] = s.ID
+	}
+	insecure := make(map[string]bool)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	var ids []uint16
+	for _, name := range kc.TLSCipherSuites {
+		id, ok := secure[name]
+		switch {
+		case ok:
+			ids = append(ids, id)
+		case insecure[name]:
+			return nil, fmt.Errorf("KAFKA_TLS_CIPHER_SUITES: %s is insecure", name)
+		default:
+			return nil, fmt.Errorf("KAFKA_TLS_CIPHER_SUITES: unknown cipher suite %q", name)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+)
+
+// SpanHooks : Hooks creating the spans of published and consumed
+// messages, set with WithSpanHooks. This isn't OpenTelemetry
+// instrumentation: the package has no tracer or propagator of its own and
+// doesn't depend on OpenTelemetry, so nothing is traced unless the
+// application implements these with its tracer, see the README.
+type SpanHooks interface {
+	// StartPublish : Starts a producer span for a message published to
+	// topic, a child of the span in ctx, and injects its context into
+	// headers. Headers is nil when the message can't carry headers, before
+	// KAFKA_VERSION 0.11.0. The returned function ends the span.
+	StartPublish(ctx context.Context, topic string, headers map[string]string) (end func(err error))
+	// StartConsume : Starts a consumer span for a consumed message, linked
+	// to the producer span whose context is extracted from msg.Headers, and
+	// returns the context the handler runs with. The returned function ends
+	// the span with the result of the handler, after its retries.
+	StartConsume(ctx context.Context, msg Message) (context.Context, func(err error))
+}
+
+// ClientOption : Optional setting of NewClient
+type ClientOption func(*Client)
+
+// WithSpanHooks : Runs hooks around every published and consumed
+// message. Published messages carry whatever context StartPublish put in
+// their headers, and handlers run with the ctx of StartConsume, so ctx can
+// be passed on to PublishOptions.Context to trace what they publish too.
+func WithSpanHooks(hooks SpanHooks) ClientOption {
+	return func(kc *Client) {
+		kc.spanHooks = hooks
+	}
+}
+
+func endSpanNoop(error) {}
+
+// Start the producer span of a message, carrying its context in the
+// headers of opts when the message can have headers
+func (kc *Client) startPublishSpan(ctx context.Context, topic string, opts PublishOptions, canCarry bool) (PublishOptions, func(error)) {
+	if kc.spanHooks == nil {
+		return opts, endSpanNoop
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// An invalid version was refused by Validate before Connect
+	version, err := kc.config.kafkaVersion(sarama.MinVersion)
+	if !canCarry || err != nil || !version.IsAtLeast(sarama.V0_11_0_0) {
+		return opts, kc.spanHooks.StartPublish(ctx, topic, nil)
+	}
+
+	headers := make(map[string]string, len(opts.Headers)+2)
+	for k, v := range opts.Headers {
+		headers[k] = v
+	}
+	end := kc.spanHooks.StartPublish(ctx, topic, headers)
+	opts.Headers = headers
+	return opts, end
+}
+
+// Start the consumer span of a message
+func (kc *Client) startConsumeSpan(ctx context.Context, msg Message) (context.Context, func(error)) {
+	if kc.spanHooks == nil {
+		return ctx, endSpanNoop
+	}
+	return kc.spanHooks.StartConsume(ctx, msg)
+}
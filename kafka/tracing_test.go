@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// Hooks publishing a fixed traceparent header and recording the
+// consumer spans
+type recordingTracer struct {
+	mu       sync.Mutex
+	linked   []string
+	consumed []error
+}
+
+func (t *recordingTracer) StartPublish(ctx context.Context, topic string, headers map[string]string) func(error) {
+	if headers != nil {
+		headers["traceparent"] = "00-trace-span-01"
+	}
+	return func(error) {}
+}
+
+func (t *recordingTracer) StartConsume(ctx context.Context, msg Message) (context.Context, func(error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.linked = append(t.linked, string(msg.Headers["traceparent"]))
+	return ctx, func(err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.consumed = append(t.consumed, err)
+	}
+}
+
+func TestPublishedMessagesCarryTheTraceContext(t *testing.T) {
+	kc := NewClient(&Config{Version: "2.1.0", Logger: discardLogger{}}, WithSpanHooks(&recordingTracer{}))
+	producer := &recordingProducer{}
+	kc.SetProducer(producer)
+
+	if err := kc.PublishWithOptions("orders", []byte("{}"), PublishOptions{}); err != nil {
+		t.Fatalf("PublishWithOptions returned %v", err)
+	}
+	msgs := producer.published()
+	if len(msgs) != 1 || msgs[0].opts.Headers["traceparent"] != "00-trace-span-01" {
+		t.Fatalf("published %+v, want a traceparent header", msgs)
+	}
+}
+
+func TestConsumedMessagesStartASpanFromTheirHeaders(t *testing.T) {
+	tracer := &recordingTracer{}
+	kc := newTestClient(newMockConsumer())
+	kc.spanHooks = tracer
+
+	msg := &sarama.ConsumerMessage{
+		Topic:   "orders",
+		Headers: []*sarama.RecordHeader{{Key: []byte("traceparent"), Value: []byte("00-trace-span-01")}},
+	}
+	failure := errors.New("printer offline")
+	kc.Process(context.Background(), msg, func(context.Context, Message) error { return failure })
+
+	if len(tracer.linked) != 1 || tracer.linked[0] != "00-trace-span-01" {
+		t.Fatalf("consumer spans linked to %q", tracer.linked)
+	}
+	if len(tracer.consumed) != 1 || tracer.consumed[0] != failure {
+		t.Fatalf("consumer spans ended with %v, want the handler's error", tracer.consumed)
+	}
+}
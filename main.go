@@ -1,19 +1,49 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Sapaad/print-microservice/kafka"
-	"github.com/Shopify/sarama"
 	"github.com/joho/godotenv"
 )
 
+var (
+	produceTopic = flag.String("produce", "", "Produce a single test message to this topic and exit")
+	produceFile  = flag.String("file", "", "File containing the payload for -produce")
+	check        = flag.Bool("check", false, "Check broker connectivity and credentials, then exit")
+	checkTimeout = flag.Duration("check-timeout", 30*time.Second, "Give up on -check after this long")
+	debugAddr    = flag.String("debug-addr", "", "Serve the debug endpoint on this address, e.g. :8080")
+	metricsAddr  = flag.String("metrics-addr", "", "Serve Prometheus metrics on /metrics at this address, e.g. :9090")
+	healthAddr   = flag.String("health-addr", "", "Serve /healthz and /readyz probes at this address, e.g. :8081")
+	selfTest     = flag.Bool("self-test", false, "Produce and consume a sentinel message on the health topic, then exit")
+	tail         = flag.String("tail", "", "Print the messages of these comma separated topics as they arrive, until interrupted")
+	tailFormat   = flag.String("tail-format", kafka.TailFormatPretty, "Output of -tail: pretty or json")
+)
+
+// Load the .env file, or the one at DOTENV_PATH, when there is one. In
+// containers and CI the config comes from the environment alone, and
+// Config.Validate reports whatever is missing.
 func init() {
-	err := godotenv.Load()
+	path := os.Getenv("DOTENV_PATH")
+	if path == "" {
+		path = ".env"
+	}
+
+	err := godotenv.Load(path)
+	if os.IsNotExist(err) {
+		log.Printf("No %s file, using the environment only", path)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		log.Fatal("Error loading .env file")
@@ -21,43 +51,154 @@ func init() {
 }
 
 func main() {
+	flag.Parse()
+
+	if *check {
+		ctx, cancel := context.WithTimeout(context.Background(), *checkTimeout)
+		err := kafka.Ping(ctx, kafka.LoadConfig())
+		cancel()
+		if err != nil {
+			log.Fatal("Connectivity check failed: ", err)
+		}
+		fmt.Println("All brokers are reachable")
+		return
+	}
+
+	if *tail != "" {
+		tailTopics(*tail, *tailFormat)
+		return
+	}
+
+	// One-off commands don't Connect, which would join the consumer group
 	kafkaClient := kafka.Client{}
-	kafkaClient.Connect()
+	if *selfTest {
+		if err := kafkaClient.SelfTest(context.Background()); err != nil {
+			log.Fatal("Self test failed: ", err)
+		}
+		fmt.Println("Self test passed")
+		return
+	}
+
+	if *produceTopic != "" {
+		err := produceTestMessage(&kafkaClient, *produceTopic, *produceFile)
+		kafkaClient.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := kafkaClient.Connect(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Endpoints given the same address share a server
+	muxes := make(map[string]*http.ServeMux)
+	serve := func(addr, path string, h http.Handler) {
+		if addr == "" {
+			return
+		}
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		muxes[addr].Handle(path, h)
+	}
+	serve(*debugAddr, "/debug/kafka", kafkaClient.DebugHandler())
+	serve(*metricsAddr, "/metrics", kafka.MetricsHandler())
+	serve(*healthAddr, "/healthz", kafkaClient.HealthHandler())
+	serve(*healthAddr, "/readyz", kafkaClient.ReadyHandler())
+	for addr, mux := range muxes {
+		go func(addr string, mux *http.ServeMux) {
+			log.Println(http.ListenAndServe(addr, mux))
+		}(addr, mux)
+	}
 
-	// Trap SIGTERM
+	// Trap SIGTERM and Ctrl + C. Shutdown stops consuming and waits for the
+	// handlers in flight, up to KAFKA_SHUTDOWN_TIMEOUT, rather than
+	// cancelling the ctx they run with. A second signal stops waiting.
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	shutdown := make(chan error, 1)
 	go func() {
 		<-c
-		// Ctrl + C trap
+		close(stopping)
 		fmt.Println("Closing consumer and producer...")
-		kafkaClient.Producer.Close()
-		kafkaClient.Consumer.Close()
-		os.Exit(1)
+		shutdownCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-c
+			cancel()
+		}()
+		shutdown <- kafkaClient.Shutdown(shutdownCtx)
 	}()
 
-	go kafkaClient.ShowErrors()
-	go kafkaClient.ShowNotifications()
-	defer kafkaClient.Producer.Close()
-	defer kafkaClient.Consumer.Close()
+	// Handlers of other topics are registered the same way
+	kafkaClient.HandleTopic("order_events", processOrderEvent)
 
 	fmt.Println("Listening to messages...")
-	for message := range kafkaClient.Consumer.Messages() {
-		if message != nil {
-			go processMessage(message, kafkaClient)
+	if err := kafkaClient.Consume(context.Background(), kafkaClient.Route(nil)); err != nil {
+		log.Println(err)
+	}
+
+	select {
+	case <-stopping:
+		err := <-shutdown
+		if err != nil {
+			log.Println(err)
+		}
+	default:
+		// Stopped on its own, e.g. after KAFKA_MAX_MESSAGES
+		fmt.Println("Closing consumer and producer...")
+		if err := kafkaClient.Close(); err != nil {
+			log.Println(err)
 		}
 	}
 }
 
-func processMessage(msg *sarama.ConsumerMessage, kc kafka.Client) {
-	message := kafka.Message{
-		Partition: msg.Partition,
-		Offset:    msg.Offset,
-		Topic:     msg.Topic,
-		Value:     string(msg.Value),
+// Receipt of each message is logged by the client, the actual print job
+// handling goes here
+func processOrderEvent(ctx context.Context, message kafka.Message) error {
+	return nil
+}
+
+// Tail mode: print the messages of the topics until interrupted, without
+// connecting the client, which would join the consumer group
+func tailTopics(topics string, format string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	kafkaClient := kafka.Client{}
+	if err := kafkaClient.Tail(ctx, os.Stdout, strings.Split(topics, ","), format); err != nil {
+		log.Fatal("Tail failed: ", err)
+	}
+}
+
+// Smoke test mode: produce one message synchronously and print where it
+// landed. Errors are returned, as log.Fatal would exit before the client
+// is closed.
+func produceTestMessage(kc *kafka.Client, topic string, file string) error {
+	payload := []byte("{}")
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("cannot read payload file: %v", err)
+		}
+		payload = data
+	}
+
+	partition, offset, err := kc.ProduceOnce(topic, payload)
+	if err != nil {
+		return fmt.Errorf("failed to produce test message: %v", err)
 	}
 
 	fmt.Printf(
-		"Message Received:\nTopic: %s\nPartition: %d\nOffset: %d\n",
-		message.Topic, message.Partition, message.Offset)
+		"Message Produced:\nTopic: %s\nPartition: %d\nOffset: %d\n",
+		topic, partition, offset)
+	return nil
 }